@@ -4,18 +4,23 @@ import (
 	"bufio"
 	"bytes"
 	"context"
+	"encoding/base64"
+	"encoding/binary"
 	"encoding/json"
 	"fmt"
 	"io"
+	"math"
 	"net/http"
 	"strings"
+	"time"
 )
 
 type GenerationConfig struct {
-	Temperature  *float32 `json:"temperature,omitempty"`
-	TopP         *float32 `json:"top_p,omitempty"`
-	TopK         *float32 `json:"top_k,omitempty"`
-	OutputLength *int32   `json:"output_length,omitempty"`
+	Temperature   *float32 `json:"temperature,omitempty"`
+	TopP          *float32 `json:"top_p,omitempty"`
+	TopK          *float32 `json:"top_k,omitempty"`
+	OutputLength  *int32   `json:"output_length,omitempty"`
+	StopSequences []string `json:"stop_sequences,omitempty"`
 }
 
 type GenerateRequest struct {
@@ -24,46 +29,172 @@ type GenerateRequest struct {
 	Stream    bool              `json:"stream"`
 	Config    *GenerationConfig `json:"config,omitempty"`
 	Images    [][]byte          `json:"images,omitempty"`
+
+	// Timeouts in seconds, overriding the server defaults.
+	Timeout           float64 `json:"timeout,omitempty"`
+	FirstTokenTimeout float64 `json:"first_token_timeout,omitempty"`
+	IdleTimeout       float64 `json:"idle_timeout,omitempty"`
+
+	// Hedge opts in to a second, racing upstream attempt when the first is
+	// slow to respond.
+	Hedge bool `json:"hedge,omitempty"`
 }
 
 type Result struct {
 	Text        string
 	Err         error
 	IsKeepAlive bool
+	Metadata    *Metadata
+	// TaskID identifies the task on the server, for Status and Cancel.
+	TaskID string
+}
+
+// Metadata describes how the server carried out a task. It is delivered on
+// the last Result of a task.
+type Metadata struct {
+	Attempts int `json:"attempts"`
+}
+
+// TaskError is an error reported by the server for a task.
+type TaskError struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *TaskError) Error() string {
+	return fmt.Sprintf("%s: %s", e.Code, e.Message)
+}
+
+// TaskStatus reports where a task is in its lifecycle. State is one of
+// queued, running, completed, failed or canceled.
+type TaskStatus struct {
+	TaskID     string     `json:"task_id"`
+	Model      string     `json:"model"`
+	State      string     `json:"state"`
+	Error      *TaskError `json:"error,omitempty"`
+	EnqueuedAt time.Time  `json:"enqueued_at"`
+	StartedAt  *time.Time `json:"started_at,omitempty"`
+	FinishedAt *time.Time `json:"finished_at,omitempty"`
+}
+
+// EmbedRequest asks for the embeddings of Inputs.
+type EmbedRequest struct {
+	ModelCode string
+	Inputs    []string
+	// Dimensions truncates the vectors, for models that support it.
+	Dimensions int32
+}
+
+// Embeddings holds one vector per input of an EmbedRequest, in order.
+type Embeddings struct {
+	Vectors     [][]float32
+	InputTokens int
+}
+
+// taskResult is the wire format of a /generate response or stream event.
+type taskResult struct {
+	Text     string     `json:"text"`
+	Err      *TaskError `json:"error"`
+	Metadata *Metadata  `json:"metadata"`
+}
+
+func (r *taskResult) toResult(taskID string) Result {
+	res := Result{Text: r.Text, Metadata: r.Metadata, TaskID: taskID}
+	if r.Err != nil {
+		res.Err = r.Err
+	}
+	return res
 }
 
 type Client interface {
 	GenerateTask(ctx context.Context, req *GenerateRequest) (<-chan Result, error)
 	ListModels(ctx context.Context) ([]string, error)
+	// Status returns the status of a task that is pending or finished
+	// recently.
+	Status(ctx context.Context, taskID string) (*TaskStatus, error)
+	// Cancel stops a pending task. The generation it belongs to ends with
+	// its last result at once.
+	Cancel(ctx context.Context, taskID string) (*TaskStatus, error)
+	// Embed returns the embeddings of the inputs of req.
+	Embed(ctx context.Context, req *EmbedRequest) (*Embeddings, error)
 	Close() error
 }
 
 type httpClient struct {
 	baseURL    string
+	apiKey     string
 	httpClient *http.Client
 }
 
-func New(addr string) Client {
+// Option configures a Client made by New.
+type Option func(*options)
+
+type options struct {
+	webSocket bool
+	apiKey    string
+}
+
+// WithWebSocket makes the client run its generation tasks over a single
+// WebSocket connection to the server's /ws endpoint, dialed on first use,
+// instead of one HTTP request per task. The other calls still use HTTP.
+func WithWebSocket() Option {
+	return func(o *options) { o.webSocket = true }
+}
+
+// WithAPIKey authenticates the client's requests with a tenant API key, for
+// servers that configure server.tenants.
+func WithAPIKey(key string) Option {
+	return func(o *options) { o.apiKey = key }
+}
+
+func New(addr string, opts ...Option) Client {
+	var o options
+	for _, opt := range opts {
+		opt(&o)
+	}
+
 	if !strings.HasPrefix(addr, "http") {
 		addr = "http://" + addr
 	}
-	return &httpClient{
+	c := &httpClient{
 		baseURL:    strings.TrimSuffix(addr, "/"),
+		apiKey:     o.apiKey,
 		httpClient: &http.Client{},
 	}
+	if o.webSocket {
+		return newWSClient(c)
+	}
+	return c
 }
 
 func (c *httpClient) Close() error {
 	return nil
 }
 
+// do sends req with the client's API key, if it has one.
+func (c *httpClient) do(req *http.Request) (*http.Response, error) {
+	if c.apiKey != "" {
+		req.Header.Set("Authorization", "Bearer "+c.apiKey)
+	}
+	return c.httpClient.Do(req)
+}
+
+// authHeader returns the header carrying the client's API key for the
+// WebSocket handshake, or nil if it has none.
+func (c *httpClient) authHeader() http.Header {
+	if c.apiKey == "" {
+		return nil
+	}
+	return http.Header{"Authorization": {"Bearer " + c.apiKey}}
+}
+
 func (c *httpClient) ListModels(ctx context.Context) ([]string, error) {
 	req, err := http.NewRequestWithContext(ctx, "GET", c.baseURL+"/models", nil)
 	if err != nil {
 		return nil, err
 	}
 
-	resp, err := c.httpClient.Do(req)
+	resp, err := c.do(req)
 	if err != nil {
 		return nil, err
 	}
@@ -83,6 +214,132 @@ func (c *httpClient) ListModels(ctx context.Context) ([]string, error) {
 	return result.Models, nil
 }
 
+func (c *httpClient) Status(ctx context.Context, taskID string) (*TaskStatus, error) {
+	return c.taskRequest(ctx, "GET", "/tasks/"+taskID, http.StatusOK)
+}
+
+func (c *httpClient) Cancel(ctx context.Context, taskID string) (*TaskStatus, error) {
+	return c.taskRequest(ctx, "POST", "/tasks/"+taskID+"/cancel", http.StatusAccepted)
+}
+
+// taskRequest calls a task endpoint that answers with the task's status.
+func (c *httpClient) taskRequest(ctx context.Context, method, path string, want int) (*TaskStatus, error) {
+	req, err := http.NewRequestWithContext(ctx, method, c.baseURL+path, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := c.do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case want:
+	case http.StatusNotFound:
+		return nil, fmt.Errorf("task not found")
+	case http.StatusConflict:
+		var status TaskStatus
+		if err := json.NewDecoder(resp.Body).Decode(&status); err != nil {
+			return nil, err
+		}
+		return &status, fmt.Errorf("task already %s", status.State)
+	default:
+		return nil, fmt.Errorf("unexpected status: %d", resp.StatusCode)
+	}
+
+	var status TaskStatus
+	if err := json.NewDecoder(resp.Body).Decode(&status); err != nil {
+		return nil, err
+	}
+	return &status, nil
+}
+
+func (c *httpClient) Embed(ctx context.Context, req *EmbedRequest) (*Embeddings, error) {
+	body, err := json.Marshal(map[string]any{
+		"model":           req.ModelCode,
+		"input":           req.Inputs,
+		"dimensions":      req.Dimensions,
+		"encoding_format": "base64",
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	httpReq, err := http.NewRequestWithContext(ctx, "POST", c.baseURL+"/v1/embeddings", bytes.NewBuffer(body))
+	if err != nil {
+		return nil, err
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+
+	resp, err := c.do(httpReq)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		var errResp struct {
+			Error struct {
+				Message string `json:"message"`
+				Type    string `json:"type"`
+				Code    string `json:"code"`
+			} `json:"error"`
+		}
+		if json.NewDecoder(resp.Body).Decode(&errResp) != nil || errResp.Error.Message == "" {
+			return nil, fmt.Errorf("unexpected status: %d", resp.StatusCode)
+		}
+		code := errResp.Error.Code
+		if code == "" {
+			code = errResp.Error.Type
+		}
+		return nil, &TaskError{Code: code, Message: errResp.Error.Message}
+	}
+
+	var result struct {
+		Data []struct {
+			Embedding string `json:"embedding"`
+			Index     int    `json:"index"`
+		} `json:"data"`
+		Usage struct {
+			PromptTokens int `json:"prompt_tokens"`
+		} `json:"usage"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, err
+	}
+
+	out := &Embeddings{Vectors: make([][]float32, len(req.Inputs)), InputTokens: result.Usage.PromptTokens}
+	for _, d := range result.Data {
+		if d.Index < 0 || d.Index >= len(out.Vectors) {
+			return nil, fmt.Errorf("embedding index %d out of range", d.Index)
+		}
+		vec, err := decodeVector(d.Embedding)
+		if err != nil {
+			return nil, err
+		}
+		out.Vectors[d.Index] = vec
+	}
+	return out, nil
+}
+
+// decodeVector decodes a base64 embedding of little-endian float32s.
+func decodeVector(s string) ([]float32, error) {
+	data, err := base64.StdEncoding.DecodeString(s)
+	if err != nil {
+		return nil, fmt.Errorf("invalid embedding: %w", err)
+	}
+	if len(data)%4 != 0 {
+		return nil, fmt.Errorf("invalid embedding: %d bytes", len(data))
+	}
+	vec := make([]float32, len(data)/4)
+	for i := range vec {
+		vec[i] = math.Float32frombits(binary.LittleEndian.Uint32(data[4*i:]))
+	}
+	return vec, nil
+}
+
 func (c *httpClient) GenerateTask(ctx context.Context, req *GenerateRequest) (<-chan Result, error) {
 	body, err := json.Marshal(req)
 	if err != nil {
@@ -95,41 +352,40 @@ func (c *httpClient) GenerateTask(ctx context.Context, req *GenerateRequest) (<-
 	}
 	httpReq.Header.Set("Content-Type", "application/json")
 
-	resp, err := c.httpClient.Do(httpReq)
+	resp, err := c.do(httpReq)
 	if err != nil {
 		return nil, err
 	}
 
-	if resp.StatusCode != http.StatusOK {
+	if resp.StatusCode != http.StatusOK && req.Stream {
 		resp.Body.Close()
 		return nil, fmt.Errorf("unexpected status: %d", resp.StatusCode)
 	}
 
 	resultChan := make(chan Result)
+	taskID := resp.Header.Get("X-Task-ID")
 	if req.Stream {
-		go c.handleStream(resp.Body, resultChan)
+		go c.handleStream(resp.Body, taskID, resultChan)
 	} else {
-		go c.handleUnary(resp.Body, resultChan)
+		go c.handleUnary(resp.Body, taskID, resultChan)
 	}
 
 	return resultChan, nil
 }
 
-func (c *httpClient) handleUnary(body io.ReadCloser, ch chan<- Result) {
+func (c *httpClient) handleUnary(body io.ReadCloser, taskID string, ch chan<- Result) {
 	defer body.Close()
 	defer close(ch)
 
-	var res struct {
-		Text string `json:"text"`
-	}
+	var res taskResult
 	if err := json.NewDecoder(body).Decode(&res); err != nil {
-		ch <- Result{Err: err}
+		ch <- Result{Err: err, TaskID: taskID}
 		return
 	}
-	ch <- Result{Text: res.Text}
+	ch <- res.toResult(taskID)
 }
 
-func (c *httpClient) handleStream(body io.ReadCloser, ch chan<- Result) {
+func (c *httpClient) handleStream(body io.ReadCloser, taskID string, ch chan<- Result) {
 	defer body.Close()
 	defer close(ch)
 
@@ -145,16 +401,14 @@ func (c *httpClient) handleStream(body io.ReadCloser, ch chan<- Result) {
 			continue
 		}
 
-		var res struct {
-			Text string `json:"text"`
-		}
+		var res taskResult
 		if err := json.Unmarshal([]byte(data), &res); err != nil {
 			continue
 		}
-		ch <- Result{Text: res.Text}
+		ch <- res.toResult(taskID)
 	}
 
 	if err := scanner.Err(); err != nil {
-		ch <- Result{Err: err}
+		ch <- Result{Err: err, TaskID: taskID}
 	}
 }
@@ -0,0 +1,243 @@
+package client
+
+import (
+	"context"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/gorilla/websocket"
+)
+
+// wsMessage is a message sent to the /ws endpoint.
+type wsMessage struct {
+	Type    string           `json:"type"`
+	ID      string           `json:"id,omitempty"`
+	TaskID  string           `json:"task_id,omitempty"`
+	Request *GenerateRequest `json:"request,omitempty"`
+}
+
+// wsFrame is a message received from the /ws endpoint.
+type wsFrame struct {
+	Type     string     `json:"type"`
+	ID       string     `json:"id"`
+	TaskID   string     `json:"task_id"`
+	Text     string     `json:"text"`
+	Error    *TaskError `json:"error"`
+	Metadata *Metadata  `json:"metadata"`
+}
+
+// wsClient runs generation tasks over a shared WebSocket connection, telling
+// their frames apart by the ID of the message that started each, and makes
+// its other calls over HTTP. A lost connection fails the tasks running on it
+// and is dialed again for the next task.
+type wsClient struct {
+	*httpClient
+	url string
+
+	mu     sync.Mutex
+	conn   *websocket.Conn
+	tasks  map[string]*wsTask
+	nextID uint64
+
+	writeMu sync.Mutex
+}
+
+// wsTask is a task running on the connection. Only the connection's reader
+// touches taskID and text. The reader queues the task's results, and the
+// task's own goroutine, run, passes them on, so that a caller slow to read
+// holds up no other task on the connection.
+type wsTask struct {
+	ctx    context.Context
+	stream bool
+	ch     chan Result
+	done   chan struct{}
+	taskID string
+	text   strings.Builder
+
+	mu      sync.Mutex
+	pending []Result
+	ended   bool
+	wake    chan struct{}
+}
+
+func newWSClient(c *httpClient) *wsClient {
+	return &wsClient{
+		httpClient: c,
+		url:        "ws" + strings.TrimPrefix(c.baseURL, "http") + "/ws",
+	}
+}
+
+func (c *wsClient) Close() error {
+	c.mu.Lock()
+	conn := c.conn
+	c.conn = nil
+	c.mu.Unlock()
+	if conn == nil {
+		return nil
+	}
+	return conn.Close()
+}
+
+func (c *wsClient) GenerateTask(ctx context.Context, req *GenerateRequest) (<-chan Result, error) {
+	c.mu.Lock()
+	if c.conn == nil {
+		conn, _, err := websocket.DefaultDialer.DialContext(ctx, c.url, c.authHeader())
+		if err != nil {
+			c.mu.Unlock()
+			return nil, err
+		}
+		c.conn = conn
+		c.tasks = make(map[string]*wsTask)
+		go c.readFrames(conn, c.tasks)
+	}
+	conn, tasks := c.conn, c.tasks
+	c.nextID++
+	id := strconv.FormatUint(c.nextID, 10)
+	t := &wsTask{
+		ctx:    ctx,
+		stream: req.Stream,
+		ch:     make(chan Result, 100),
+		done:   make(chan struct{}),
+		wake:   make(chan struct{}, 1),
+	}
+	tasks[id] = t
+	c.mu.Unlock()
+
+	if err := c.write(conn, wsMessage{Type: "generate", ID: id, Request: req}); err != nil {
+		c.mu.Lock()
+		delete(tasks, id)
+		c.mu.Unlock()
+		return nil, err
+	}
+	go t.run()
+
+	// The server stops the task if the caller gives up on it.
+	go func() {
+		select {
+		case <-ctx.Done():
+			c.write(conn, wsMessage{Type: "cancel", ID: id})
+		case <-t.done:
+		}
+	}()
+	return t.ch, nil
+}
+
+// readFrames delivers the frames read from conn to the tasks they belong to
+// until the connection ends, then fails the tasks still running on it.
+func (c *wsClient) readFrames(conn *websocket.Conn, tasks map[string]*wsTask) {
+	var err error
+	for {
+		var f wsFrame
+		if err = conn.ReadJSON(&f); err != nil {
+			break
+		}
+
+		c.mu.Lock()
+		t, ok := tasks[f.ID]
+		c.mu.Unlock()
+		if !ok {
+			continue
+		}
+		if f.TaskID != "" {
+			t.taskID = f.TaskID
+		}
+
+		switch f.Type {
+		case "chunk":
+			if t.stream {
+				t.deliver(Result{Text: f.Text, TaskID: t.taskID})
+			} else {
+				t.text.WriteString(f.Text)
+			}
+		case "error", "done":
+			res := Result{Metadata: f.Metadata, TaskID: t.taskID}
+			if f.Error != nil {
+				res.Err = f.Error
+			}
+			if !t.stream {
+				res.Text = t.text.String()
+			}
+			t.deliver(res)
+
+			c.mu.Lock()
+			delete(tasks, f.ID)
+			c.mu.Unlock()
+			t.finish()
+		}
+	}
+
+	c.mu.Lock()
+	if c.conn == conn {
+		c.conn = nil
+	}
+	pending := make([]*wsTask, 0, len(tasks))
+	for id, t := range tasks {
+		pending = append(pending, t)
+		delete(tasks, id)
+	}
+	c.mu.Unlock()
+
+	conn.Close()
+	for _, t := range pending {
+		t.deliver(Result{Err: err, TaskID: t.taskID})
+		t.finish()
+	}
+}
+
+func (c *wsClient) write(conn *websocket.Conn, msg wsMessage) error {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	return conn.WriteJSON(msg)
+}
+
+// deliver queues res for the caller without blocking.
+func (t *wsTask) deliver(res Result) {
+	t.mu.Lock()
+	t.pending = append(t.pending, res)
+	t.mu.Unlock()
+	t.signal()
+}
+
+// finish marks the task's results complete once those queued are delivered.
+func (t *wsTask) finish() {
+	t.mu.Lock()
+	t.ended = true
+	t.mu.Unlock()
+	t.signal()
+}
+
+func (t *wsTask) signal() {
+	select {
+	case t.wake <- struct{}{}:
+	default:
+	}
+}
+
+// run passes the queued results to the caller in order, dropping them once
+// the caller has given up on the task, and closes its channel after the
+// last.
+func (t *wsTask) run() {
+	defer close(t.done)
+	defer close(t.ch)
+	for {
+		t.mu.Lock()
+		if len(t.pending) == 0 {
+			ended := t.ended
+			t.mu.Unlock()
+			if ended {
+				return
+			}
+			<-t.wake
+			continue
+		}
+		res := t.pending[0]
+		t.pending = t.pending[1:]
+		t.mu.Unlock()
+
+		select {
+		case t.ch <- res:
+		case <-t.ctx.Done():
+		}
+	}
+}
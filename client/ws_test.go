@@ -0,0 +1,105 @@
+package client
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// chunksPerTask is more than a task's result channel holds.
+const chunksPerTask = 300
+
+// fakeWSServer answers each generate message with chunksPerTask chunks,
+// sending the tasks' frames interleaved once both tasks have started.
+func fakeWSServer(t *testing.T) string {
+	t.Helper()
+	upgrader := websocket.Upgrader{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		var ids []string
+		for len(ids) < 2 {
+			var msg wsMessage
+			if err := conn.ReadJSON(&msg); err != nil {
+				return
+			}
+			if msg.Type == "generate" {
+				ids = append(ids, msg.ID)
+			}
+		}
+		for i := 0; i < chunksPerTask; i++ {
+			for _, id := range ids {
+				conn.WriteJSON(wsFrame{Type: "chunk", ID: id, TaskID: "task-" + id, Text: strconv.Itoa(i)})
+			}
+		}
+		for _, id := range ids {
+			conn.WriteJSON(wsFrame{Type: "done", ID: id, TaskID: "task-" + id, Metadata: &Metadata{}})
+		}
+		conn.ReadJSON(&wsMessage{})
+	}))
+	t.Cleanup(srv.Close)
+	return srv.URL
+}
+
+func TestWSSlowTaskHoldsUpNoOther(t *testing.T) {
+	c := New(fakeWSServer(t), WithWebSocket())
+	defer c.Close()
+	ctx := context.Background()
+
+	slow, err := c.GenerateTask(ctx, &GenerateRequest{Prompt: "slow", Stream: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	fast, err := c.GenerateTask(ctx, &GenerateRequest{Prompt: "fast", Stream: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The fast task finishes while nobody reads the slow one.
+	n := 0
+	timeout := time.After(5 * time.Second)
+	for done := false; !done; {
+		select {
+		case res, ok := <-fast:
+			if !ok {
+				done = true
+				break
+			}
+			if res.Err != nil {
+				t.Fatal(res.Err)
+			}
+			if res.Metadata == nil {
+				n++
+			}
+		case <-timeout:
+			t.Fatalf("fast task held up after %d chunks", n)
+		}
+	}
+	if n != chunksPerTask {
+		t.Errorf("fast task got %d chunks, want %d", n, chunksPerTask)
+	}
+
+	// The slow task still gets all of its results, in order.
+	n = 0
+	for res := range slow {
+		if res.Metadata != nil {
+			continue
+		}
+		if res.Text != strconv.Itoa(n) {
+			t.Fatalf("slow task chunk %d = %q", n, res.Text)
+		}
+		n++
+	}
+	if n != chunksPerTask {
+		t.Errorf("slow task got %d chunks, want %d", n, chunksPerTask)
+	}
+}
@@ -2,63 +2,179 @@ package main
 
 import (
 	"context"
+	"flag"
 	"fmt"
-	"log"
+	"log/slog"
 	"net/http"
+	"os"
 	"os/signal"
 	"runtime"
 	"syscall"
 	"time"
 
+	"github.com/sokinpui/synapse.go/internal/audit"
+	"github.com/sokinpui/synapse.go/internal/batch"
+	"github.com/sokinpui/synapse.go/internal/billing"
 	"github.com/sokinpui/synapse.go/internal/broker"
+	"github.com/sokinpui/synapse.go/internal/cache"
 	"github.com/sokinpui/synapse.go/internal/config"
+	"github.com/sokinpui/synapse.go/internal/logging"
+	"github.com/sokinpui/synapse.go/internal/metrics"
+	"github.com/sokinpui/synapse.go/internal/responses"
 	"github.com/sokinpui/synapse.go/internal/server"
+	"github.com/sokinpui/synapse.go/internal/tracing"
 	"github.com/sokinpui/synapse.go/internal/worker"
 	"github.com/sokinpui/synapse.go/model"
 )
 
 func main() {
-	log.SetPrefix("server: ")
+	healthcheck := flag.Bool("healthcheck", false, "probe /healthz of the server running locally and exit")
+	flag.Parse()
 
 	cfg := config.Load()
+	logging.Setup(cfg.Logging)
+
+	if *healthcheck {
+		os.Exit(probeHealth(cfg.Server.HTTPPort))
+	}
+
+	shutdownTracing, err := tracing.Setup(context.Background(), cfg.Tracing)
+	if err != nil {
+		slog.Warn("Failed to set up tracing", "error", err)
+	}
 
 	llmRegistry, err := model.New(cfg)
 	if err != nil {
-		log.Printf("Warning: Failed to initialize LLM registry: %v", err)
+		slog.Warn("Failed to initialize LLM registry", "error", err)
 	}
 
 	memBroker := broker.NewMemoryBroker(1000)
+	memBroker.SetSubscriberBuffer(cfg.Server.SubscriberBuffer)
+	metrics.RegisterQueueDepth(memBroker.QueueDepth)
 
 	concurrency := cfg.Worker.ConcurrencyMultiplier * runtime.NumCPU()
-	w := worker.New(memBroker, llmRegistry, concurrency)
+	w := worker.New(memBroker, llmRegistry, concurrency, cfg.Worker)
+
+	auditLog, err := audit.New(cfg.Audit)
+	if err != nil {
+		slog.Error("Failed to open audit log", "error", err)
+		os.Exit(1)
+	}
+	if auditLog != nil {
+		w.OnComplete(auditLog.Observe)
+	}
+
+	ledger, err := billing.New(cfg.Billing)
+	if err != nil {
+		slog.Error("Failed to open billing ledger", "error", err)
+		os.Exit(1)
+	}
+	w.OnComplete(ledger.Observe)
+
+	responseCache, err := cache.New(cfg.Cache)
+	if err != nil {
+		slog.Error("Failed to open response cache", "error", err)
+		os.Exit(1)
+	}
+	if responseCache != nil {
+		w.OnComplete(responseCache.Observe)
+	}
+	stopLedger := make(chan struct{})
+	ledgerDone := make(chan struct{})
+	go func() {
+		defer close(ledgerDone)
+		ledger.Run(time.Minute, stopLedger)
+	}()
 
 	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
 	defer stop()
 
-	go w.Run(ctx)
+	// The worker outlives the signal so that in-flight tasks can drain.
+	go w.Run(context.Background())
 
 	// HTTP Server
 	mux := http.NewServeMux()
-	httpSrv := server.NewHTTPServer(memBroker, llmRegistry)
+	httpSrv := server.NewHTTPServer(memBroker, llmRegistry, cfg)
+	httpSrv.SetAuditLog(auditLog)
+	httpSrv.SetLedger(ledger)
+	httpSrv.SetCache(responseCache)
+	httpSrv.SetResponses(responses.New(cfg.Responses))
+	batches, err := batch.New(cfg.Batch, httpSrv.ExecuteBatchRequest)
+	if err != nil {
+		slog.Error("Failed to open batch store", "error", err)
+		os.Exit(1)
+	}
+	httpSrv.SetBatches(batches)
 	httpSrv.RegisterRoutes(mux)
 	httpAddr := fmt.Sprintf(":%d", cfg.Server.HTTPPort)
-	hSrv := &http.Server{Addr: httpAddr, Handler: mux}
+	handler := server.RequestID(server.Instrument(server.Trace(mux)))
+	hSrv := &http.Server{Addr: httpAddr, Handler: server.Authenticate(cfg.Server.Tenants, handler)}
+	if len(cfg.Billing.Budgets) > 0 && len(cfg.Server.Tenants) == 0 {
+		slog.Warn("Budgets are enforced on the unauthenticated X-Tenant-ID header; set server.tenants to tie tenants to API keys")
+	}
 
-	log.Printf("HTTP Server listening at %s", httpAddr)
+	slog.Info("HTTP server listening", "addr", httpAddr)
 
 	go func() {
 		if err := hSrv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
-			log.Printf("HTTP server error: %v", err)
+			slog.Error("HTTP server error", "error", err)
 		}
 	}()
 
 	<-ctx.Done()
-	log.Println("Shutting down servers...")
+
+	drainTimeout := cfg.Server.DrainTimeout
+	if drainTimeout <= 0 {
+		drainTimeout = 30 * time.Second
+	}
+	slog.Info("Draining: rejecting new tasks, waiting for tasks in flight", "timeout", drainTimeout)
+	// Batches stop first and resume on restart, rather than have their
+	// queued requests rejected by the drain.
+	batches.Close()
+	memBroker.Close()
+
+	drainCtx, cancelDrain := context.WithTimeout(context.Background(), drainTimeout)
+	defer cancelDrain()
+	if err := w.Drain(drainCtx); err != nil {
+		slog.Warn("Drain incomplete", "error", err)
+	}
+
+	slog.Info("Shutting down servers")
 
 	shutdownCtx, cancel := context.WithTimeout(context.Background(), time.Duration(10*runtime.NumCPU())*time.Second)
 	defer cancel()
 
 	if err := hSrv.Shutdown(shutdownCtx); err != nil {
-		log.Printf("HTTP shutdown error: %v", err)
+		slog.Error("HTTP shutdown error", "error", err)
+	}
+	// Drain has returned only once every worker goroutine stopped, and
+	// nothing else records completions once the HTTP server is down.
+	if err := auditLog.Close(); err != nil {
+		slog.Error("Audit log close error", "error", err)
+	}
+	close(stopLedger)
+	<-ledgerDone
+	if shutdownTracing != nil {
+		if err := shutdownTracing(shutdownCtx); err != nil {
+			slog.Error("Tracing shutdown error", "error", err)
+		}
+	}
+}
+
+// probeHealth checks the liveness endpoint of a server on this host, for
+// container health checks. It returns the process exit code.
+func probeHealth(port int) int {
+	client := &http.Client{Timeout: 3 * time.Second}
+	resp, err := client.Get(fmt.Sprintf("http://127.0.0.1:%d/healthz", port))
+	if err != nil {
+		slog.Error("Health check failed", "error", err)
+		return 1
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		slog.Error("Health check failed", "status", resp.StatusCode)
+		return 1
 	}
+	return 0
 }
@@ -0,0 +1,363 @@
+// Command synapse-batch runs a JSONL file of generate requests against a
+// Synapse server, writing one result per line to an output JSONL file.
+//
+// Each input line is a client.GenerateRequest, optionally with an "id" that
+// is copied to its result. Results are written as they finish, so their
+// order differs from the input; each carries the number of its input line.
+// Rerunning with the same output file resumes: lines that already succeeded
+// are kept and skipped, and the rest run again.
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/sokinpui/synapse.go/client"
+)
+
+// request is one line of the input file.
+type request struct {
+	ID string `json:"id,omitempty"`
+	client.GenerateRequest
+}
+
+// result is one line of the output file.
+type result struct {
+	Line       int              `json:"line"`
+	ID         string           `json:"id,omitempty"`
+	Status     string           `json:"status"`
+	Text       string           `json:"text,omitempty"`
+	Error      string           `json:"error,omitempty"`
+	Tries      int              `json:"tries"`
+	DurationMS int64            `json:"duration_ms"`
+	Metadata   *client.Metadata `json:"metadata,omitempty"`
+}
+
+const (
+	statusOK    = "ok"
+	statusError = "error"
+)
+
+// job is an input line still to run.
+type job struct {
+	line int
+	data []byte
+}
+
+func main() {
+	addr := flag.String("addr", "localhost:8080", "address of the Synapse server")
+	in := flag.String("in", "", "input JSONL file of generate requests (required)")
+	out := flag.String("out", "", "output JSONL file of results (default: the input name with .out.jsonl)")
+	parallel := flag.Int("parallel", 4, "requests in flight at once")
+	retries := flag.Int("retries", 3, "retries of a failed request, for transient errors")
+	retryDelay := flag.Duration("retry-delay", time.Second, "delay before the first retry, doubling after each")
+	progress := flag.Duration("progress", 2*time.Second, "interval between progress reports")
+	ws := flag.Bool("ws", false, "run requests over one WebSocket connection instead of one HTTP request each")
+	flag.Parse()
+
+	if *in == "" {
+		flag.Usage()
+		os.Exit(2)
+	}
+	if *out == "" {
+		*out = strings.TrimSuffix(*in, ".jsonl") + ".out.jsonl"
+	}
+	if *parallel < 1 {
+		*parallel = 1
+	}
+
+	done, err := resume(*out)
+	if err != nil {
+		fatal("Failed to read %s: %v", *out, err)
+	}
+	jobs, err := readJobs(*in, done)
+	if err != nil {
+		fatal("Failed to read %s: %v", *in, err)
+	}
+	if len(done) > 0 {
+		fmt.Fprintf(os.Stderr, "Resuming: %d lines already done, %d to run\n", len(done), len(jobs))
+	}
+
+	outFile, err := os.OpenFile(*out, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+	if err != nil {
+		fatal("Failed to open %s: %v", *out, err)
+	}
+	defer outFile.Close()
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	var opts []client.Option
+	if key := os.Getenv("SYNAPSE_API_KEY"); key != "" {
+		opts = append(opts, client.WithAPIKey(key))
+	}
+	if *ws {
+		opts = append(opts, client.WithWebSocket())
+	}
+	c := client.New(*addr, opts...)
+	defer c.Close()
+
+	r := &runner{
+		client:     c,
+		out:        outFile,
+		retries:    *retries,
+		retryDelay: *retryDelay,
+		total:      len(jobs),
+		started:    time.Now(),
+	}
+	r.run(ctx, jobs, *parallel, *progress)
+
+	r.report(os.Stderr)
+	if ctx.Err() != nil {
+		fmt.Fprintln(os.Stderr, "Interrupted; run again with the same output file to resume")
+		os.Exit(130)
+	}
+	if r.failed > 0 {
+		os.Exit(1)
+	}
+}
+
+func fatal(format string, args ...any) {
+	fmt.Fprintf(os.Stderr, format+"\n", args...)
+	os.Exit(1)
+}
+
+// resume keeps the successful results of a previous run in the output file
+// at path, dropping failed and incomplete ones, and returns their lines.
+func resume(path string) (map[int]bool, error) {
+	done := map[int]bool{}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return done, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var kept bytes.Buffer
+	for _, line := range bytes.Split(data, []byte("\n")) {
+		var res result
+		if json.Unmarshal(line, &res) != nil || res.Status != statusOK || done[res.Line] {
+			continue
+		}
+		done[res.Line] = true
+		kept.Write(line)
+		kept.WriteByte('\n')
+	}
+
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, kept.Bytes(), 0o644); err != nil {
+		return nil, err
+	}
+	return done, os.Rename(tmp, path)
+}
+
+// readJobs returns the non-blank lines of the input file at path that are
+// not done.
+func readJobs(path string, done map[int]bool) ([]job, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var jobs []job
+	r := bufio.NewReader(f)
+	for n := 1; ; n++ {
+		line, err := r.ReadBytes('\n')
+		if line = bytes.TrimSpace(line); len(line) > 0 && !done[n] {
+			jobs = append(jobs, job{line: n, data: line})
+		}
+		if err == io.EOF {
+			return jobs, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+}
+
+type runner struct {
+	client     client.Client
+	out        *os.File
+	retries    int
+	retryDelay time.Duration
+	total      int
+	started    time.Time
+
+	mu        sync.Mutex
+	succeeded int
+	failed    int
+}
+
+func (r *runner) run(ctx context.Context, jobs []job, parallel int, progress time.Duration) {
+	queue := make(chan job)
+	var wg sync.WaitGroup
+	for i := 0; i < parallel; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := range queue {
+				if res, ok := r.execute(ctx, j); ok {
+					r.record(res)
+				}
+			}
+		}()
+	}
+
+	ticker := time.NewTicker(progress)
+	defer ticker.Stop()
+	finished := make(chan struct{})
+	go func() {
+		for {
+			select {
+			case <-ticker.C:
+				r.report(os.Stderr)
+			case <-finished:
+				return
+			}
+		}
+	}()
+
+feed:
+	for _, j := range jobs {
+		select {
+		case queue <- j:
+		case <-ctx.Done():
+			break feed
+		}
+	}
+	close(queue)
+	wg.Wait()
+	close(finished)
+}
+
+// execute runs one line, retrying transient failures. It reports false if
+// ctx ended first, in which case the line is left for a later run.
+func (r *runner) execute(ctx context.Context, j job) (res result, ok bool) {
+	res.Line = j.line
+	start := time.Now()
+	defer func() { res.DurationMS = time.Since(start).Milliseconds() }()
+
+	var req request
+	if err := json.Unmarshal(j.data, &req); err != nil {
+		res.Status, res.Error = statusError, "invalid request: "+err.Error()
+		return res, true
+	}
+	res.ID = req.ID
+
+	delay := r.retryDelay
+	for {
+		res.Tries++
+		text, meta, err := generate(ctx, r.client, &req.GenerateRequest)
+		if ctx.Err() != nil {
+			return res, false
+		}
+		if err == nil {
+			res.Status, res.Text, res.Metadata, res.Error = statusOK, text, meta, ""
+			return res, true
+		}
+		res.Status, res.Error = statusError, err.Error()
+		if res.Tries > r.retries || !retryable(err) {
+			return res, true
+		}
+
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return res, false
+		}
+		delay *= 2
+	}
+}
+
+// generate runs req to completion and returns its text.
+func generate(ctx context.Context, c client.Client, req *client.GenerateRequest) (string, *client.Metadata, error) {
+	ch, err := c.GenerateTask(ctx, req)
+	if err != nil {
+		return "", nil, err
+	}
+
+	var sb strings.Builder
+	var meta *client.Metadata
+	for res := range ch {
+		if res.Err != nil {
+			// Drain the channel so that the reading goroutine can exit.
+			for range ch {
+			}
+			return "", nil, res.Err
+		}
+		sb.WriteString(res.Text)
+		if res.Metadata != nil {
+			meta = res.Metadata
+		}
+	}
+	return sb.String(), meta, nil
+}
+
+// retryable reports whether err may go away on another try. Errors the
+// server reports for the task itself are retried only if transient;
+// transport errors always are.
+func retryable(err error) bool {
+	var taskErr *client.TaskError
+	if !errors.As(err, &taskErr) {
+		return true
+	}
+	switch taskErr.Code {
+	case "generation_failed", "circuit_open", "timeout", "shutting_down":
+		return true
+	}
+	return false
+}
+
+func (r *runner) record(res result) {
+	data, err := json.Marshal(res)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to encode the result of line %d: %v\n", res.Line, err)
+		return
+	}
+	data = append(data, '\n')
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, err := r.out.Write(data); err != nil {
+		fatal("Failed to write result: %v", err)
+	}
+	if res.Status == statusOK {
+		r.succeeded++
+	} else {
+		r.failed++
+	}
+}
+
+// report prints progress and throughput to w.
+func (r *runner) report(w io.Writer) {
+	r.mu.Lock()
+	succeeded, failed := r.succeeded, r.failed
+	r.mu.Unlock()
+
+	finished := succeeded + failed
+	elapsed := time.Since(r.started)
+	rate := float64(finished) / elapsed.Seconds()
+
+	line := fmt.Sprintf("%d/%d done (%d ok, %d failed) in %s, %.2f req/s",
+		finished, r.total, succeeded, failed, elapsed.Round(time.Second), rate)
+	if remaining := r.total - finished; remaining > 0 && rate > 0 {
+		eta := time.Duration(float64(remaining) / rate * float64(time.Second))
+		line += fmt.Sprintf(", ETA %s", eta.Round(time.Second))
+	}
+	fmt.Fprintln(w, line)
+}
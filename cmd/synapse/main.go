@@ -0,0 +1,422 @@
+// Command synapse is a command-line client for a Synapse server.
+//
+//	synapse [-addr host:port] [-json] <command> [flags] [args]
+//
+// Commands:
+//
+//	models              list the models the server offers
+//	generate [prompt]   generate once; the prompt is read from stdin if not given
+//	chat                chat interactively, keeping the conversation's history
+//	status <task-id>    show the status of a task
+//	cancel <task-id>    cancel a pending task
+//
+// With -json, results are printed as JSON for scripts.
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+
+	"github.com/sokinpui/synapse.go/client"
+)
+
+const defaultModel = "gemini-2.5-flash"
+
+// app holds the global options.
+type app struct {
+	client client.Client
+	json   bool
+}
+
+func main() {
+	addr := flag.String("addr", envOr("SYNAPSE_ADDR", "localhost:8080"), "address of the Synapse server (SYNAPSE_ADDR)")
+	jsonOut := flag.Bool("json", false, "print results as JSON")
+	flag.Usage = usage
+	flag.Parse()
+
+	if flag.NArg() == 0 {
+		usage()
+		os.Exit(2)
+	}
+
+	var opts []client.Option
+	if key := os.Getenv("SYNAPSE_API_KEY"); key != "" {
+		opts = append(opts, client.WithAPIKey(key))
+	}
+	a := &app{client: client.New(*addr, opts...), json: *jsonOut}
+	defer a.client.Close()
+
+	cmd, args := flag.Arg(0), flag.Args()[1:]
+	var err error
+	switch cmd {
+	case "models":
+		err = a.models()
+	case "generate":
+		err = a.generate(args)
+	case "chat":
+		err = a.chat(args)
+	case "status":
+		err = a.status(args)
+	case "cancel":
+		err = a.cancel(args)
+	default:
+		fmt.Fprintf(os.Stderr, "unknown command %q\n\n", cmd)
+		usage()
+		os.Exit(2)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error:", err)
+		os.Exit(1)
+	}
+}
+
+func usage() {
+	fmt.Fprintf(os.Stderr, `Usage: synapse [-addr host:port] [-json] <command> [flags] [args]
+
+Commands:
+  models              list the models the server offers
+  generate [prompt]   generate once; the prompt is read from stdin if not given
+  chat                chat interactively, keeping the conversation's history
+  status <task-id>    show the status of a task
+  cancel <task-id>    cancel a pending task
+
+Run "synapse <command> -h" for the flags of a command.
+
+Global flags:
+`)
+	flag.PrintDefaults()
+}
+
+func envOr(key, fallback string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+	return fallback
+}
+
+func (a *app) printJSON(v any) {
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	enc.Encode(v)
+}
+
+func (a *app) models() error {
+	models, err := a.client.ListModels(context.Background())
+	if err != nil {
+		return err
+	}
+	if a.json {
+		a.printJSON(map[string]any{"models": models})
+		return nil
+	}
+	for _, m := range models {
+		fmt.Println(m)
+	}
+	return nil
+}
+
+// genFlags are the generation options shared by generate and chat.
+type genFlags struct {
+	model       *string
+	temperature *float64
+	maxTokens   *int
+	stream      *bool
+}
+
+func addGenFlags(fs *flag.FlagSet) genFlags {
+	return genFlags{
+		model:       fs.String("model", envOr("SYNAPSE_MODEL", defaultModel), "model code (SYNAPSE_MODEL)"),
+		temperature: fs.Float64("temperature", -1, "sampling temperature (default: the model's)"),
+		maxTokens:   fs.Int("max-tokens", 0, "maximum output tokens (default: the model's)"),
+		stream:      fs.Bool("stream", true, "print the response as it is generated"),
+	}
+}
+
+func (g genFlags) request(prompt string) *client.GenerateRequest {
+	req := &client.GenerateRequest{Prompt: prompt, ModelCode: *g.model, Stream: *g.stream}
+	if *g.temperature >= 0 || *g.maxTokens > 0 {
+		req.Config = &client.GenerationConfig{}
+		if *g.temperature >= 0 {
+			t := float32(*g.temperature)
+			req.Config.Temperature = &t
+		}
+		if *g.maxTokens > 0 {
+			n := int32(*g.maxTokens)
+			req.Config.OutputLength = &n
+		}
+	}
+	return req
+}
+
+// stringList collects a repeated flag.
+type stringList []string
+
+func (l *stringList) String() string     { return strings.Join(*l, ",") }
+func (l *stringList) Set(v string) error { *l = append(*l, v); return nil }
+
+// generation is the outcome of one task, as printed in JSON mode.
+type generation struct {
+	TaskID   string           `json:"task_id,omitempty"`
+	Text     string           `json:"text"`
+	Metadata *client.Metadata `json:"metadata,omitempty"`
+	Error    string           `json:"error,omitempty"`
+}
+
+// run sends req and collects its response, writing the text to w as it
+// arrives if w is not nil.
+func (a *app) run(ctx context.Context, req *client.GenerateRequest, w io.Writer) (generation, error) {
+	ch, err := a.client.GenerateTask(ctx, req)
+	if err != nil {
+		return generation{}, err
+	}
+
+	var gen generation
+	var sb strings.Builder
+	for res := range ch {
+		if res.TaskID != "" {
+			gen.TaskID = res.TaskID
+		}
+		if res.Err != nil {
+			err = res.Err
+			continue
+		}
+		if res.IsKeepAlive {
+			continue
+		}
+		sb.WriteString(res.Text)
+		if w != nil {
+			io.WriteString(w, res.Text)
+		}
+		if res.Metadata != nil {
+			gen.Metadata = res.Metadata
+		}
+	}
+	gen.Text = sb.String()
+	if err == nil && ctx.Err() != nil {
+		err = ctx.Err()
+	}
+	if err != nil {
+		gen.Error = err.Error()
+	}
+	return gen, err
+}
+
+func (a *app) generate(args []string) error {
+	fs := flag.NewFlagSet("generate", flag.ExitOnError)
+	gf := addGenFlags(fs)
+	var images stringList
+	fs.Var(&images, "image", "image file to send with the prompt (repeatable)")
+	fs.Parse(args)
+
+	prompt := strings.Join(fs.Args(), " ")
+	if prompt == "" {
+		data, err := io.ReadAll(os.Stdin)
+		if err != nil {
+			return err
+		}
+		prompt = string(data)
+	}
+	if strings.TrimSpace(prompt) == "" {
+		return errors.New("empty prompt")
+	}
+
+	req := gf.request(prompt)
+	for _, path := range images {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		req.Images = append(req.Images, data)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	var w io.Writer
+	if !a.json {
+		w = os.Stdout
+	}
+	gen, err := a.run(ctx, req, w)
+	if a.json {
+		a.printJSON(gen)
+		if err != nil {
+			os.Exit(1)
+		}
+		return nil
+	}
+	if !strings.HasSuffix(gen.Text, "\n") {
+		fmt.Println()
+	}
+	return err
+}
+
+// turn is one message of a chat.
+type turn struct {
+	role string
+	text string
+}
+
+// chatPrompt renders the history as the prompt for the next reply, in the
+// same form the server gives chat completion requests.
+func chatPrompt(history []turn) string {
+	var sb strings.Builder
+	for _, t := range history {
+		fmt.Fprintf(&sb, "%s: %s\n", t.role, t.text)
+	}
+	return sb.String()
+}
+
+func (a *app) chat(args []string) error {
+	fs := flag.NewFlagSet("chat", flag.ExitOnError)
+	gf := addGenFlags(fs)
+	system := fs.String("system", "", "system prompt")
+	fs.Parse(args)
+
+	var history []turn
+	reset := func() {
+		history = history[:0]
+		if *system != "" {
+			history = append(history, turn{role: "system", text: *system})
+		}
+	}
+	reset()
+
+	// Ctrl-C cancels the reply in progress, or quits at the prompt.
+	var mu sync.Mutex
+	var cancelReply context.CancelFunc
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, os.Interrupt)
+	defer signal.Stop(sigs)
+	go func() {
+		for range sigs {
+			mu.Lock()
+			cancel := cancelReply
+			mu.Unlock()
+			if cancel == nil {
+				fmt.Println()
+				os.Exit(0)
+			}
+			cancel()
+		}
+	}()
+
+	if !a.json {
+		fmt.Fprintf(os.Stderr, "Chatting with %s. Commands: /reset, /model <code>, /exit. Ctrl-C stops a reply.\n", *gf.model)
+	}
+	in := bufio.NewScanner(os.Stdin)
+	in.Buffer(make([]byte, 64*1024), 4*1024*1024)
+	for {
+		if !a.json {
+			fmt.Print("> ")
+		}
+		if !in.Scan() {
+			fmt.Println()
+			return in.Err()
+		}
+		line := strings.TrimSpace(in.Text())
+		switch {
+		case line == "":
+			continue
+		case line == "/exit" || line == "/quit":
+			return nil
+		case line == "/reset":
+			reset()
+			fmt.Fprintln(os.Stderr, "History cleared.")
+			continue
+		case strings.HasPrefix(line, "/model"):
+			if m := strings.TrimSpace(strings.TrimPrefix(line, "/model")); m != "" {
+				*gf.model = m
+			}
+			fmt.Fprintln(os.Stderr, "Model:", *gf.model)
+			continue
+		}
+
+		history = append(history, turn{role: "user", text: line})
+		ctx, cancel := context.WithCancel(context.Background())
+		mu.Lock()
+		cancelReply = cancel
+		mu.Unlock()
+
+		var w io.Writer
+		if !a.json {
+			w = os.Stdout
+		}
+		gen, err := a.run(ctx, gf.request(chatPrompt(history)), w)
+
+		mu.Lock()
+		cancelReply = nil
+		mu.Unlock()
+		canceled := ctx.Err() != nil
+		cancel()
+
+		if a.json {
+			a.printJSON(gen)
+		} else if !strings.HasSuffix(gen.Text, "\n") {
+			fmt.Println()
+		}
+		if err != nil {
+			// Leave the failed exchange out of the history.
+			history = history[:len(history)-1]
+			if canceled {
+				fmt.Fprintln(os.Stderr, "(canceled)")
+			} else if !a.json {
+				fmt.Fprintln(os.Stderr, "Error:", err)
+			}
+			continue
+		}
+		history = append(history, turn{role: "assistant", text: gen.Text})
+	}
+}
+
+func taskArg(cmd string, args []string) (string, error) {
+	if len(args) != 1 {
+		return "", fmt.Errorf("usage: synapse %s <task-id>", cmd)
+	}
+	return args[0], nil
+}
+
+func (a *app) printStatus(st *client.TaskStatus) {
+	if a.json {
+		a.printJSON(st)
+		return
+	}
+	fmt.Printf("%s  %s  %s\n", st.TaskID, st.Model, st.State)
+	if st.Error != nil {
+		fmt.Printf("error: %s\n", st.Error)
+	}
+}
+
+func (a *app) status(args []string) error {
+	id, err := taskArg("status", args)
+	if err != nil {
+		return err
+	}
+	st, err := a.client.Status(context.Background(), id)
+	if err != nil {
+		return err
+	}
+	a.printStatus(st)
+	return nil
+}
+
+func (a *app) cancel(args []string) error {
+	id, err := taskArg("cancel", args)
+	if err != nil {
+		return err
+	}
+	st, err := a.client.Cancel(context.Background(), id)
+	if st != nil {
+		a.printStatus(st)
+	}
+	return err
+}
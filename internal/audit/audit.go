@@ -0,0 +1,281 @@
+// Package audit records every generation the worker finishes, for
+// compliance, and writes the records to pluggable sinks.
+package audit
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/sokinpui/synapse.go/internal/config"
+	"github.com/sokinpui/synapse.go/internal/metrics"
+	"github.com/sokinpui/synapse.go/internal/models"
+	"github.com/sokinpui/synapse.go/internal/worker"
+)
+
+// Record is one audited task.
+type Record struct {
+	Time         time.Time     `json:"time"`
+	TaskID       string        `json:"task_id"`
+	RequestID    string        `json:"request_id,omitempty"`
+	Tenant       string        `json:"tenant,omitempty"`
+	Model        string        `json:"model"`
+	Prompt       string        `json:"prompt,omitempty"`
+	PromptHash   string        `json:"prompt_hash,omitempty"`
+	Response     string        `json:"response,omitempty"`
+	ResponseHash string        `json:"response_hash,omitempty"`
+	Usage        *models.Usage `json:"usage,omitempty"`
+	Attempts     int           `json:"attempts"`
+	LatencyMS    int64         `json:"latency_ms"`
+	Outcome      string        `json:"outcome"`
+	ErrorCode    string        `json:"error_code,omitempty"`
+	Error        string        `json:"error,omitempty"`
+}
+
+// Sink stores audit records.
+type Sink interface {
+	Write(ctx context.Context, rec Record) error
+	Close() error
+}
+
+// Querier is implemented by sinks that can read records back.
+type Querier interface {
+	Query(ctx context.Context, f Filter) ([]Record, error)
+}
+
+// Filter selects audit records. Zero fields match everything. Results are
+// the most recent Limit records, newest first.
+type Filter struct {
+	Tenant string
+	Model  string
+	TaskID string
+	Since  time.Time
+	Until  time.Time
+	Limit  int
+}
+
+// DefaultLimit caps query results when a Filter sets no limit.
+const DefaultLimit = 100
+
+// ErrNotQueryable is returned by Query when no sink can read records back.
+var ErrNotQueryable = errors.New("no configured audit sink supports queries")
+
+func (f Filter) matches(rec Record) bool {
+	return (f.Tenant == "" || rec.Tenant == f.Tenant) &&
+		(f.Model == "" || rec.Model == f.Model) &&
+		(f.TaskID == "" || rec.TaskID == f.TaskID) &&
+		(f.Since.IsZero() || !rec.Time.Before(f.Since)) &&
+		(f.Until.IsZero() || rec.Time.Before(f.Until))
+}
+
+func (f Filter) limit() int {
+	if f.Limit <= 0 {
+		return DefaultLimit
+	}
+	return f.Limit
+}
+
+// Redaction modes for prompts and responses.
+const (
+	RedactFull = "full"
+	RedactHash = "hash"
+	RedactNone = "none"
+)
+
+// recordQueueSize bounds the records waiting to be written. When it is
+// full, as when a sink stalls, further records are dropped and counted
+// rather than holding up the tasks completing.
+const recordQueueSize = 1024
+
+// Logger writes audit records to its sinks in the background.
+type Logger struct {
+	sinks     []Sink
+	prompts   string
+	responses string
+
+	// mu guards closed, so that Observe never sends on records once Close
+	// has closed it.
+	mu      sync.RWMutex
+	closed  bool
+	records chan Record
+	done    chan struct{}
+}
+
+// New opens the sinks described by cfg. It returns nil if none are
+// configured; a nil Logger records nothing.
+func New(cfg config.AuditConfig) (*Logger, error) {
+	prompts, err := redaction(cfg.Prompts, RedactHash)
+	if err != nil {
+		return nil, fmt.Errorf("audit prompts: %w", err)
+	}
+	responses, err := redaction(cfg.Responses, RedactFull)
+	if err != nil {
+		return nil, fmt.Errorf("audit responses: %w", err)
+	}
+
+	var sinks []Sink
+	closeAll := func() {
+		for _, s := range sinks {
+			s.Close()
+		}
+	}
+	if cfg.SQLite != nil {
+		s, err := NewSQLiteSink(*cfg.SQLite)
+		if err != nil {
+			return nil, err
+		}
+		sinks = append(sinks, s)
+	}
+	if cfg.JSONL != nil {
+		s, err := NewJSONLSink(*cfg.JSONL)
+		if err != nil {
+			closeAll()
+			return nil, err
+		}
+		sinks = append(sinks, s)
+	}
+	if cfg.Webhook != nil {
+		s, err := NewWebhookSink(*cfg.Webhook)
+		if err != nil {
+			closeAll()
+			return nil, err
+		}
+		sinks = append(sinks, s)
+	}
+	if len(sinks) == 0 {
+		return nil, nil
+	}
+
+	return newLogger(sinks, prompts, responses, recordQueueSize), nil
+}
+
+func newLogger(sinks []Sink, prompts, responses string, queueSize int) *Logger {
+	l := &Logger{
+		sinks:     sinks,
+		prompts:   prompts,
+		responses: responses,
+		records:   make(chan Record, queueSize),
+		done:      make(chan struct{}),
+	}
+	go l.run()
+	return l
+}
+
+func redaction(mode, def string) (string, error) {
+	switch mode {
+	case "":
+		return def, nil
+	case RedactFull, RedactHash, RedactNone:
+		return mode, nil
+	}
+	return "", fmt.Errorf("unknown redaction mode %q", mode)
+}
+
+// Observe records a finished task. It is meant to be registered with
+// worker.OnComplete. It never blocks: records that find the queue full, or
+// arrive after Close, are dropped.
+func (l *Logger) Observe(c worker.Completion) {
+	if l == nil {
+		return
+	}
+
+	rec := Record{
+		Time:      c.Finished,
+		TaskID:    c.Task.TaskID,
+		RequestID: c.Task.RequestID,
+		Tenant:    c.Task.Tenant,
+		Model:     c.Task.ModelCode,
+		LatencyMS: c.Finished.Sub(c.Started).Milliseconds(),
+		Outcome:   c.Outcome,
+	}
+	rec.Prompt, rec.PromptHash = redact(l.prompts, c.Task.Prompt)
+	rec.Response, rec.ResponseHash = redact(l.responses, c.Text)
+	if c.Metadata != nil {
+		rec.Attempts = c.Metadata.Attempts
+		rec.Usage = c.Metadata.Usage
+	}
+	if c.Err != nil {
+		rec.ErrorCode = c.Err.Code
+		rec.Error = c.Err.Message
+	}
+
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	if l.closed {
+		l.drop(rec, "audit log is closed")
+		return
+	}
+	select {
+	case l.records <- rec:
+	default:
+		l.drop(rec, "audit queue is full")
+	}
+}
+
+func (l *Logger) drop(rec Record, reason string) {
+	metrics.AuditRecordsDropped.Inc()
+	slog.Warn("Dropped audit record", "task_id", rec.TaskID, "reason", reason)
+}
+
+// redact returns the text and hash to record for text under mode.
+func redact(mode, text string) (string, string) {
+	switch mode {
+	case RedactFull:
+		return text, ""
+	case RedactHash:
+		sum := sha256.Sum256([]byte(text))
+		return "", hex.EncodeToString(sum[:])
+	}
+	return "", ""
+}
+
+func (l *Logger) run() {
+	defer close(l.done)
+	for rec := range l.records {
+		for _, s := range l.sinks {
+			if err := s.Write(context.Background(), rec); err != nil {
+				slog.Error("Failed to write audit record", "task_id", rec.TaskID, "sink", fmt.Sprintf("%T", s), "error", err)
+			}
+		}
+	}
+}
+
+// Query reads records back from the first sink that supports it.
+func (l *Logger) Query(ctx context.Context, f Filter) ([]Record, error) {
+	if l != nil {
+		for _, s := range l.sinks {
+			if q, ok := s.(Querier); ok {
+				return q.Query(ctx, f)
+			}
+		}
+	}
+	return nil, ErrNotQueryable
+}
+
+// Close writes the records still queued and closes the sinks. Records
+// observed afterwards are dropped.
+func (l *Logger) Close() error {
+	if l == nil {
+		return nil
+	}
+	l.mu.Lock()
+	if l.closed {
+		l.mu.Unlock()
+		return nil
+	}
+	l.closed = true
+	close(l.records)
+	l.mu.Unlock()
+	<-l.done
+
+	var errs []error
+	for _, s := range l.sinks {
+		errs = append(errs, s.Close())
+	}
+	return errors.Join(errs...)
+}
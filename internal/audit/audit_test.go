@@ -0,0 +1,158 @@
+package audit
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/sokinpui/synapse.go/internal/config"
+	"github.com/sokinpui/synapse.go/internal/models"
+	"github.com/sokinpui/synapse.go/internal/worker"
+)
+
+// blockingSink holds every write until release is closed.
+type blockingSink struct {
+	release chan struct{}
+	mu      sync.Mutex
+	written []Record
+}
+
+func (s *blockingSink) Write(_ context.Context, rec Record) error {
+	<-s.release
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.written = append(s.written, rec)
+	return nil
+}
+
+func (s *blockingSink) Close() error { return nil }
+
+func completion(taskID string) worker.Completion {
+	now := time.Now()
+	return worker.Completion{
+		Task:     &models.GenerationTask{TaskID: taskID, ModelCode: "m", Prompt: "secret"},
+		Text:     "answer",
+		Outcome:  "ok",
+		Started:  now,
+		Finished: now,
+	}
+}
+
+func TestObserveDropsWhenQueueIsFull(t *testing.T) {
+	sink := &blockingSink{release: make(chan struct{})}
+	l := newLogger([]Sink{sink}, RedactHash, RedactFull, 1)
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		// One record is held by the stalled sink and one fills the queue;
+		// the rest must be dropped rather than block.
+		for i := range 10 {
+			l.Observe(completion(fmt.Sprint(i)))
+		}
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Observe blocked on a stalled sink")
+	}
+
+	close(sink.release)
+	if err := l.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if n := len(sink.written); n == 0 || n > 2 {
+		t.Errorf("%d records written, want 1 or 2", n)
+	}
+}
+
+func TestObserveAfterCloseIsDropped(t *testing.T) {
+	sink := &blockingSink{release: make(chan struct{})}
+	close(sink.release)
+	l := newLogger([]Sink{sink}, RedactHash, RedactFull, recordQueueSize)
+
+	l.Observe(completion("before"))
+	if err := l.Close(); err != nil {
+		t.Fatal(err)
+	}
+	l.Observe(completion("after"))
+	if err := l.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(sink.written) != 1 || sink.written[0].TaskID != "before" {
+		t.Errorf("written = %+v, want only the record observed before Close", sink.written)
+	}
+	if rec := sink.written[0]; rec.Prompt != "" || rec.PromptHash == "" || rec.Response != "answer" {
+		t.Errorf("record = %+v, want a hashed prompt and the full response", rec)
+	}
+}
+
+func TestJSONLQueryAcrossRotations(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.jsonl")
+	s, err := NewJSONLSink(config.JSONLSinkConfig{Path: path, MaxBackups: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	s.maxSize = 300 // a couple of records per file
+
+	start := time.Now()
+	for i := range 6 {
+		rec := Record{Time: start.Add(time.Duration(i) * time.Second), TaskID: fmt.Sprint(i), Model: "m", Tenant: "acme"}
+		if i%2 == 1 {
+			rec.Tenant = "other"
+		}
+		if err := s.Write(context.Background(), rec); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	recs, err := s.Query(context.Background(), Filter{Tenant: "acme"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var ids []string
+	for _, r := range recs {
+		ids = append(ids, r.TaskID)
+	}
+	if fmt.Sprint(ids) != "[4 2 0]" {
+		t.Errorf("task IDs = %v, want [4 2 0], newest first", ids)
+	}
+
+	recs, err = s.Query(context.Background(), Filter{Limit: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(recs) != 2 || recs[0].TaskID != "5" {
+		t.Errorf("got %+v, want the two newest records", recs)
+	}
+}
+
+func TestJSONLQueryDuringWrites(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.jsonl")
+	s, err := NewJSONLSink(config.JSONLSinkConfig{Path: path, MaxBackups: 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	s.maxSize = 1000
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := range 200 {
+			s.Write(context.Background(), Record{Time: time.Now(), TaskID: fmt.Sprint(i)})
+		}
+	}()
+	for range 20 {
+		if _, err := s.Query(context.Background(), Filter{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	wg.Wait()
+}
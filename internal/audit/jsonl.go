@@ -0,0 +1,190 @@
+package audit
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+
+	"github.com/sokinpui/synapse.go/internal/config"
+)
+
+// JSONLSink appends records as JSON lines to a file, rotating it by size.
+// Rotated files are named path.1, path.2 and so on, path.1 being the most
+// recent.
+type JSONLSink struct {
+	path       string
+	maxSize    int64
+	maxBackups int
+
+	mu   sync.Mutex
+	file *os.File
+	size int64
+}
+
+// NewJSONLSink opens or creates the file at cfg.Path for appending.
+func NewJSONLSink(cfg config.JSONLSinkConfig) (*JSONLSink, error) {
+	if cfg.Path == "" {
+		return nil, errors.New("audit jsonl: path is required")
+	}
+	maxSizeMB := cfg.MaxSizeMB
+	if maxSizeMB <= 0 {
+		maxSizeMB = 100
+	}
+	maxBackups := cfg.MaxBackups
+	if maxBackups <= 0 {
+		maxBackups = 5
+	}
+
+	s := &JSONLSink{path: cfg.Path, maxSize: int64(maxSizeMB) << 20, maxBackups: maxBackups}
+	if err := s.open(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *JSONLSink) open() error {
+	if err := os.MkdirAll(filepath.Dir(s.path), 0o755); err != nil {
+		return fmt.Errorf("audit jsonl: %w", err)
+	}
+	f, err := os.OpenFile(s.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o600)
+	if err != nil {
+		return fmt.Errorf("audit jsonl: %w", err)
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return fmt.Errorf("audit jsonl: %w", err)
+	}
+	s.file, s.size = f, info.Size()
+	return nil
+}
+
+func (s *JSONLSink) Write(_ context.Context, rec Record) error {
+	line, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.size > 0 && s.size+int64(len(line)) > s.maxSize {
+		if err := s.rotate(); err != nil {
+			return err
+		}
+	}
+	n, err := s.file.Write(line)
+	s.size += int64(n)
+	return err
+}
+
+// rotate shifts the backups up by one, dropping the oldest, and starts a
+// new file.
+func (s *JSONLSink) rotate() error {
+	if err := s.file.Close(); err != nil {
+		return fmt.Errorf("audit jsonl: %w", err)
+	}
+	os.Remove(s.backup(s.maxBackups))
+	for i := s.maxBackups - 1; i >= 1; i-- {
+		if err := os.Rename(s.backup(i), s.backup(i+1)); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return fmt.Errorf("audit jsonl: %w", err)
+		}
+	}
+	if err := os.Rename(s.path, s.backup(1)); err != nil {
+		return fmt.Errorf("audit jsonl: %w", err)
+	}
+	return s.open()
+}
+
+func (s *JSONLSink) backup(i int) string {
+	return fmt.Sprintf("%s.%d", s.path, i)
+}
+
+// Query scans the current file and its backups. The files are opened under
+// the lock, so that a concurrent rotation cannot move records between them
+// mid-query, and read outside it, so that writes are not held up.
+func (s *JSONLSink) Query(ctx context.Context, f Filter) ([]Record, error) {
+	files, err := s.openAll()
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		for _, file := range files {
+			file.Close()
+		}
+	}()
+
+	var recs []Record
+	for _, file := range files {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		if err := scanJSONL(file, f, &recs); err != nil {
+			return nil, err
+		}
+	}
+
+	sort.SliceStable(recs, func(i, j int) bool { return recs[i].Time.After(recs[j].Time) })
+	if len(recs) > f.limit() {
+		recs = recs[:f.limit()]
+	}
+	return recs, nil
+}
+
+// openAll opens the current file and those of its backups that exist.
+func (s *JSONLSink) openAll() ([]*os.File, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var files []*os.File
+	for i := 0; i <= s.maxBackups; i++ {
+		path := s.path
+		if i > 0 {
+			path = s.backup(i)
+		}
+		file, err := os.Open(path)
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			for _, f := range files {
+				f.Close()
+			}
+			return nil, fmt.Errorf("audit jsonl: %w", err)
+		}
+		files = append(files, file)
+	}
+	return files, nil
+}
+
+func scanJSONL(file *os.File, f Filter, recs *[]Record) error {
+	sc := bufio.NewScanner(file)
+	sc.Buffer(make([]byte, 64*1024), 64<<20)
+	for sc.Scan() {
+		var rec Record
+		if err := json.Unmarshal(sc.Bytes(), &rec); err != nil {
+			continue // a line cut short by a crash
+		}
+		if f.matches(rec) {
+			*recs = append(*recs, rec)
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return fmt.Errorf("audit jsonl: %w", err)
+	}
+	return nil
+}
+
+func (s *JSONLSink) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.file.Close()
+}
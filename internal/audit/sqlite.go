@@ -0,0 +1,141 @@
+package audit
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/sokinpui/synapse.go/internal/config"
+	"github.com/sokinpui/synapse.go/internal/models"
+	_ "modernc.org/sqlite"
+)
+
+const sqliteSchema = `
+CREATE TABLE IF NOT EXISTS audit (
+	time              INTEGER NOT NULL,
+	task_id           TEXT NOT NULL,
+	request_id        TEXT NOT NULL DEFAULT '',
+	tenant            TEXT NOT NULL DEFAULT '',
+	model             TEXT NOT NULL,
+	prompt            TEXT NOT NULL DEFAULT '',
+	prompt_hash       TEXT NOT NULL DEFAULT '',
+	response          TEXT NOT NULL DEFAULT '',
+	response_hash     TEXT NOT NULL DEFAULT '',
+	prompt_tokens     INTEGER,
+	completion_tokens INTEGER,
+	attempts          INTEGER NOT NULL,
+	latency_ms        INTEGER NOT NULL,
+	outcome           TEXT NOT NULL,
+	error_code        TEXT NOT NULL DEFAULT '',
+	error             TEXT NOT NULL DEFAULT ''
+);
+CREATE INDEX IF NOT EXISTS audit_time ON audit (time);
+CREATE INDEX IF NOT EXISTS audit_tenant_time ON audit (tenant, time);
+CREATE INDEX IF NOT EXISTS audit_task_id ON audit (task_id);
+`
+
+const sqliteColumns = `time, task_id, request_id, tenant, model, prompt, prompt_hash, response, response_hash,
+	prompt_tokens, completion_tokens, attempts, latency_ms, outcome, error_code, error`
+
+// SQLiteSink stores records in a SQLite database.
+type SQLiteSink struct {
+	db *sql.DB
+}
+
+// NewSQLiteSink opens or creates the database at cfg.Path.
+func NewSQLiteSink(cfg config.SQLiteSinkConfig) (*SQLiteSink, error) {
+	if cfg.Path == "" {
+		return nil, errors.New("audit sqlite: path is required")
+	}
+	db, err := sql.Open("sqlite", cfg.Path+"?_pragma=journal_mode(WAL)&_pragma=busy_timeout(5000)")
+	if err != nil {
+		return nil, fmt.Errorf("audit sqlite: %w", err)
+	}
+	if _, err := db.Exec(sqliteSchema); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("audit sqlite: %w", err)
+	}
+	return &SQLiteSink{db: db}, nil
+}
+
+func (s *SQLiteSink) Write(ctx context.Context, rec Record) error {
+	var promptTokens, completionTokens sql.NullInt64
+	if rec.Usage != nil {
+		promptTokens = sql.NullInt64{Int64: int64(rec.Usage.PromptTokens), Valid: true}
+		completionTokens = sql.NullInt64{Int64: int64(rec.Usage.CompletionTokens), Valid: true}
+	}
+	_, err := s.db.ExecContext(ctx, `INSERT INTO audit (`+sqliteColumns+`)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		rec.Time.UnixNano(), rec.TaskID, rec.RequestID, rec.Tenant, rec.Model,
+		rec.Prompt, rec.PromptHash, rec.Response, rec.ResponseHash,
+		promptTokens, completionTokens, rec.Attempts, rec.LatencyMS,
+		rec.Outcome, rec.ErrorCode, rec.Error)
+	return err
+}
+
+func (s *SQLiteSink) Query(ctx context.Context, f Filter) ([]Record, error) {
+	var where []string
+	var args []any
+	add := func(cond string, arg any) {
+		where = append(where, cond)
+		args = append(args, arg)
+	}
+	if f.Tenant != "" {
+		add("tenant = ?", f.Tenant)
+	}
+	if f.Model != "" {
+		add("model = ?", f.Model)
+	}
+	if f.TaskID != "" {
+		add("task_id = ?", f.TaskID)
+	}
+	if !f.Since.IsZero() {
+		add("time >= ?", f.Since.UnixNano())
+	}
+	if !f.Until.IsZero() {
+		add("time < ?", f.Until.UnixNano())
+	}
+
+	query := `SELECT ` + sqliteColumns + ` FROM audit`
+	if len(where) > 0 {
+		query += " WHERE " + strings.Join(where, " AND ")
+	}
+	query += " ORDER BY time DESC LIMIT ?"
+	args = append(args, f.limit())
+
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var recs []Record
+	for rows.Next() {
+		var rec Record
+		var nanos int64
+		var promptTokens, completionTokens sql.NullInt64
+		if err := rows.Scan(&nanos, &rec.TaskID, &rec.RequestID, &rec.Tenant, &rec.Model,
+			&rec.Prompt, &rec.PromptHash, &rec.Response, &rec.ResponseHash,
+			&promptTokens, &completionTokens, &rec.Attempts, &rec.LatencyMS,
+			&rec.Outcome, &rec.ErrorCode, &rec.Error); err != nil {
+			return nil, err
+		}
+		rec.Time = time.Unix(0, nanos).UTC()
+		if promptTokens.Valid {
+			rec.Usage = &models.Usage{
+				PromptTokens:     int(promptTokens.Int64),
+				CompletionTokens: int(completionTokens.Int64),
+				TotalTokens:      int(promptTokens.Int64 + completionTokens.Int64),
+			}
+		}
+		recs = append(recs, rec)
+	}
+	return recs, rows.Err()
+}
+
+func (s *SQLiteSink) Close() error {
+	return s.db.Close()
+}
@@ -0,0 +1,65 @@
+package audit
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/sokinpui/synapse.go/internal/config"
+)
+
+// WebhookSink posts each record as JSON to a URL. It cannot be queried.
+type WebhookSink struct {
+	url     string
+	headers map[string]string
+	client  *http.Client
+}
+
+// NewWebhookSink returns a sink posting to cfg.URL, waiting up to
+// cfg.Timeout (default 10s) for each request.
+func NewWebhookSink(cfg config.WebhookSinkConfig) (*WebhookSink, error) {
+	if cfg.URL == "" {
+		return nil, errors.New("audit webhook: url is required")
+	}
+	timeout := cfg.Timeout
+	if timeout <= 0 {
+		timeout = 10 * time.Second
+	}
+	return &WebhookSink{url: cfg.URL, headers: cfg.Headers, client: &http.Client{Timeout: timeout}}, nil
+}
+
+func (s *WebhookSink) Write(ctx context.Context, rec Record) error {
+	body, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range s.headers {
+		req.Header.Set(k, v)
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("audit webhook: status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+func (s *WebhookSink) Close() error {
+	return nil
+}
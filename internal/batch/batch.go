@@ -0,0 +1,473 @@
+// Package batch implements the OpenAI Batch API. Uploaded JSONL files of
+// requests are run in the background at low priority, and their results
+// written to output files. Files and batch state are kept on disk, so that
+// batches in progress resume after a restart.
+package batch
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/sokinpui/synapse.go/internal/config"
+)
+
+// Batch statuses, as in the OpenAI API.
+const (
+	StatusValidating = "validating"
+	StatusFailed     = "failed"
+	StatusInProgress = "in_progress"
+	StatusFinalizing = "finalizing"
+	StatusCompleted  = "completed"
+	StatusExpired    = "expired"
+	StatusCancelling = "cancelling"
+	StatusCancelled  = "cancelled"
+)
+
+// Purposes of files.
+const (
+	PurposeBatch       = "batch"
+	PurposeBatchOutput = "batch_output"
+)
+
+// EndpointChatCompletions is the only endpoint batches may target.
+const EndpointChatCompletions = "/v1/chat/completions"
+
+// CompletionWindow is the only completion window batches may ask for.
+const CompletionWindow = "24h"
+
+// MaxFileBytes caps the size of uploaded files.
+const MaxFileBytes = 200 << 20
+
+var (
+	// ErrNotFound is returned for files and batches that do not exist, or
+	// belong to another tenant.
+	ErrNotFound = errors.New("not found")
+	// ErrInvalid wraps errors caused by the request rather than the server.
+	ErrInvalid = errors.New("invalid request")
+)
+
+// Causes with which a running batch is stopped.
+var (
+	errCancelled = errors.New("batch cancelled")
+	errExpired   = errors.New("batch expired")
+	errShutdown  = errors.New("server is shutting down")
+)
+
+// File is an uploaded file, or the output of a batch.
+type File struct {
+	ID        string `json:"id"`
+	Object    string `json:"object"`
+	Bytes     int64  `json:"bytes"`
+	CreatedAt int64  `json:"created_at"`
+	Filename  string `json:"filename"`
+	Purpose   string `json:"purpose"`
+}
+
+// Batch is a batch as reported to clients.
+type Batch struct {
+	ID               string            `json:"id"`
+	Object           string            `json:"object"`
+	Endpoint         string            `json:"endpoint"`
+	Errors           *Errors           `json:"errors"`
+	InputFileID      string            `json:"input_file_id"`
+	CompletionWindow string            `json:"completion_window"`
+	Status           string            `json:"status"`
+	OutputFileID     string            `json:"output_file_id,omitempty"`
+	ErrorFileID      string            `json:"error_file_id,omitempty"`
+	CreatedAt        int64             `json:"created_at"`
+	InProgressAt     int64             `json:"in_progress_at,omitempty"`
+	ExpiresAt        int64             `json:"expires_at,omitempty"`
+	FinalizingAt     int64             `json:"finalizing_at,omitempty"`
+	CompletedAt      int64             `json:"completed_at,omitempty"`
+	FailedAt         int64             `json:"failed_at,omitempty"`
+	ExpiredAt        int64             `json:"expired_at,omitempty"`
+	CancellingAt     int64             `json:"cancelling_at,omitempty"`
+	CancelledAt      int64             `json:"cancelled_at,omitempty"`
+	RequestCounts    Counts            `json:"request_counts"`
+	Metadata         map[string]string `json:"metadata,omitempty"`
+}
+
+// Counts tracks the progress of a batch.
+type Counts struct {
+	Total     int `json:"total"`
+	Completed int `json:"completed"`
+	Failed    int `json:"failed"`
+}
+
+// Errors lists the problems that failed a batch's validation.
+type Errors struct {
+	Object string      `json:"object"`
+	Data   []LineError `json:"data"`
+}
+
+// LineError is a problem with one line of an input file.
+type LineError struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+	Line    int    `json:"line,omitempty"`
+}
+
+// CreateRequest is the body of POST /v1/batches.
+type CreateRequest struct {
+	InputFileID      string            `json:"input_file_id"`
+	Endpoint         string            `json:"endpoint"`
+	CompletionWindow string            `json:"completion_window"`
+	Metadata         map[string]string `json:"metadata,omitempty"`
+}
+
+// Response is the outcome of one request of a batch.
+type Response struct {
+	StatusCode int
+	RequestID  string
+	Body       any
+}
+
+// Executor runs the body of one batch request on behalf of tenant. It
+// returns an error only if the request was interrupted before it finished,
+// in which case it is run again when the batch resumes.
+type Executor func(ctx context.Context, tenant string, body json.RawMessage) (Response, error)
+
+type fileRecord struct {
+	File
+	Tenant string `json:"tenant,omitempty"`
+}
+
+type batchRecord struct {
+	Batch
+	Tenant string `json:"tenant,omitempty"`
+	// The files results are written to, reported in Batch once it ends.
+	OutputFile string `json:"output_file"`
+	ErrorFile  string `json:"error_file"`
+
+	lastSave time.Time
+}
+
+// Manager stores files and runs batches.
+type Manager struct {
+	dir  string
+	exec Executor
+	sem  chan struct{} // bounds the requests in flight across batches
+
+	ctx  context.Context
+	stop context.CancelCauseFunc
+	wg   sync.WaitGroup
+
+	mu      sync.Mutex
+	files   map[string]*fileRecord
+	batches map[string]*batchRecord
+	running map[string]context.CancelCauseFunc
+}
+
+// New opens the batch store described by cfg and resumes the batches that
+// were in progress. It returns nil if the Batch API is disabled.
+func New(cfg config.BatchConfig, exec Executor) (*Manager, error) {
+	if cfg.Dir == "" {
+		return nil, nil
+	}
+	concurrency := cfg.Concurrency
+	if concurrency <= 0 {
+		concurrency = 8
+	}
+	for _, sub := range []string{"files", "batches"} {
+		if err := os.MkdirAll(filepath.Join(cfg.Dir, sub), 0o755); err != nil {
+			return nil, fmt.Errorf("batch store: %w", err)
+		}
+	}
+
+	ctx, stop := context.WithCancelCause(context.Background())
+	m := &Manager{
+		dir:     cfg.Dir,
+		exec:    exec,
+		sem:     make(chan struct{}, concurrency),
+		ctx:     ctx,
+		stop:    stop,
+		files:   make(map[string]*fileRecord),
+		batches: make(map[string]*batchRecord),
+		running: make(map[string]context.CancelCauseFunc),
+	}
+	if err := loadAll(filepath.Join(cfg.Dir, "files"), m.files); err != nil {
+		return nil, fmt.Errorf("batch store: %w", err)
+	}
+	if err := loadAll(filepath.Join(cfg.Dir, "batches"), m.batches); err != nil {
+		return nil, fmt.Errorf("batch store: %w", err)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, b := range m.batches {
+		if active(b.Status) {
+			slog.Info("Resuming batch", "batch_id", b.ID, "status", b.Status)
+			m.start(b)
+		}
+	}
+	return m, nil
+}
+
+// Close stops the running batches and waits for their requests in flight.
+// The batches stay in progress and resume when the store is opened again.
+func (m *Manager) Close() {
+	if m == nil {
+		return
+	}
+	m.stop(errShutdown)
+	m.wg.Wait()
+}
+
+func active(status string) bool {
+	switch status {
+	case StatusValidating, StatusInProgress, StatusFinalizing, StatusCancelling:
+		return true
+	}
+	return false
+}
+
+func newID(prefix string) string {
+	return prefix + strings.ReplaceAll(uuid.NewString(), "-", "")
+}
+
+// CreateFile stores the content of r as a file of tenant.
+func (m *Manager) CreateFile(tenant, filename, purpose string, r io.Reader) (File, error) {
+	if purpose != PurposeBatch {
+		return File{}, fmt.Errorf("%w: purpose must be %q", ErrInvalid, PurposeBatch)
+	}
+
+	id := newID("file-")
+	tmp, err := os.CreateTemp(filepath.Join(m.dir, "files"), id+".*.tmp")
+	if err != nil {
+		return File{}, err
+	}
+	n, err := io.Copy(tmp, r)
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), m.contentPath(id))
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return File{}, err
+	}
+
+	f := &fileRecord{
+		File: File{
+			ID:        id,
+			Object:    "file",
+			Bytes:     n,
+			CreatedAt: time.Now().Unix(),
+			Filename:  filename,
+			Purpose:   purpose,
+		},
+		Tenant: tenant,
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if err := m.saveFile(f); err != nil {
+		os.Remove(m.contentPath(id))
+		return File{}, err
+	}
+	m.files[id] = f
+	return f.File, nil
+}
+
+// File returns the file id of tenant.
+func (m *Manager) File(tenant, id string) (File, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	f, ok := m.files[id]
+	if !ok || f.Tenant != tenant {
+		return File{}, fmt.Errorf("file %s: %w", id, ErrNotFound)
+	}
+	return f.File, nil
+}
+
+// OpenFile opens the content of the file id of tenant.
+func (m *Manager) OpenFile(tenant, id string) (*os.File, File, error) {
+	f, err := m.File(tenant, id)
+	if err != nil {
+		return nil, File{}, err
+	}
+	content, err := os.Open(m.contentPath(id))
+	if err != nil {
+		return nil, File{}, err
+	}
+	return content, f, nil
+}
+
+// Create starts a batch running the requests of an uploaded file.
+func (m *Manager) Create(tenant string, req CreateRequest) (Batch, error) {
+	if req.Endpoint != EndpointChatCompletions {
+		return Batch{}, fmt.Errorf("%w: endpoint must be %q", ErrInvalid, EndpointChatCompletions)
+	}
+	if req.CompletionWindow != CompletionWindow {
+		return Batch{}, fmt.Errorf("%w: completion_window must be %q", ErrInvalid, CompletionWindow)
+	}
+	input, err := m.File(tenant, req.InputFileID)
+	if err != nil {
+		return Batch{}, fmt.Errorf("%w: input file %s does not exist", ErrInvalid, req.InputFileID)
+	}
+	if input.Purpose != PurposeBatch {
+		return Batch{}, fmt.Errorf("%w: input file %s does not have purpose %q", ErrInvalid, input.ID, PurposeBatch)
+	}
+
+	now := time.Now()
+	b := &batchRecord{
+		Batch: Batch{
+			ID:               newID("batch_"),
+			Object:           "batch",
+			Endpoint:         req.Endpoint,
+			InputFileID:      req.InputFileID,
+			CompletionWindow: req.CompletionWindow,
+			Status:           StatusValidating,
+			CreatedAt:        now.Unix(),
+			ExpiresAt:        now.Add(24 * time.Hour).Unix(),
+			Metadata:         req.Metadata,
+		},
+		Tenant:     tenant,
+		OutputFile: newID("file-"),
+		ErrorFile:  newID("file-"),
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.ctx.Err() != nil {
+		return Batch{}, errShutdown
+	}
+	if err := m.save(b); err != nil {
+		return Batch{}, err
+	}
+	m.batches[b.ID] = b
+	m.start(b)
+	return b.Batch, nil
+}
+
+// Get returns the batch id of tenant.
+func (m *Manager) Get(tenant, id string) (Batch, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	b, ok := m.batches[id]
+	if !ok || b.Tenant != tenant {
+		return Batch{}, fmt.Errorf("batch %s: %w", id, ErrNotFound)
+	}
+	return b.Batch, nil
+}
+
+// List returns up to limit batches of tenant, newest first, starting after
+// the batch with ID after if it is set. It also reports whether there are
+// more.
+func (m *Manager) List(tenant, after string, limit int) ([]Batch, bool) {
+	m.mu.Lock()
+	all := []Batch{}
+	for _, b := range m.batches {
+		if b.Tenant == tenant {
+			all = append(all, b.Batch)
+		}
+	}
+	m.mu.Unlock()
+
+	sort.Slice(all, func(i, j int) bool {
+		if all[i].CreatedAt != all[j].CreatedAt {
+			return all[i].CreatedAt > all[j].CreatedAt
+		}
+		return all[i].ID > all[j].ID
+	})
+	if after != "" {
+		for i, b := range all {
+			if b.ID == after {
+				all = all[i+1:]
+				break
+			}
+		}
+	}
+	if len(all) > limit {
+		return all[:limit], true
+	}
+	return all, false
+}
+
+// Cancel stops the batch id of tenant. Requests in flight are abandoned, and
+// the results so far remain available once the batch is cancelled.
+func (m *Manager) Cancel(tenant, id string) (Batch, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	b, ok := m.batches[id]
+	if !ok || b.Tenant != tenant {
+		return Batch{}, fmt.Errorf("batch %s: %w", id, ErrNotFound)
+	}
+	if !active(b.Status) {
+		return Batch{}, fmt.Errorf("%w: batch %s is %s", ErrInvalid, id, b.Status)
+	}
+	if b.Status != StatusCancelling {
+		b.Status = StatusCancelling
+		b.CancellingAt = time.Now().Unix()
+		if err := m.save(b); err != nil {
+			return Batch{}, err
+		}
+	}
+	if cancel, ok := m.running[id]; ok {
+		cancel(errCancelled)
+	}
+	return b.Batch, nil
+}
+
+func (m *Manager) contentPath(fileID string) string {
+	return filepath.Join(m.dir, "files", fileID+".jsonl")
+}
+
+// save writes the state of b. m.mu must be held.
+func (m *Manager) save(b *batchRecord) error {
+	b.lastSave = time.Now()
+	return writeJSON(filepath.Join(m.dir, "batches", b.ID+".json"), b)
+}
+
+// saveFile writes the metadata of f. m.mu must be held.
+func (m *Manager) saveFile(f *fileRecord) error {
+	return writeJSON(filepath.Join(m.dir, "files", f.ID+".json"), f)
+}
+
+func writeJSON(path string, v any) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// loadAll reads the records in the .json files of dir into records, keyed
+// by their file names.
+func loadAll[T any](dir string, records map[string]*T) error {
+	paths, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return err
+	}
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		rec := new(T)
+		if err := json.Unmarshal(data, rec); err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+		records[strings.TrimSuffix(filepath.Base(path), ".json")] = rec
+	}
+	return nil
+}
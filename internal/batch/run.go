@@ -0,0 +1,407 @@
+package batch
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/sokinpui/synapse.go/internal/logging"
+)
+
+// maxLineErrors caps the validation errors reported for an input file.
+const maxLineErrors = 100
+
+// saveInterval is how often the progress of a running batch is saved.
+const saveInterval = time.Second
+
+// requestLine is one line of an input file.
+type requestLine struct {
+	CustomID string          `json:"custom_id"`
+	Method   string          `json:"method"`
+	URL      string          `json:"url"`
+	Body     json.RawMessage `json:"body"`
+}
+
+// resultLine is one line of an output or error file.
+type resultLine struct {
+	ID       string          `json:"id"`
+	CustomID string          `json:"custom_id"`
+	Response *resultResponse `json:"response"`
+	Error    any             `json:"error"`
+}
+
+type resultResponse struct {
+	StatusCode int    `json:"status_code"`
+	RequestID  string `json:"request_id"`
+	Body       any    `json:"body"`
+}
+
+// start runs b in the background until it ends or the manager closes.
+// m.mu must be held.
+func (m *Manager) start(b *batchRecord) {
+	ctx, cancel := context.WithCancelCause(m.ctx)
+	ctx, cancelExpiry := context.WithDeadlineCause(ctx, time.Unix(b.ExpiresAt, 0), errExpired)
+	if b.Status == StatusCancelling {
+		cancel(errCancelled)
+	}
+	m.running[b.ID] = cancel
+
+	m.wg.Add(1)
+	go func() {
+		defer m.wg.Done()
+		defer cancelExpiry()
+		defer cancel(nil)
+		m.run(logging.With(ctx, slog.String("batch_id", b.ID)), b)
+
+		m.mu.Lock()
+		delete(m.running, b.ID)
+		m.mu.Unlock()
+	}()
+}
+
+func (m *Manager) run(ctx context.Context, b *batchRecord) {
+	m.mu.Lock()
+	status := b.Status
+	m.mu.Unlock()
+
+	if status == StatusValidating {
+		total, errs, err := m.validate(b)
+		if err == nil && len(errs) == 0 && total == 0 {
+			errs = []LineError{{Code: "empty_file", Message: "the input file has no requests"}}
+		}
+		if err != nil {
+			errs = []LineError{{Code: "file_error", Message: err.Error()}}
+		}
+
+		m.mu.Lock()
+		now := time.Now().Unix()
+		if len(errs) > 0 {
+			b.Status = StatusFailed
+			b.FailedAt = now
+			b.Errors = &Errors{Object: "list", Data: errs}
+		} else {
+			if b.Status == StatusValidating {
+				b.Status = StatusInProgress
+			}
+			b.InProgressAt = now
+			b.RequestCounts.Total = total
+		}
+		m.saveLogged(ctx, b)
+		m.mu.Unlock()
+
+		if len(errs) > 0 {
+			slog.WarnContext(ctx, "Batch failed validation", "errors", len(errs))
+			return
+		}
+		slog.InfoContext(ctx, "Batch started", "requests", total)
+	}
+
+	err := m.execute(ctx, b)
+	m.end(ctx, b, err)
+}
+
+// validate checks every line of b's input file, returning the number of
+// requests and the problems found.
+func (m *Manager) validate(b *batchRecord) (int, []LineError, error) {
+	total := 0
+	seen := map[string]bool{}
+	var errs []LineError
+	fail := func(line int, code, msg string) {
+		if len(errs) < maxLineErrors {
+			errs = append(errs, LineError{Code: code, Message: msg, Line: line})
+		}
+	}
+
+	err := forEachLine(m.contentPath(b.InputFileID), func(n int, line []byte) error {
+		total++
+		var req requestLine
+		if err := json.Unmarshal(line, &req); err != nil {
+			fail(n, "invalid_json", "the line is not valid JSON: "+err.Error())
+			return nil
+		}
+		switch {
+		case req.CustomID == "":
+			fail(n, "missing_custom_id", "custom_id is required")
+		case seen[req.CustomID]:
+			fail(n, "duplicate_custom_id", fmt.Sprintf("custom_id %q is not unique", req.CustomID))
+		case req.Method != "POST":
+			fail(n, "invalid_method", "method must be POST")
+		case req.URL != b.Endpoint:
+			fail(n, "mismatched_url", fmt.Sprintf("url must be the batch's endpoint %s", b.Endpoint))
+		case len(req.Body) == 0:
+			fail(n, "missing_body", "body is required")
+		}
+		seen[req.CustomID] = true
+		return nil
+	})
+	return total, errs, err
+}
+
+// execute runs the requests of b that have no result yet. On return, the
+// requests started have all finished or been abandoned.
+func (m *Manager) execute(ctx context.Context, b *batchRecord) error {
+	done, err := m.resume(b)
+	if err != nil {
+		return err
+	}
+
+	out, err := os.OpenFile(m.contentPath(b.OutputFile), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+	errOut, err := os.OpenFile(m.contentPath(b.ErrorFile), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+	if err != nil {
+		return err
+	}
+	defer errOut.Close()
+
+	var wg sync.WaitGroup
+	defer wg.Wait()
+
+	return forEachLine(m.contentPath(b.InputFileID), func(_ int, line []byte) error {
+		var req requestLine
+		if err := json.Unmarshal(line, &req); err != nil || done[req.CustomID] {
+			return nil
+		}
+		if ctx.Err() != nil {
+			return context.Cause(ctx)
+		}
+		select {
+		case m.sem <- struct{}{}:
+		case <-ctx.Done():
+			return context.Cause(ctx)
+		}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer func() { <-m.sem }()
+
+			resp, err := m.exec(ctx, b.Tenant, req.Body)
+			if err != nil {
+				return
+			}
+			m.record(ctx, b, req.CustomID, resp, out, errOut)
+		}()
+		return nil
+	})
+}
+
+// record writes the result of one request to the output or error file and
+// counts it.
+func (m *Manager) record(ctx context.Context, b *batchRecord, customID string, resp Response, out, errOut *os.File) {
+	data, err := json.Marshal(resultLine{
+		ID:       newID("batch_req_"),
+		CustomID: customID,
+		Response: &resultResponse{StatusCode: resp.StatusCode, RequestID: resp.RequestID, Body: resp.Body},
+	})
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to encode batch result", "custom_id", customID, "error", err)
+		return
+	}
+	data = append(data, '\n')
+
+	failed := resp.StatusCode >= 400
+	dst := out
+	if failed {
+		dst = errOut
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	// Lines are written whole under the lock, so that a crash leaves at
+	// most the last one incomplete.
+	if _, err := dst.Write(data); err != nil {
+		slog.ErrorContext(ctx, "Failed to write batch result", "custom_id", customID, "error", err)
+		return
+	}
+	if failed {
+		b.RequestCounts.Failed++
+	} else {
+		b.RequestCounts.Completed++
+	}
+	if time.Since(b.lastSave) >= saveInterval {
+		m.saveLogged(ctx, b)
+	}
+}
+
+// resume reads the results b already has, after a restart, and returns the
+// custom IDs of the requests they belong to.
+func (m *Manager) resume(b *batchRecord) (map[string]bool, error) {
+	done := map[string]bool{}
+	var counts [2]int
+	for i, id := range []string{b.OutputFile, b.ErrorFile} {
+		path := m.contentPath(id)
+		if err := truncatePartial(path); err != nil {
+			if errors.Is(err, os.ErrNotExist) {
+				continue
+			}
+			return nil, err
+		}
+		err := forEachLine(path, func(_ int, line []byte) error {
+			var res resultLine
+			if json.Unmarshal(line, &res) == nil && !done[res.CustomID] {
+				done[res.CustomID] = true
+				counts[i]++
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	m.mu.Lock()
+	b.RequestCounts.Completed = counts[0]
+	b.RequestCounts.Failed = counts[1]
+	m.mu.Unlock()
+	return done, nil
+}
+
+// end records how b ended, unless it was only interrupted by a shutdown.
+func (m *Manager) end(ctx context.Context, b *batchRecord, err error) {
+	cause := context.Cause(ctx)
+	if errors.Is(cause, errShutdown) {
+		m.mu.Lock()
+		m.saveLogged(ctx, b)
+		m.mu.Unlock()
+		slog.InfoContext(ctx, "Batch interrupted, it resumes on restart")
+		return
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now().Unix()
+	switch {
+	case errors.Is(cause, errCancelled):
+		b.Status = StatusCancelled
+		b.CancelledAt = now
+	case errors.Is(cause, errExpired):
+		b.Status = StatusExpired
+		b.ExpiredAt = now
+	case err != nil:
+		b.Status = StatusFailed
+		b.FailedAt = now
+		b.Errors = &Errors{Object: "list", Data: []LineError{{Code: "file_error", Message: err.Error()}}}
+	default:
+		b.Status = StatusCompleted
+		b.FinalizingAt = now
+		b.CompletedAt = now
+	}
+
+	if b.RequestCounts.Completed > 0 {
+		b.OutputFileID = m.registerOutput(ctx, b, b.OutputFile, "output")
+	}
+	if b.RequestCounts.Failed > 0 {
+		b.ErrorFileID = m.registerOutput(ctx, b, b.ErrorFile, "error")
+	}
+	m.saveLogged(ctx, b)
+	slog.InfoContext(ctx, "Batch ended", "status", b.Status,
+		"completed", b.RequestCounts.Completed, "failed", b.RequestCounts.Failed)
+}
+
+// registerOutput makes a results file of b available for download and
+// returns its ID. m.mu must be held.
+func (m *Manager) registerOutput(ctx context.Context, b *batchRecord, id, kind string) string {
+	info, err := os.Stat(m.contentPath(id))
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to register batch results", "file_id", id, "error", err)
+		return ""
+	}
+	f := &fileRecord{
+		File: File{
+			ID:        id,
+			Object:    "file",
+			Bytes:     info.Size(),
+			CreatedAt: time.Now().Unix(),
+			Filename:  fmt.Sprintf("%s_%s.jsonl", b.ID, kind),
+			Purpose:   PurposeBatchOutput,
+		},
+		Tenant: b.Tenant,
+	}
+	if err := m.saveFile(f); err != nil {
+		slog.ErrorContext(ctx, "Failed to register batch results", "file_id", id, "error", err)
+		return ""
+	}
+	m.files[id] = f
+	return id
+}
+
+// saveLogged saves b, logging failures. m.mu must be held.
+func (m *Manager) saveLogged(ctx context.Context, b *batchRecord) {
+	if err := m.save(b); err != nil {
+		slog.ErrorContext(ctx, "Failed to save batch", "error", err)
+	}
+}
+
+// forEachLine calls fn with each non-blank line of the file at path,
+// numbered from 1, until fn returns an error.
+func forEachLine(path string, fn func(n int, line []byte) error) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	r := bufio.NewReader(f)
+	for n := 1; ; n++ {
+		line, err := r.ReadBytes('\n')
+		if line = bytes.TrimSpace(line); len(line) > 0 {
+			if ferr := fn(n, line); ferr != nil {
+				return ferr
+			}
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// truncatePartial cuts an incomplete last line, left by a crash, off the
+// file at path.
+func truncatePartial(path string) error {
+	f, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	end := info.Size()
+	buf := make([]byte, 4096)
+	for end > 0 {
+		n := int64(len(buf))
+		if end < n {
+			n = end
+		}
+		if _, err := f.ReadAt(buf[:n], end-n); err != nil {
+			return err
+		}
+		if i := bytes.LastIndexByte(buf[:n], '\n'); i >= 0 {
+			end = end - n + int64(i) + 1
+			break
+		}
+		end -= n
+	}
+	if end == info.Size() {
+		return nil
+	}
+	return f.Truncate(end)
+}
@@ -0,0 +1,237 @@
+package batch
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/sokinpui/synapse.go/internal/config"
+)
+
+// recorder is an Executor that answers every request with 200 and keeps
+// the bodies it ran.
+type recorder struct {
+	mu     sync.Mutex
+	bodies []string
+}
+
+func (r *recorder) exec(ctx context.Context, tenant string, body json.RawMessage) (Response, error) {
+	r.mu.Lock()
+	r.bodies = append(r.bodies, string(body))
+	r.mu.Unlock()
+	return Response{StatusCode: 200, RequestID: "req", Body: map[string]any{}}, nil
+}
+
+func (r *recorder) ran() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]string(nil), r.bodies...)
+}
+
+// hang is an Executor whose requests run until they are interrupted,
+// announcing each on started.
+func hang(started chan<- struct{}) Executor {
+	return func(ctx context.Context, tenant string, body json.RawMessage) (Response, error) {
+		started <- struct{}{}
+		<-ctx.Done()
+		return Response{}, ctx.Err()
+	}
+}
+
+func openManager(t *testing.T, dir string, exec Executor) *Manager {
+	t.Helper()
+	m, err := New(config.BatchConfig{Dir: dir, Concurrency: 2}, exec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(m.Close)
+	return m
+}
+
+// requests returns an input file with a request for each custom ID, whose
+// body is the ID.
+func requests(ids ...string) string {
+	var sb strings.Builder
+	for _, id := range ids {
+		fmt.Fprintf(&sb, `{"custom_id": %q, "method": "POST", "url": %q, "body": {"id": %q}}`+"\n", id, EndpointChatCompletions, id)
+	}
+	return sb.String()
+}
+
+func createBatch(t *testing.T, m *Manager, input string) Batch {
+	t.Helper()
+	f, err := m.CreateFile("acme", "input.jsonl", PurposeBatch, strings.NewReader(input))
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := m.Create("acme", CreateRequest{InputFileID: f.ID, Endpoint: EndpointChatCompletions, CompletionWindow: CompletionWindow})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func waitStatus(t *testing.T, m *Manager, id, status string) Batch {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		b, err := m.Get("acme", id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if b.Status == status {
+			return b
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("batch status = %s, want %s", b.Status, status)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// stored reads the saved state of the batch id in dir.
+func stored(t *testing.T, dir, id string) batchRecord {
+	t.Helper()
+	data, err := os.ReadFile(filepath.Join(dir, "batches", id+".json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var b batchRecord
+	if err := json.Unmarshal(data, &b); err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func TestTruncatePartial(t *testing.T) {
+	tests := []struct {
+		name, content, want string
+	}{
+		{"empty", "", ""},
+		{"whole lines", "a\nb\n", "a\nb\n"},
+		{"partial last line", "a\nb\n{\"id\": ", "a\nb\n"},
+		{"only a partial line", "{\"id\": ", ""},
+		{"partial line past a read block", "a\n" + strings.Repeat("x", 5000), "a\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "results.jsonl")
+			if err := os.WriteFile(path, []byte(tt.content), 0o600); err != nil {
+				t.Fatal(err)
+			}
+			if err := truncatePartial(path); err != nil {
+				t.Fatal(err)
+			}
+			if got, _ := os.ReadFile(path); string(got) != tt.want {
+				t.Errorf("content = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestResumeSkipsRecordedRequests(t *testing.T) {
+	dir := t.TempDir()
+	started := make(chan struct{}, 10)
+	m := openManager(t, dir, hang(started))
+	b := createBatch(t, m, requests("done", "fail", "todo"))
+	<-started
+	m.Close()
+
+	// Before the restart, "done" and "fail" got results, and a crash cut
+	// the result of "todo" short. The counts saved lag behind the files.
+	rec := stored(t, dir, b.ID)
+	results := map[string]string{
+		rec.OutputFile: `{"id": "r1", "custom_id": "done", "response": {"status_code": 200}}` + "\n" + `{"id": "r3", "custom_id": "to`,
+		rec.ErrorFile:  `{"id": "r2", "custom_id": "fail", "response": {"status_code": 400}}` + "\n",
+	}
+	for id, content := range results {
+		if err := os.WriteFile(m.contentPath(id), []byte(content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	r := &recorder{}
+	m = openManager(t, dir, r.exec)
+	got := waitStatus(t, m, b.ID, StatusCompleted)
+
+	if ran := r.ran(); len(ran) != 1 || !strings.Contains(ran[0], "todo") {
+		t.Errorf("ran %q, want only the request without a result", ran)
+	}
+	if want := (Counts{Total: 3, Completed: 2, Failed: 1}); got.RequestCounts != want {
+		t.Errorf("counts = %+v, want %+v", got.RequestCounts, want)
+	}
+	output, _ := os.ReadFile(m.contentPath(got.OutputFileID))
+	if lines := strings.Split(strings.TrimSpace(string(output)), "\n"); len(lines) != 2 {
+		t.Errorf("output has %d lines, want 2 whole ones:\n%s", len(lines), output)
+	}
+}
+
+func TestCancelEndsBatch(t *testing.T) {
+	started := make(chan struct{}, 10)
+	m := openManager(t, t.TempDir(), hang(started))
+	b := createBatch(t, m, requests("a", "b"))
+	<-started
+
+	if _, err := m.Cancel("acme", b.ID); err != nil {
+		t.Fatal(err)
+	}
+	got := waitStatus(t, m, b.ID, StatusCancelled)
+	if got.CancellingAt == 0 || got.CancelledAt == 0 {
+		t.Errorf("batch = %+v, want cancelling_at and cancelled_at set", got)
+	}
+	if _, err := m.Cancel("acme", b.ID); err == nil {
+		t.Error("cancelling an ended batch succeeded")
+	}
+}
+
+func TestExpiredBatchEnds(t *testing.T) {
+	dir := t.TempDir()
+	started := make(chan struct{}, 10)
+	m := openManager(t, dir, hang(started))
+	b := createBatch(t, m, requests("done", "todo"))
+	<-started
+	m.Close()
+
+	// The batch is resumed past its completion window, with one result.
+	rec := stored(t, dir, b.ID)
+	rec.ExpiresAt = time.Now().Add(-time.Minute).Unix()
+	if err := writeJSON(filepath.Join(dir, "batches", b.ID+".json"), rec); err != nil {
+		t.Fatal(err)
+	}
+	result := `{"id": "r1", "custom_id": "done", "response": {"status_code": 200}}` + "\n"
+	if err := os.WriteFile(m.contentPath(rec.OutputFile), []byte(result), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	m = openManager(t, dir, hang(started))
+	got := waitStatus(t, m, b.ID, StatusExpired)
+	if got.ExpiredAt == 0 || got.RequestCounts.Completed != 1 || got.OutputFileID == "" {
+		t.Errorf("batch = %+v, want it expired with its one result available", got)
+	}
+}
+
+func TestShutdownLeavesBatchInProgress(t *testing.T) {
+	dir := t.TempDir()
+	started := make(chan struct{}, 10)
+	m := openManager(t, dir, hang(started))
+	b := createBatch(t, m, requests("a"))
+	<-started
+	m.Close()
+
+	if rec := stored(t, dir, b.ID); rec.Status != StatusInProgress || rec.OutputFileID != "" {
+		t.Fatalf("saved batch = %+v, want it still in progress", rec.Batch)
+	}
+
+	r := &recorder{}
+	m = openManager(t, dir, r.exec)
+	got := waitStatus(t, m, b.ID, StatusCompleted)
+	if ran := r.ran(); len(ran) != 1 || got.RequestCounts.Completed != 1 {
+		t.Errorf("after the restart ran %q and counted %+v, want the interrupted request run once", ran, got.RequestCounts)
+	}
+}
@@ -0,0 +1,398 @@
+// Package billing prices the usage of finished tasks and keeps daily spend
+// per tenant, API key and model, against which budgets are enforced.
+package billing
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/sokinpui/synapse.go/internal/config"
+	"github.com/sokinpui/synapse.go/internal/worker"
+	"github.com/sokinpui/synapse.go/model"
+)
+
+// ErrBudgetExceeded is returned by Allow for tenants over budget.
+var ErrBudgetExceeded = errors.New("budget exceeded")
+
+// ErrUnknownTenant is returned by Allow, once budgets are configured, for
+// tenants without one.
+var ErrUnknownTenant = errors.New("unknown tenant")
+
+const dayLayout = "2006-01-02"
+
+// Key identifies one line of the ledger: a day's usage of one model on one
+// API key, on behalf of one tenant. Keys are identified by their index, never
+// their value.
+type Key struct {
+	Day      string `json:"day"`
+	Tenant   string `json:"tenant"`
+	Provider string `json:"provider"`
+	APIKey   int    `json:"key"`
+	Model    string `json:"model"`
+}
+
+// Totals is the usage summed over a ledger line or a group of them.
+type Totals struct {
+	Requests     int     `json:"requests"`
+	InputTokens  int     `json:"input_tokens"`
+	OutputTokens int     `json:"output_tokens"`
+	CachedTokens int     `json:"cached_tokens"`
+	Images       int     `json:"images"`
+	Cost         float64 `json:"cost"`
+}
+
+func (t *Totals) add(o Totals) {
+	t.Requests += o.Requests
+	t.InputTokens += o.InputTokens
+	t.OutputTokens += o.OutputTokens
+	t.CachedTokens += o.CachedTokens
+	t.Images += o.Images
+	t.Cost += o.Cost
+}
+
+// Row is a ledger line, or a group of lines when the unused fields of Key
+// are empty.
+type Row struct {
+	Key
+	Totals
+}
+
+// Ledger records spend in memory, optionally saving it to a file.
+type Ledger struct {
+	prices    map[string]config.Price
+	budgets   map[string]config.Budget
+	statePath string
+
+	mu    sync.Mutex
+	lines map[Key]*Totals
+	dirty bool
+	// spend keeps each tenant's running spend, so that Allow need not sum
+	// the ledger on every request.
+	spend map[string]*tenantSpend
+}
+
+// tenantSpend is a tenant's spend on the latest day and in the latest month
+// it has spent in.
+type tenantSpend struct {
+	day, month     string
+	daily, monthly float64
+}
+
+// add adds cost spent on day. Costs from before the latest day or month
+// only count towards the period they are still part of.
+func (s *tenantSpend) add(day string, cost float64) {
+	switch {
+	case day == s.day:
+		s.daily += cost
+	case day > s.day:
+		s.day, s.daily = day, cost
+	}
+	switch month := day[:len("2006-01")]; {
+	case month == s.month:
+		s.monthly += cost
+	case month > s.month:
+		s.month, s.monthly = month, cost
+	}
+}
+
+// on returns the spend on day and in its month.
+func (s *tenantSpend) on(day string) (daily, monthly float64) {
+	if s.day == day {
+		daily = s.daily
+	}
+	if s.month == day[:len("2006-01")] {
+		monthly = s.monthly
+	}
+	return daily, monthly
+}
+
+// New returns a ledger, loading the spend saved at cfg.StatePath if there
+// is any.
+func New(cfg config.BillingConfig) (*Ledger, error) {
+	l := &Ledger{
+		prices:    cfg.Prices,
+		budgets:   cfg.Budgets,
+		statePath: cfg.StatePath,
+		lines:     make(map[Key]*Totals),
+		spend:     make(map[string]*tenantSpend),
+	}
+	if err := l.load(); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+// Cost prices usage of modelCode by the price table, for providers that do
+// not report a cost of their own.
+func (l *Ledger) Cost(modelCode string, u model.Usage, images int) float64 {
+	price, ok := config.ForModel(l.prices, modelCode)
+	if !ok {
+		return 0
+	}
+	cachedPrice := price.Input
+	if price.Cached != nil {
+		cachedPrice = *price.Cached
+	}
+	uncached := max(u.InputTokens-u.CachedTokens, 0)
+	return (float64(uncached)*price.Input+
+		float64(u.CachedTokens)*cachedPrice+
+		float64(u.OutputTokens)*price.Output)/1e6 +
+		float64(images)*price.Image
+}
+
+// Observe adds the usage of a finished task to the ledger. It is meant to be
+// registered with worker.OnComplete.
+func (l *Ledger) Observe(c worker.Completion) {
+	if len(c.Charges) == 0 {
+		return
+	}
+	day := c.Finished.UTC().Format(dayLayout)
+	images := len(c.Task.Images)
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	for _, ch := range c.Charges {
+		cost := ch.Usage.Cost
+		if cost == 0 {
+			cost = l.Cost(ch.Model, ch.Usage, images)
+		}
+		k := Key{Day: day, Tenant: c.Task.Tenant, Provider: ch.Provider, APIKey: ch.Key, Model: ch.Model}
+		t := l.lines[k]
+		if t == nil {
+			t = &Totals{}
+			l.lines[k] = t
+		}
+		t.add(Totals{
+			Requests:     1,
+			InputTokens:  ch.Usage.InputTokens,
+			OutputTokens: ch.Usage.OutputTokens,
+			CachedTokens: ch.Usage.CachedTokens,
+			Images:       images,
+			Cost:         cost,
+		})
+		l.spendOf(c.Task.Tenant).add(day, cost)
+	}
+	l.dirty = true
+}
+
+// spendOf returns the running spend of tenant. The caller must hold l.mu.
+func (l *Ledger) spendOf(tenant string) *tenantSpend {
+	s := l.spend[tenant]
+	if s == nil {
+		s = &tenantSpend{}
+		l.spend[tenant] = s
+	}
+	return s
+}
+
+// Allow reports whether tenant may submit another task, returning an error
+// wrapping ErrBudgetExceeded if it has spent its daily or monthly budget.
+// Once any budgets are configured, tenants without one, including requests
+// that name no tenant, get an error wrapping ErrUnknownTenant.
+func (l *Ledger) Allow(tenant string) error {
+	if l == nil || len(l.budgets) == 0 {
+		return nil
+	}
+	budget, ok := l.budgets[tenant]
+	if !ok {
+		return fmt.Errorf("%w %q: no budget is configured for it", ErrUnknownTenant, tenant)
+	}
+	if budget.Daily <= 0 && budget.Monthly <= 0 {
+		return nil
+	}
+
+	l.mu.Lock()
+	daily, monthly := l.spendOf(tenant).on(time.Now().UTC().Format(dayLayout))
+	l.mu.Unlock()
+
+	if budget.Daily > 0 && daily >= budget.Daily {
+		return fmt.Errorf("%w: spent $%.4f of the daily budget of $%.2f", ErrBudgetExceeded, daily, budget.Daily)
+	}
+	if budget.Monthly > 0 && monthly >= budget.Monthly {
+		return fmt.Errorf("%w: spent $%.4f of the monthly budget of $%.2f", ErrBudgetExceeded, monthly, budget.Monthly)
+	}
+	return nil
+}
+
+// Dimensions by which Query can group rows.
+const (
+	ByDay      = "day"
+	ByTenant   = "tenant"
+	ByKey      = "key"
+	ByModel    = "model"
+	ByProvider = "provider"
+)
+
+// Filter selects ledger lines. Since and Until are days in the form
+// 2006-01-02, both inclusive. GroupBy lists the dimensions to keep; lines
+// differing only in the others are summed. An empty GroupBy keeps them all.
+type Filter struct {
+	Since   string
+	Until   string
+	Tenant  string
+	Model   string
+	GroupBy []string
+}
+
+// Query returns the matching rows, ordered by day, tenant, provider, key
+// and model. Dimensions left out of the grouping are zero in the rows.
+func (l *Ledger) Query(f Filter) ([]Row, error) {
+	group := map[string]bool{}
+	for _, d := range f.GroupBy {
+		switch d {
+		case ByDay, ByTenant, ByKey, ByModel, ByProvider:
+			group[d] = true
+		default:
+			return nil, fmt.Errorf("unknown group_by dimension %q", d)
+		}
+	}
+	all := len(group) == 0
+	if group[ByKey] {
+		// A key index means nothing without its provider.
+		group[ByProvider] = true
+	}
+
+	sums := map[Key]*Totals{}
+	l.mu.Lock()
+	for k, t := range l.lines {
+		if (f.Since != "" && k.Day < f.Since) || (f.Until != "" && k.Day > f.Until) ||
+			(f.Tenant != "" && k.Tenant != f.Tenant) || (f.Model != "" && k.Model != f.Model) {
+			continue
+		}
+		if !all {
+			k = Key{
+				Day:      pick(group[ByDay], k.Day),
+				Tenant:   pick(group[ByTenant], k.Tenant),
+				Provider: pick(group[ByProvider], k.Provider),
+				Model:    pick(group[ByModel], k.Model),
+				APIKey:   pick(group[ByKey], k.APIKey),
+			}
+		}
+		sum := sums[k]
+		if sum == nil {
+			sum = &Totals{}
+			sums[k] = sum
+		}
+		sum.add(*t)
+	}
+	l.mu.Unlock()
+
+	rows := make([]Row, 0, len(sums))
+	for k, t := range sums {
+		rows = append(rows, Row{Key: k, Totals: *t})
+	}
+	sort.Slice(rows, func(i, j int) bool {
+		a, b := rows[i].Key, rows[j].Key
+		if a.Day != b.Day {
+			return a.Day < b.Day
+		}
+		if a.Tenant != b.Tenant {
+			return a.Tenant < b.Tenant
+		}
+		if a.Provider != b.Provider {
+			return a.Provider < b.Provider
+		}
+		if a.APIKey != b.APIKey {
+			return a.APIKey < b.APIKey
+		}
+		return a.Model < b.Model
+	})
+	return rows, nil
+}
+
+func pick[T any](keep bool, v T) T {
+	if keep {
+		return v
+	}
+	var zero T
+	return zero
+}
+
+// Run saves the ledger every interval until stop is closed, then saves it
+// one last time. It does nothing without a state path.
+func (l *Ledger) Run(interval time.Duration, stop <-chan struct{}) {
+	if l.statePath == "" {
+		return
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if err := l.Save(); err != nil {
+				slog.Error("Failed to save billing ledger", "path", l.statePath, "error", err)
+			}
+		case <-stop:
+			if err := l.Save(); err != nil {
+				slog.Error("Failed to save billing ledger", "path", l.statePath, "error", err)
+			}
+			return
+		}
+	}
+}
+
+// Save writes the ledger to its state path if it changed since the last
+// save.
+func (l *Ledger) Save() error {
+	if l.statePath == "" {
+		return nil
+	}
+
+	l.mu.Lock()
+	if !l.dirty {
+		l.mu.Unlock()
+		return nil
+	}
+	rows := make([]Row, 0, len(l.lines))
+	for k, t := range l.lines {
+		rows = append(rows, Row{Key: k, Totals: *t})
+	}
+	l.dirty = false
+	l.mu.Unlock()
+
+	data, err := json.Marshal(rows)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(l.statePath), 0o755); err != nil {
+		return err
+	}
+	tmp := l.statePath + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, l.statePath)
+}
+
+func (l *Ledger) load() error {
+	if l.statePath == "" {
+		return nil
+	}
+	data, err := os.ReadFile(l.statePath)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read billing state: %w", err)
+	}
+
+	var rows []Row
+	if err := json.Unmarshal(data, &rows); err != nil {
+		return fmt.Errorf("failed to parse billing state %s: %w", l.statePath, err)
+	}
+	for _, r := range rows {
+		t := r.Totals
+		l.lines[r.Key] = &t
+		l.spendOf(r.Tenant).add(r.Day, t.Cost)
+	}
+	return nil
+}
@@ -0,0 +1,130 @@
+package billing
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/sokinpui/synapse.go/internal/config"
+	"github.com/sokinpui/synapse.go/internal/models"
+	"github.com/sokinpui/synapse.go/internal/worker"
+	"github.com/sokinpui/synapse.go/model"
+)
+
+func spent(tenant string, cost float64, at time.Time) worker.Completion {
+	return worker.Completion{
+		Task:     &models.GenerationTask{TaskID: "t", Tenant: tenant, ModelCode: "m"},
+		Charges:  []model.Charge{{Provider: "p", Model: "m", Usage: model.Usage{InputTokens: 10, Cost: cost}}},
+		Finished: at,
+	}
+}
+
+func TestAllowRefusesUnknownTenants(t *testing.T) {
+	l, err := New(config.BillingConfig{Budgets: map[string]config.Budget{"acme": {Daily: 1}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := l.Allow("acme"); err != nil {
+		t.Errorf("Allow(acme) = %v, want nil", err)
+	}
+	for _, tenant := range []string{"", "default", "someone-else"} {
+		if err := l.Allow(tenant); !errors.Is(err, ErrUnknownTenant) {
+			t.Errorf("Allow(%q) = %v, want ErrUnknownTenant", tenant, err)
+		}
+	}
+}
+
+func TestAllowWithoutBudgets(t *testing.T) {
+	l, err := New(config.BillingConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := l.Allow("anyone"); err != nil {
+		t.Errorf("Allow = %v, want nil without budgets", err)
+	}
+}
+
+func TestAllowEnforcesDailyAndMonthlyBudgets(t *testing.T) {
+	l, err := New(config.BillingConfig{Budgets: map[string]config.Budget{
+		"daily":   {Daily: 1},
+		"monthly": {Monthly: 2},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now().UTC()
+	yesterday := now.AddDate(0, 0, -1)
+
+	l.Observe(spent("daily", 0.6, yesterday))
+	l.Observe(spent("daily", 0.6, now))
+	if err := l.Allow("daily"); err != nil {
+		t.Errorf("Allow(daily) = %v after $0.60 today, want nil", err)
+	}
+	l.Observe(spent("daily", 0.6, now))
+	if err := l.Allow("daily"); !errors.Is(err, ErrBudgetExceeded) {
+		t.Errorf("Allow(daily) = %v after $1.20 today, want ErrBudgetExceeded", err)
+	}
+
+	l.Observe(spent("monthly", 1.5, now))
+	if err := l.Allow("monthly"); err != nil {
+		t.Errorf("Allow(monthly) = %v after $1.50, want nil", err)
+	}
+	l.Observe(spent("monthly", 0.5, now))
+	if err := l.Allow("monthly"); !errors.Is(err, ErrBudgetExceeded) {
+		t.Errorf("Allow(monthly) = %v after $2, want ErrBudgetExceeded", err)
+	}
+	// Spend of other tenants does not count.
+	l.Observe(spent("other", 100, now))
+	if daily, _ := l.spend["daily"].on(now.Format(dayLayout)); daily != 1.2 {
+		t.Errorf("daily spend = %v, want 1.2", daily)
+	}
+}
+
+func TestTenantSpendRollsOver(t *testing.T) {
+	var s tenantSpend
+	s.add("2026-09-30", 1)
+	s.add("2026-10-01", 2)
+	s.add("2026-09-29", 4) // late, from a previous month
+	s.add("2026-10-01", 3)
+
+	if daily, monthly := s.on("2026-10-01"); daily != 5 || monthly != 5 {
+		t.Errorf("spend on 2026-10-01 = %v, %v; want 5, 5", daily, monthly)
+	}
+	if daily, monthly := s.on("2026-10-02"); daily != 0 || monthly != 5 {
+		t.Errorf("spend on 2026-10-02 = %v, %v; want 0, 5", daily, monthly)
+	}
+	if daily, monthly := s.on("2026-11-01"); daily != 0 || monthly != 0 {
+		t.Errorf("spend on 2026-11-01 = %v, %v; want 0, 0", daily, monthly)
+	}
+}
+
+func TestLedgerReloadsSpend(t *testing.T) {
+	cfg := config.BillingConfig{
+		StatePath: filepath.Join(t.TempDir(), "billing.json"),
+		Budgets:   map[string]config.Budget{"acme": {Daily: 1}},
+	}
+	l, err := New(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	l.Observe(spent("acme", 1, time.Now()))
+	if err := l.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	reloaded, err := New(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := reloaded.Allow("acme"); !errors.Is(err, ErrBudgetExceeded) {
+		t.Errorf("Allow after reload = %v, want ErrBudgetExceeded", err)
+	}
+	rows, err := reloaded.Query(Filter{GroupBy: []string{ByTenant}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 1 || rows[0].Tenant != "acme" || rows[0].Requests != 1 {
+		t.Errorf("rows = %+v, want one request by acme", rows)
+	}
+}
@@ -0,0 +1,123 @@
+package broker
+
+import (
+	"github.com/sokinpui/synapse.go/internal/metrics"
+	"github.com/sokinpui/synapse.go/internal/models"
+)
+
+// flight is one upstream generation shared by identical pending tasks.
+// Only the leader is queued; the results published for it go to the
+// subscribers of every member, the leader included while it stays.
+type flight struct {
+	key     string
+	leader  string
+	members map[string]*models.GenerationTask // by task ID
+	started bool                              // a worker is processing the leader
+	// history holds the results published so far, for members that join
+	// after the generation started.
+	history []models.TaskResult
+}
+
+// coalesceKey returns the key under which task may share a generation with
+// identical tasks of the same tenant, or "" if it may not. Only
+// deterministic tasks, with a temperature of zero, are coalesced.
+func coalesceKey(task *models.GenerationTask) string {
+	if task.Config == nil || task.Config.Temperature == nil || *task.Config.Temperature != 0 {
+		return ""
+	}
+	return task.Fingerprint()
+}
+
+// join adds task to the flight of an identical pending task and reports
+// whether it did; if not, and task can be coalesced, task leads a new
+// flight. b.mu must be held.
+func (b *MemoryBroker) join(task *models.GenerationTask) bool {
+	key := coalesceKey(task)
+	if key == "" {
+		return false
+	}
+
+	f, ok := b.flights[key]
+	if !ok {
+		b.lead(key, task)
+		return false
+	}
+
+	// The history is replayed before the subscriber is read from, so it
+	// must fit in the subscriber's buffer.
+	sub := b.subscribers[task.TaskID]
+	if sub == nil || len(f.history) > cap(sub.ch)-len(sub.ch)-1 {
+		return false
+	}
+	for _, msg := range f.history {
+		sub.ch <- msg
+	}
+	f.members[task.TaskID] = task
+	b.memberOf[task.TaskID] = f
+	if f.started {
+		b.setRunning(task.TaskID)
+	}
+	metrics.CoalescedTasks.Inc()
+	return true
+}
+
+func (b *MemoryBroker) lead(key string, task *models.GenerationTask) {
+	f := &flight{key: key, leader: task.TaskID, members: map[string]*models.GenerationTask{task.TaskID: task}}
+	b.flights[key] = f
+	b.leading[task.TaskID] = f
+	b.memberOf[task.TaskID] = f
+}
+
+// fanOut publishes a result of the flight led by id to all its members,
+// ending the flight on the last result. b.mu must be held.
+func (b *MemoryBroker) fanOut(f *flight, msg models.TaskResult) {
+	for id := range f.members {
+		b.observe(id, msg)
+		b.send(id, msg)
+	}
+	if !msg.Done {
+		f.history = append(f.history, msg)
+		return
+	}
+
+	// The flight may have been closed to new members already, and another
+	// have taken its key.
+	if b.flights[f.key] == f {
+		delete(b.flights, f.key)
+	}
+	delete(b.leading, f.leader)
+	for id := range f.members {
+		delete(b.memberOf, id)
+	}
+}
+
+// leave removes the task id from its flight, if it is in one, when its
+// subscriber is gone or it was canceled. It reports whether the flight
+// carries on for other members; if not, the caller should cancel the
+// leader's generation. b.mu must be held.
+func (b *MemoryBroker) leave(id string) (leader string, shared bool) {
+	f, ok := b.memberOf[id]
+	if !ok {
+		return id, false
+	}
+	if len(f.members) == 1 {
+		// Nobody else wants the result, so the generation will be canceled
+		// and must not be joined. The member stays to receive its end.
+		if b.flights[f.key] == f {
+			delete(b.flights, f.key)
+		}
+		return f.leader, false
+	}
+	delete(f.members, id)
+	delete(b.memberOf, id)
+	return f.leader, true
+}
+
+// cancel signals the worker running the task id to stop. b.mu must be held.
+func (b *MemoryBroker) cancel(id string) {
+	if _, ok := b.cancellations[id]; !ok {
+		b.cancellations[id] = make(chan struct{})
+	}
+	close(b.cancellations[id])
+	delete(b.cancellations, id)
+}
@@ -0,0 +1,229 @@
+package broker
+
+import (
+	"testing"
+	"time"
+
+	"github.com/sokinpui/synapse.go/internal/models"
+	"github.com/sokinpui/synapse.go/model"
+)
+
+// identical returns a deterministic task that coalesces with every other
+// task identical returns.
+func identical(id string) *models.GenerationTask {
+	zero := float32(0)
+	return &models.GenerationTask{
+		TaskID:    id,
+		Tenant:    "acme",
+		ModelCode: "m",
+		Prompt:    "What is 2+2?",
+		Config:    &model.Config{Temperature: &zero},
+	}
+}
+
+// submit subscribes to and enqueues each task.
+func submit(t *testing.T, b *MemoryBroker, tasks ...*models.GenerationTask) []chan models.TaskResult {
+	t.Helper()
+	var subs []chan models.TaskResult
+	for _, task := range tasks {
+		subs = append(subs, b.Subscribe(task.TaskID))
+		if err := b.Enqueue(task); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return subs
+}
+
+// next returns the next result on ch, failing the test if none comes.
+func next(t *testing.T, ch <-chan models.TaskResult) models.TaskResult {
+	t.Helper()
+	select {
+	case msg := <-ch:
+		return msg
+	case <-time.After(time.Second):
+		t.Fatal("no result in time")
+		return models.TaskResult{}
+	}
+}
+
+func TestCoalesceJoinAndLeave(t *testing.T) {
+	b := NewMemoryBroker(10)
+	subs := submit(t, b, identical("leader"), identical("member"))
+	if n := b.QueueDepth(); n != 1 {
+		t.Fatalf("queue depth = %d, want only the leader queued", n)
+	}
+
+	b.Started("leader")
+	b.Publish("leader", models.TaskResult{Text: "4"})
+	for i, sub := range subs {
+		if msg := next(t, sub); msg.Text != "4" {
+			t.Errorf("subscriber %d got %+v, want the leader's chunk", i, msg)
+		}
+	}
+
+	// A late joiner gets the output so far replayed.
+	late := submit(t, b, identical("late"))[0]
+	if msg := next(t, late); msg.Text != "4" {
+		t.Errorf("late joiner got %+v, want the replayed chunk", msg)
+	}
+
+	// A member that leaves gets its end at once; the generation goes on.
+	b.SignalCancel("member")
+	if msg := next(t, subs[1]); !msg.Done {
+		t.Errorf("canceled member got %+v, want its final result", msg)
+	}
+	select {
+	case <-b.IsCancelled("leader"):
+		t.Fatal("the shared generation was canceled")
+	default:
+	}
+
+	members := b.PublishFinal("leader", models.TaskResult{Done: true, Metadata: &models.TaskMetadata{}})
+	if len(members) != 1 || members[0].TaskID != "late" {
+		t.Errorf("PublishFinal returned %v, want only the late joiner", members)
+	}
+	for _, sub := range []chan models.TaskResult{subs[0], late} {
+		if msg := next(t, sub); !msg.Done {
+			t.Errorf("got %+v, want the final result", msg)
+		}
+	}
+	if st, _ := b.Status("member"); st.State != StateCanceled {
+		t.Errorf("member state = %s, want %s", st.State, StateCanceled)
+	}
+	if st, _ := b.Status("late"); st.State != StateCompleted {
+		t.Errorf("late joiner state = %s, want %s", st.State, StateCompleted)
+	}
+}
+
+func TestSlowMemberDoesNotHoldUpPublish(t *testing.T) {
+	b := NewMemoryBroker(10)
+	subs := submit(t, b, identical("leader"), identical("member"))
+	leader, member := subs[0], subs[1]
+	b.Started("leader")
+
+	// The member is never read from; the leader reads each result as it
+	// is published.
+	published := make(chan struct{})
+	go func() {
+		defer close(published)
+		for i := 0; i < 2*DefaultSubscriberBuffer; i++ {
+			b.Publish("leader", models.TaskResult{Text: "x"})
+			if msg := <-leader; msg.Text != "x" {
+				t.Errorf("leader got %+v, want chunk %d", msg, i)
+				return
+			}
+		}
+		b.Publish("leader", models.TaskResult{Done: true, Metadata: &models.TaskMetadata{}})
+		if msg := <-leader; !msg.Done {
+			t.Errorf("leader got %+v, want the final result", msg)
+		}
+	}()
+	select {
+	case <-published:
+	case <-time.After(5 * time.Second):
+		t.Fatal("publishing was held up by the slow member")
+	}
+
+	// The member is ended with an error, which it still gets after the
+	// chunks that fit in its buffer, and can leave without a deadlock.
+	var last models.TaskResult
+	for len(member) > 0 {
+		last = <-member
+	}
+	if !last.Done || last.Err == nil || last.Err.Code != models.ErrCodeSlowConsumer {
+		t.Errorf("slow member's last result = %+v, want a %s error", last, models.ErrCodeSlowConsumer)
+	}
+	if st, _ := b.Status("member"); st.State != StateFailed {
+		t.Errorf("member state = %s, want %s", st.State, StateFailed)
+	}
+	b.Unsubscribe("member")
+	b.Unsubscribe("leader")
+}
+
+func TestSlowSubscriberCancelsItsGeneration(t *testing.T) {
+	b := NewMemoryBroker(10)
+	submit(t, b, &models.GenerationTask{TaskID: "solo", ModelCode: "m"})
+	b.Started("solo")
+	canceled := b.IsCancelled("solo")
+
+	for i := 0; i < DefaultSubscriberBuffer; i++ {
+		b.Publish("solo", models.TaskResult{Text: "x"})
+	}
+	select {
+	case <-canceled:
+	default:
+		t.Error("the generation of a dropped subscriber was not canceled")
+	}
+}
+
+func TestSubscriberBufferCutoff(t *testing.T) {
+	b := NewMemoryBroker(10)
+	b.SetSubscriberBuffer(4)
+	sub := submit(t, b, &models.GenerationTask{TaskID: "solo", ModelCode: "m"})[0]
+	b.Started("solo")
+	canceled := b.IsCancelled("solo")
+
+	// Three results fit, keeping the last slot for the final one.
+	for range 3 {
+		b.Publish("solo", models.TaskResult{Text: "x"})
+	}
+	select {
+	case <-canceled:
+		t.Fatal("a subscriber within its buffer was dropped")
+	default:
+	}
+
+	b.Publish("solo", models.TaskResult{Text: "x"})
+	select {
+	case <-canceled:
+	default:
+		t.Error("a subscriber past its buffer was not dropped")
+	}
+	var got []models.TaskResult
+	for len(sub) > 0 {
+		got = append(got, <-sub)
+	}
+	if len(got) != 4 {
+		t.Fatalf("got %d results, want 3 chunks and the error", len(got))
+	}
+	if last := got[3]; !last.Done || last.Err == nil || last.Err.Code != models.ErrCodeSlowConsumer {
+		t.Errorf("last result = %+v, want a %s error", last, models.ErrCodeSlowConsumer)
+	}
+}
+
+func TestLeaderCanceledWhileMembersRemain(t *testing.T) {
+	b := NewMemoryBroker(10)
+	subs := submit(t, b, identical("leader"), identical("member"))
+	b.Started("leader")
+
+	b.SignalCancel("leader")
+	if msg := next(t, subs[0]); !msg.Done {
+		t.Errorf("canceled leader got %+v, want its final result", msg)
+	}
+	select {
+	case <-b.IsCancelled("leader"):
+		t.Fatal("the generation was canceled although a member waits for it")
+	default:
+	}
+
+	b.Publish("leader", models.TaskResult{Text: "4"})
+	members := b.PublishFinal("leader", models.TaskResult{Done: true, Metadata: &models.TaskMetadata{}})
+	if msg := next(t, subs[1]); msg.Text != "4" {
+		t.Errorf("member got %+v, want the chunk", msg)
+	}
+	if msg := next(t, subs[1]); !msg.Done {
+		t.Errorf("member got %+v, want the final result", msg)
+	}
+	if len(members) != 1 || members[0].TaskID != "member" {
+		t.Errorf("PublishFinal returned %v, want the member", members)
+	}
+	if len(subs[0]) != 0 {
+		t.Error("the canceled leader kept receiving results")
+	}
+
+	// With the flight over, an identical task leads a new one.
+	submit(t, b, identical("next"))
+	if n := b.QueueDepth(); n != 2 {
+		t.Errorf("queue depth = %d, want the new task queued", n)
+	}
+}
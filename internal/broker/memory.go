@@ -1,40 +1,174 @@
 package broker
 
 import (
+	"errors"
 	"sync"
+	"time"
 
+	"github.com/sokinpui/synapse.go/internal/metrics"
 	"github.com/sokinpui/synapse.go/internal/models"
 )
 
+// ErrClosed is returned by Enqueue once the broker no longer accepts tasks.
+var ErrClosed = errors.New("broker is closed to new tasks")
+
 type MemoryBroker struct {
 	tasks         chan *models.GenerationTask
-	subscribers   map[string]chan string
+	background    chan *models.GenerationTask
+	subscribers   map[string]*subscriber
 	cancellations map[string]chan struct{}
 	mu            sync.RWMutex
+
+	// subscriberBuffer is the number of results a subscriber may fall
+	// behind by before it is dropped, see send.
+	subscriberBuffer int
+
+	// Identical deterministic tasks share a flight, see coalesce.go.
+	flights  map[string]*flight // by coalescing key
+	leading  map[string]*flight // by leader task ID
+	memberOf map[string]*flight // by member task ID
+
+	// Statuses of pending and recently finished tasks, see status.go.
+	statuses map[string]*taskState
+	finished []finishedTask // in order of finishing
+
+	// enqueueMu is held for reading by Enqueue, so that Close returns only
+	// after every enqueue in progress has finished.
+	enqueueMu sync.RWMutex
+	closed    bool
 }
 
 func NewMemoryBroker(bufferSize int) *MemoryBroker {
 	return &MemoryBroker{
 		tasks:         make(chan *models.GenerationTask, bufferSize),
-		subscribers:   make(map[string]chan string),
+		background:    make(chan *models.GenerationTask, bufferSize),
+		subscribers:   make(map[string]*subscriber),
 		cancellations: make(map[string]chan struct{}),
+		flights:       make(map[string]*flight),
+		leading:       make(map[string]*flight),
+		memberOf:      make(map[string]*flight),
+		statuses:      make(map[string]*taskState),
+
+		subscriberBuffer: DefaultSubscriberBuffer,
 	}
 }
 
-func (b *MemoryBroker) Enqueue(task *models.GenerationTask) {
+// SetSubscriberBuffer sets the number of results a subscriber may fall
+// behind by before it is ended with a slow_consumer error; n < 2 keeps the
+// default. It must be called before the first Subscribe.
+func (b *MemoryBroker) SetSubscriberBuffer(n int) {
+	if n >= 2 {
+		b.subscriberBuffer = n
+	}
+}
+
+// Enqueue queues task, unless an identical deterministic task is already
+// pending, in which case task shares its results instead. The task's
+// subscriber must be registered first.
+func (b *MemoryBroker) Enqueue(task *models.GenerationTask) error {
+	b.enqueueMu.RLock()
+	defer b.enqueueMu.RUnlock()
+
+	if b.closed {
+		return ErrClosed
+	}
+
+	b.mu.Lock()
+	b.track(task)
+	joined := b.join(task)
+	b.mu.Unlock()
+	if joined {
+		return nil
+	}
+
+	task.EnqueuedAt = time.Now()
+	if task.Background {
+		b.background <- task
+		return nil
+	}
 	b.tasks <- task
+	return nil
+}
+
+// Close stops the broker from accepting new tasks. Tasks already queued stay
+// queued, and results keep flowing to subscribers.
+func (b *MemoryBroker) Close() {
+	b.enqueueMu.Lock()
+	defer b.enqueueMu.Unlock()
+	b.closed = true
+}
+
+// Closed reports whether Close has been called.
+func (b *MemoryBroker) Closed() bool {
+	b.enqueueMu.RLock()
+	defer b.enqueueMu.RUnlock()
+	return b.closed
+}
+
+// TakeQueued removes and returns the tasks still waiting in the queues.
+func (b *MemoryBroker) TakeQueued() []*models.GenerationTask {
+	var tasks []*models.GenerationTask
+	for _, queue := range []chan *models.GenerationTask{b.tasks, b.background} {
+	drain:
+		for {
+			select {
+			case task := <-queue:
+				tasks = append(tasks, task)
+			default:
+				break drain
+			}
+		}
+	}
+	return tasks
+}
+
+// QueueDepth returns the number of interactive tasks waiting to be
+// dequeued. Background tasks do not count towards readiness.
+func (b *MemoryBroker) QueueDepth() int {
+	return len(b.tasks)
+}
+
+// Capacity returns the number of tasks the queue holds before Enqueue blocks.
+func (b *MemoryBroker) Capacity() int {
+	return cap(b.tasks)
+}
+
+// Pending returns the number of tasks with a subscriber waiting for results,
+// whether queued or in flight.
+func (b *MemoryBroker) Pending() int {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return len(b.subscribers)
 }
 
 func (b *MemoryBroker) Dequeue() <-chan *models.GenerationTask {
 	return b.tasks
 }
 
-func (b *MemoryBroker) Subscribe(id string) chan string {
+// DequeueBackground returns the queue of background tasks, to be taken from
+// only when Dequeue has none.
+func (b *MemoryBroker) DequeueBackground() <-chan *models.GenerationTask {
+	return b.background
+}
+
+// DefaultSubscriberBuffer is the number of results a subscriber may fall
+// behind by unless SetSubscriberBuffer says otherwise. It leaves room for
+// a long stream to a client that reads in bursts.
+const DefaultSubscriberBuffer = 1024
+
+// subscriber receives the results of a task.
+type subscriber struct {
+	ch chan models.TaskResult
+	// ended is set once the subscriber got its final result.
+	ended bool
+}
+
+func (b *MemoryBroker) Subscribe(id string) chan models.TaskResult {
 	b.mu.Lock()
 	defer b.mu.Unlock()
 
-	ch := make(chan string, 100)
-	b.subscribers[id] = ch
+	ch := make(chan models.TaskResult, b.subscriberBuffer)
+	b.subscribers[id] = &subscriber{ch: ch}
 	return ch
 }
 
@@ -42,35 +176,124 @@ func (b *MemoryBroker) Unsubscribe(id string) {
 	b.mu.Lock()
 	defer b.mu.Unlock()
 
-	if ch, ok := b.subscribers[id]; ok {
-		close(ch)
+	if sub, ok := b.subscribers[id]; ok {
+		close(sub.ch)
 		delete(b.subscribers, id)
 	}
 
-	if cancelCh, ok := b.cancellations[id]; ok {
+	// A generation others are waiting for goes on without this task.
+	leader, shared := b.leave(id)
+	if shared {
+		b.finishStatus(id, StateCanceled)
+		return
+	}
+	b.requestCancel(id)
+	b.requestCancel(leader)
+	if cancelCh, ok := b.cancellations[leader]; ok {
 		close(cancelCh)
-		delete(b.cancellations, id)
+		delete(b.cancellations, leader)
 	}
 }
 
-func (b *MemoryBroker) Publish(id string, msg string) {
-	b.mu.RLock()
-	defer b.mu.RUnlock()
+func (b *MemoryBroker) Publish(id string, msg models.TaskResult) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
 
-	if ch, ok := b.subscribers[id]; ok {
-		ch <- msg
+	if f, ok := b.leading[id]; ok {
+		b.fanOut(f, msg)
+		return
 	}
+	b.observe(id, msg)
+	b.send(id, msg)
 }
 
+// PublishFinal publishes the final result of the task id, like Publish, and
+// returns the other tasks that shared its generation and got the result.
+func (b *MemoryBroker) PublishFinal(id string, msg models.TaskResult) []*models.GenerationTask {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	f, ok := b.leading[id]
+	if !ok {
+		b.observe(id, msg)
+		b.send(id, msg)
+		return nil
+	}
+	var members []*models.GenerationTask
+	for member, task := range f.members {
+		if member != id {
+			members = append(members, task)
+		}
+	}
+	b.fanOut(f, msg)
+	return members
+}
+
+// send passes msg to the subscriber of the task id without blocking, so
+// that no subscriber holds up the broker. The last slot of a subscriber's
+// buffer is kept for its final result; a subscriber that falls so far
+// behind that only that slot is left is ended with an error and leaves the
+// task, whose generation is canceled unless others share it. b.mu must be
+// held.
+func (b *MemoryBroker) send(id string, msg models.TaskResult) {
+	sub, ok := b.subscribers[id]
+	if !ok || sub.ended {
+		return
+	}
+	if msg.Done || len(sub.ch) < cap(sub.ch)-1 {
+		sub.ended = msg.Done
+		sub.ch <- msg
+		return
+	}
+
+	metrics.SlowSubscribersDropped.Inc()
+	end := models.TaskResult{
+		Err:      &models.TaskError{Code: models.ErrCodeSlowConsumer, Message: "results were not read fast enough"},
+		Done:     true,
+		Metadata: &models.TaskMetadata{},
+	}
+	b.observe(id, end)
+	sub.ended = true
+	sub.ch <- end
+	if leader, shared := b.leave(id); !shared {
+		b.requestCancel(leader)
+		b.cancel(leader)
+	}
+}
+
+// SignalCancel cancels the task id. If it shares its generation with other
+// tasks, only this task stops receiving results, and it gets its final one
+// at once.
 func (b *MemoryBroker) SignalCancel(id string) {
 	b.mu.Lock()
 	defer b.mu.Unlock()
 
-	if _, ok := b.cancellations[id]; !ok {
-		b.cancellations[id] = make(chan struct{})
+	leader, shared := b.leave(id)
+	if !shared {
+		b.requestCancel(id)
+		b.requestCancel(leader)
+		b.cancel(leader)
+		if st, ok := b.statuses[id]; ok && st.State == StateQueued {
+			// The worker skips the task once it dequeues it, which may take
+			// a while; end it now.
+			b.endQueued(id, leader)
+		}
+		return
+	}
+	b.finishStatus(id, StateCanceled)
+	b.send(id, models.TaskResult{Done: true, Metadata: &models.TaskMetadata{}})
+}
+
+// endQueued publishes the final result of the canceled task id, led by
+// leader, before a worker takes it. b.mu must be held.
+func (b *MemoryBroker) endQueued(id, leader string) {
+	msg := models.TaskResult{Done: true, Metadata: &models.TaskMetadata{}}
+	if f, ok := b.leading[leader]; ok {
+		b.fanOut(f, msg)
+		return
 	}
-	close(b.cancellations[id])
-	delete(b.cancellations, id)
+	b.observe(id, msg)
+	b.send(id, msg)
 }
 
 func (b *MemoryBroker) IsCancelled(id string) <-chan struct{} {
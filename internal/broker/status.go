@@ -0,0 +1,162 @@
+package broker
+
+import (
+	"time"
+
+	"github.com/sokinpui/synapse.go/internal/models"
+)
+
+// Task states reported by Status.
+const (
+	StateQueued    = "queued"
+	StateRunning   = "running"
+	StateCompleted = "completed"
+	StateFailed    = "failed"
+	StateCanceled  = "canceled"
+)
+
+// statusRetention is how long the status of a finished task is kept.
+const statusRetention = 10 * time.Minute
+
+// TaskStatus reports where a task is in its lifecycle.
+type TaskStatus struct {
+	TaskID     string            `json:"task_id"`
+	Model      string            `json:"model"`
+	Tenant     string            `json:"tenant,omitempty"`
+	State      string            `json:"state"`
+	Error      *models.TaskError `json:"error,omitempty"`
+	EnqueuedAt time.Time         `json:"enqueued_at"`
+	StartedAt  *time.Time        `json:"started_at,omitempty"`
+	FinishedAt *time.Time        `json:"finished_at,omitempty"`
+}
+
+// Finished reports whether the task has reached a final state.
+func (s TaskStatus) Finished() bool {
+	return s.FinishedAt != nil
+}
+
+type taskState struct {
+	TaskStatus
+	// cancelRequested makes a task that ends without an error canceled.
+	cancelRequested bool
+}
+
+type finishedTask struct {
+	id string
+	at time.Time
+}
+
+// Status returns the status of the task id, if it is pending or finished
+// recently.
+func (b *MemoryBroker) Status(id string) (TaskStatus, bool) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	st, ok := b.statuses[id]
+	if !ok {
+		return TaskStatus{}, false
+	}
+	return st.TaskStatus, true
+}
+
+// Started records that a worker is about to process the task id, and
+// reports whether it should: a task canceled or abandoned by its subscriber
+// while queued is not worth processing.
+func (b *MemoryBroker) Started(id string) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if st, ok := b.statuses[id]; ok && st.cancelRequested {
+		return false
+	}
+	b.setRunning(id)
+	if f, ok := b.leading[id]; ok {
+		f.started = true
+		for member := range f.members {
+			b.setRunning(member)
+		}
+	}
+	return true
+}
+
+// Served records a task that was answered without being queued, for
+// example from the response cache.
+func (b *MemoryBroker) Served(task *models.GenerationTask) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.track(task)
+	b.finishStatus(task.TaskID, StateCompleted)
+}
+
+// track starts the status of task. b.mu must be held.
+func (b *MemoryBroker) track(task *models.GenerationTask) {
+	now := time.Now()
+	for len(b.finished) > 0 && now.Sub(b.finished[0].at) > statusRetention {
+		delete(b.statuses, b.finished[0].id)
+		b.finished = b.finished[1:]
+	}
+
+	b.statuses[task.TaskID] = &taskState{TaskStatus: TaskStatus{
+		TaskID:     task.TaskID,
+		Model:      task.ModelCode,
+		Tenant:     task.Tenant,
+		State:      StateQueued,
+		EnqueuedAt: now,
+	}}
+}
+
+// setRunning moves the task id from queued to running. b.mu must be held.
+func (b *MemoryBroker) setRunning(id string) {
+	st, ok := b.statuses[id]
+	if !ok || st.State != StateQueued {
+		return
+	}
+	now := time.Now()
+	st.State = StateRunning
+	st.StartedAt = &now
+}
+
+// observe updates the status of the task id with a result published for
+// it. b.mu must be held.
+func (b *MemoryBroker) observe(id string, msg models.TaskResult) {
+	st, ok := b.statuses[id]
+	if !ok || st.Finished() {
+		return
+	}
+	if msg.Err != nil {
+		st.Error = msg.Err
+	}
+	if !msg.Done {
+		return
+	}
+	switch {
+	case st.Error != nil:
+		b.finishStatus(id, StateFailed)
+	case st.cancelRequested:
+		b.finishStatus(id, StateCanceled)
+	default:
+		b.finishStatus(id, StateCompleted)
+	}
+}
+
+// requestCancel records that the task id was canceled, or abandoned by its
+// subscriber, before it finished. b.mu must be held.
+func (b *MemoryBroker) requestCancel(id string) {
+	if st, ok := b.statuses[id]; ok {
+		st.cancelRequested = true
+	}
+}
+
+// finishStatus ends the status of the task id in state, unless it has
+// already ended. b.mu must be held.
+func (b *MemoryBroker) finishStatus(id, state string) {
+	st, ok := b.statuses[id]
+	if !ok || st.Finished() {
+		return
+	}
+	now := time.Now()
+	st.State = state
+	st.FinishedAt = &now
+	b.finished = append(b.finished, finishedTask{id: id, at: now})
+}
@@ -0,0 +1,113 @@
+// Package cache stores the results of finished tasks so that identical
+// requests can be answered without calling upstream again.
+package cache
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/sokinpui/synapse.go/internal/config"
+	"github.com/sokinpui/synapse.go/internal/models"
+	"github.com/sokinpui/synapse.go/internal/worker"
+)
+
+// Entry is a cached result.
+type Entry struct {
+	Text     string               `json:"text"`
+	Metadata *models.TaskMetadata `json:"metadata,omitempty"`
+	Created  time.Time            `json:"created"`
+}
+
+// Store holds entries by key. Implementations must be safe for concurrent
+// use.
+type Store interface {
+	Get(key string) (Entry, bool)
+	Put(key string, e Entry)
+	Delete(key string)
+}
+
+// Cache is a Store whose entries expire after a TTL.
+type Cache struct {
+	store Store
+	ttl   time.Duration
+}
+
+// New returns the cache described by cfg, or nil if caching is disabled. A
+// nil Cache misses every lookup and stores nothing.
+func New(cfg config.CacheConfig) (*Cache, error) {
+	var store Store
+	switch cfg.Backend {
+	case "":
+		return nil, nil
+	case "memory":
+		maxEntries := cfg.MaxEntries
+		if maxEntries <= 0 {
+			maxEntries = 10000
+		}
+		store = NewLRU(maxEntries)
+	case "disk":
+		disk, err := NewDisk(cfg.Dir, cfg.TTL)
+		if err != nil {
+			return nil, err
+		}
+		store = disk
+	default:
+		return nil, fmt.Errorf("unknown cache backend %q", cfg.Backend)
+	}
+	return &Cache{store: store, ttl: cfg.TTL}, nil
+}
+
+// Get returns the live entry under key.
+func (c *Cache) Get(key string) (Entry, bool) {
+	if c == nil {
+		return Entry{}, false
+	}
+	e, ok := c.store.Get(key)
+	if !ok {
+		return Entry{}, false
+	}
+	if c.ttl > 0 && time.Since(e.Created) > c.ttl {
+		c.store.Delete(key)
+		return Entry{}, false
+	}
+	return e, true
+}
+
+// Observe caches the result of a task that succeeded and carries a cache
+// key. It is meant to be registered with worker.OnComplete.
+func (c *Cache) Observe(comp worker.Completion) {
+	if c == nil || comp.Task.CacheKey == "" || comp.Outcome != "ok" {
+		return
+	}
+	c.store.Put(comp.Task.CacheKey, Entry{Text: comp.Text, Metadata: comp.Metadata, Created: comp.Finished})
+}
+
+// Chunks splits a cached text into pieces, for replaying it as a stream.
+// Pieces end at whitespace where possible.
+func Chunks(text string) []string {
+	const size = 64
+
+	var chunks []string
+	for len(text) > size {
+		cut := strings.LastIndexAny(text[:size], " \n\t")
+		if cut <= 0 {
+			cut = size
+			for cut < len(text) && !utf8Start(text[cut]) {
+				cut++
+			}
+		} else {
+			cut++
+		}
+		chunks = append(chunks, text[:cut])
+		text = text[cut:]
+	}
+	if text != "" {
+		chunks = append(chunks, text)
+	}
+	return chunks
+}
+
+func utf8Start(b byte) bool {
+	return b&0xC0 != 0x80
+}
@@ -0,0 +1,153 @@
+package cache
+
+import (
+	"container/list"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// LRU is an in-memory Store that evicts the least recently used entry once
+// it holds maxEntries.
+type LRU struct {
+	maxEntries int
+
+	mu      sync.Mutex
+	order   *list.List // front is most recently used
+	entries map[string]*list.Element
+}
+
+type lruItem struct {
+	key   string
+	entry Entry
+}
+
+func NewLRU(maxEntries int) *LRU {
+	return &LRU{maxEntries: maxEntries, order: list.New(), entries: make(map[string]*list.Element)}
+}
+
+func (c *LRU) Get(key string) (Entry, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	el, ok := c.entries[key]
+	if !ok {
+		return Entry{}, false
+	}
+	c.order.MoveToFront(el)
+	return el.Value.(*lruItem).entry, true
+}
+
+func (c *LRU) Put(key string, e Entry) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if el, ok := c.entries[key]; ok {
+		el.Value.(*lruItem).entry = e
+		c.order.MoveToFront(el)
+		return
+	}
+	c.entries[key] = c.order.PushFront(&lruItem{key: key, entry: e})
+	for c.order.Len() > c.maxEntries {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*lruItem).key)
+	}
+}
+
+func (c *LRU) Delete(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if el, ok := c.entries[key]; ok {
+		c.order.Remove(el)
+		delete(c.entries, key)
+	}
+}
+
+// Disk is a Store keeping one JSON file per entry in a directory, so that
+// the cache survives restarts.
+type Disk struct {
+	dir string
+}
+
+// NewDisk opens the cache directory, creating it if needed, and removes
+// entries older than ttl.
+func NewDisk(dir string, ttl time.Duration) (*Disk, error) {
+	if dir == "" {
+		return nil, errors.New("disk cache: dir is required")
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("disk cache: %w", err)
+	}
+	d := &Disk{dir: dir}
+	if ttl > 0 {
+		go d.sweep(ttl)
+	}
+	return d, nil
+}
+
+func (d *Disk) path(key string) string {
+	return filepath.Join(d.dir, key+".json")
+}
+
+func (d *Disk) Get(key string) (Entry, bool) {
+	data, err := os.ReadFile(d.path(key))
+	if err != nil {
+		return Entry{}, false
+	}
+	var e Entry
+	if err := json.Unmarshal(data, &e); err != nil {
+		return Entry{}, false
+	}
+	return e, true
+}
+
+func (d *Disk) Put(key string, e Entry) {
+	data, err := json.Marshal(e)
+	if err != nil {
+		return
+	}
+	tmp, err := os.CreateTemp(d.dir, key+".*.tmp")
+	if err != nil {
+		slog.Error("Failed to write cache entry", "error", err)
+		return
+	}
+	_, err = tmp.Write(data)
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), d.path(key))
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		slog.Error("Failed to write cache entry", "error", err)
+	}
+}
+
+func (d *Disk) Delete(key string) {
+	os.Remove(d.path(key))
+}
+
+// sweep removes entries last written more than ttl ago.
+func (d *Disk) sweep(ttl time.Duration) {
+	entries, err := os.ReadDir(d.dir)
+	if err != nil {
+		return
+	}
+	for _, de := range entries {
+		if !strings.HasSuffix(de.Name(), ".json") {
+			continue
+		}
+		if info, err := de.Info(); err == nil && time.Since(info.ModTime()) > ttl {
+			os.Remove(filepath.Join(d.dir, de.Name()))
+		}
+	}
+}
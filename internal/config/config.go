@@ -1,8 +1,9 @@
 package config
 
 import (
-	"log"
+	"log/slog"
 	"os"
+	"time"
 
 	"gopkg.in/yaml.v3"
 )
@@ -10,18 +11,237 @@ import (
 type Config struct {
 	Server struct {
 		HTTPPort int `yaml:"http_port"`
+		// DrainTimeout bounds how long shutdown waits for tasks in flight.
+		DrainTimeout time.Duration `yaml:"drain_timeout"`
+		// ReadyQueueDepth is the queue depth at which the server reports
+		// itself not ready. Zero means 90% of the queue capacity.
+		ReadyQueueDepth int `yaml:"ready_queue_depth"`
+		// SubscriberBuffer is the number of results a client may fall
+		// behind by before it is ended with a slow_consumer error. Zero
+		// means 1024.
+		SubscriberBuffer int `yaml:"subscriber_buffer"`
+		// AdminToken is the bearer token required by the /admin endpoints,
+		// which are disabled without one. SYNAPSE_ADMIN_TOKEN overrides it.
+		AdminToken string `yaml:"admin_token"`
+		// Tenants maps tenant names to their API keys. When set, every
+		// API request must carry one of the keys, and the tenant it
+		// belongs to replaces any X-Tenant-ID header the request sent.
+		Tenants map[string]string `yaml:"tenants"`
 	} `yaml:"server"`
-	Worker struct {
-		ConcurrencyMultiplier int `yaml:"concurrency_multiplier"`
-	} `yaml:"worker"`
-	Models struct {
-		Gemini     ProviderConfig `yaml:"gemini"`
-		OpenRouter ProviderConfig `yaml:"openrouter"`
-	} `yaml:"models"`
+	Worker    WorkerConfig    `yaml:"worker"`
+	Models    ModelsConfig    `yaml:"models"`
+	Tracing   TracingConfig   `yaml:"tracing"`
+	Logging   LoggingConfig   `yaml:"logging"`
+	Audit     AuditConfig     `yaml:"audit"`
+	Billing   BillingConfig   `yaml:"billing"`
+	Cache     CacheConfig     `yaml:"cache"`
+	Batch     BatchConfig     `yaml:"batch"`
+	Responses ResponsesConfig `yaml:"responses"`
 }
 
+// BatchConfig configures the OpenAI-compatible Batch API, which keeps
+// uploaded files, batches and their results in Dir and is disabled without
+// one. Concurrency caps the batch requests in flight at once, across all
+// batches (default 8).
+type BatchConfig struct {
+	Dir         string `yaml:"dir"`
+	Concurrency int    `yaml:"concurrency"`
+}
+
+// ResponsesConfig configures the in-memory store of the Responses API,
+// which keeps up to MaxStored (default 10000) responses for
+// previous_response_id, evicting the least recently used. Responses expire
+// after TTL, and never if it is zero.
+type ResponsesConfig struct {
+	MaxStored int           `yaml:"max_stored"`
+	TTL       time.Duration `yaml:"ttl"`
+}
+
+// CacheConfig configures the response cache. Backend is "memory", an LRU
+// of up to MaxEntries (default 10000) results, or "disk", one file per
+// result in Dir; without one caching is disabled. Results expire after TTL,
+// and never if it is zero.
+type CacheConfig struct {
+	Backend    string        `yaml:"backend"`
+	TTL        time.Duration `yaml:"ttl"`
+	MaxEntries int           `yaml:"max_entries"`
+	Dir        string        `yaml:"dir"`
+}
+
+// BillingConfig configures cost accounting. Prices are keyed by model code,
+// with a "default" entry for the rest. Budgets are keyed by tenant; once any
+// are set, tenants without one are refused. Costs reported by upstream take
+// precedence over Prices. Spend is kept in StatePath, if set, so that it
+// survives restarts.
+type BillingConfig struct {
+	Prices    map[string]Price  `yaml:"prices"`
+	Budgets   map[string]Budget `yaml:"budgets"`
+	StatePath string            `yaml:"state_path"`
+}
+
+// Price is in USD per million tokens, and per image for Image. Cached
+// tokens are billed at Input when Cached is not set.
+type Price struct {
+	Input  float64  `yaml:"input"`
+	Output float64  `yaml:"output"`
+	Cached *float64 `yaml:"cached"`
+	Image  float64  `yaml:"image"`
+}
+
+// Budget caps a tenant's spend in USD per UTC day and month. Zero means no
+// cap.
+type Budget struct {
+	Daily   float64 `yaml:"daily"`
+	Monthly float64 `yaml:"monthly"`
+}
+
+// AuditConfig configures the audit log, which records every task the
+// worker finishes to each configured sink. Prompts and Responses choose how
+// much of the text to keep: "full", "hash" (a SHA-256 digest) or "none".
+// Prompts default to "hash" and responses to "full".
+type AuditConfig struct {
+	Prompts   string             `yaml:"prompts"`
+	Responses string             `yaml:"responses"`
+	JSONL     *JSONLSinkConfig   `yaml:"jsonl"`
+	SQLite    *SQLiteSinkConfig  `yaml:"sqlite"`
+	Webhook   *WebhookSinkConfig `yaml:"webhook"`
+}
+
+// JSONLSinkConfig writes audit records as JSON lines to Path, rotating it
+// once it exceeds MaxSizeMB (default 100) and keeping MaxBackups old files
+// (default 5).
+type JSONLSinkConfig struct {
+	Path       string `yaml:"path"`
+	MaxSizeMB  int    `yaml:"max_size_mb"`
+	MaxBackups int    `yaml:"max_backups"`
+}
+
+// SQLiteSinkConfig writes audit records to a SQLite database at Path.
+type SQLiteSinkConfig struct {
+	Path string `yaml:"path"`
+}
+
+// WebhookSinkConfig posts each audit record as JSON to URL.
+type WebhookSinkConfig struct {
+	URL     string            `yaml:"url"`
+	Headers map[string]string `yaml:"headers"`
+	Timeout time.Duration     `yaml:"timeout"`
+}
+
+// LoggingConfig configures the server's logs. Format is "text" (the
+// default) or "json", and Level one of debug, info, warn or error. Debug
+// logs at debug level and, unlike the level alone, includes full API keys
+// and prompts, so it should not be left on in production.
+type LoggingConfig struct {
+	Format string `yaml:"format"`
+	Level  string `yaml:"level"`
+	Debug  bool   `yaml:"debug"`
+}
+
+// TracingConfig configures OpenTelemetry tracing. Spans are exported over
+// OTLP/HTTP to Endpoint, a URL such as http://localhost:4318/v1/traces;
+// without one tracing is disabled. SampleRatio samples that fraction of new
+// traces, and zero means all of them.
+type TracingConfig struct {
+	Endpoint    string            `yaml:"endpoint"`
+	Headers     map[string]string `yaml:"headers"`
+	ServiceName string            `yaml:"service_name"`
+	SampleRatio float64           `yaml:"sample_ratio"`
+}
+
+type WorkerConfig struct {
+	ConcurrencyMultiplier int            `yaml:"concurrency_multiplier"`
+	MaxInFlight           InFlightLimits `yaml:"max_in_flight"`
+	Timeouts              Timeouts       `yaml:"timeouts"`
+	Hedging               Hedging        `yaml:"hedging"`
+}
+
+// Hedging configures hedged requests for tasks that opt in. If the first
+// attempt has not responded within Delay, a second one is sent to the
+// model's sibling, or to the same model on another API key.
+type Hedging struct {
+	Delay    time.Duration     `yaml:"delay"`
+	Siblings map[string]string `yaml:"siblings"`
+}
+
+// Timeouts are the default limits on a task; requests may override them.
+// Request bounds the whole task, FirstToken the wait for a stream's first
+// chunk and Idle the gap between chunks. Zero means no limit.
+type Timeouts struct {
+	Request    time.Duration `yaml:"request"`
+	FirstToken time.Duration `yaml:"first_token"`
+	Idle       time.Duration `yaml:"idle"`
+}
+
+// InFlightLimits caps the number of tasks running at once against a single
+// model or provider. Missing or non-positive entries mean no limit.
+type InFlightLimits struct {
+	Providers map[string]int `yaml:"providers"`
+	Models    map[string]int `yaml:"models"`
+}
+
+type ModelsConfig struct {
+	Gemini     ProviderConfig `yaml:"gemini"`
+	OpenRouter ProviderConfig `yaml:"openrouter"`
+	// OpenAI is any OpenAI-compatible API, at BaseURL. Only its embedding
+	// models are used.
+	OpenAI ProviderConfig `yaml:"openai"`
+}
+
+// Provider returns the configuration of the named model provider.
+func (m *ModelsConfig) Provider(name string) ProviderConfig {
+	switch name {
+	case "gemini":
+		return m.Gemini
+	case "openrouter":
+		return m.OpenRouter
+	case "openai":
+		return m.OpenAI
+	}
+	return ProviderConfig{}
+}
+
+// ProviderConfig configures a model provider. The per-model maps are keyed by
+// model code; their "default" entry applies to models without one of their
+// own, see ForModel.
 type ProviderConfig struct {
-	Codes []string `yaml:"codes"`
+	Codes      []string               `yaml:"codes"`
+	RateLimits map[string]RateLimit   `yaml:"rate_limits"`
+	Retry      map[string]RetryPolicy `yaml:"retry"`
+	// CircuitBreaker is keyed by model code like the maps above.
+	CircuitBreaker map[string]BreakerPolicy `yaml:"circuit_breaker"`
+	// EmbeddingCodes are the provider's embedding models.
+	EmbeddingCodes []string `yaml:"embedding_codes"`
+	// BaseURL overrides the provider's API endpoint, for providers that
+	// speak a common protocol.
+	BaseURL string `yaml:"base_url"`
+}
+
+// ForModel returns the entry for modelCode, falling back to "default".
+func ForModel[T any](m map[string]T, modelCode string) (T, bool) {
+	if v, ok := m[modelCode]; ok {
+		return v, true
+	}
+	v, ok := m["default"]
+	return v, ok
+}
+
+// RateLimit is the upstream budget of a single API key for a single model.
+// Zero values mean unlimited.
+type RateLimit struct {
+	RPM int `yaml:"rpm"`
+	TPM int `yaml:"tpm"`
+}
+
+// RetryPolicy controls retries of transient upstream failures for a model.
+// MaxAttempts bounds the retry rounds only; within a round a provider still
+// fails over through all of its API keys. RetryOn lists the retriable error
+// classes: "server_error", "timeout", "rate_limit" and "network".
+type RetryPolicy struct {
+	MaxAttempts int           `yaml:"max_attempts"`
+	BaseDelay   time.Duration `yaml:"base_delay"`
+	MaxDelay    time.Duration `yaml:"max_delay"`
+	RetryOn     []string      `yaml:"retry_on"`
 }
 
 // Load reads configuration from the YAML file.
@@ -29,14 +249,30 @@ func Load() *Config {
 	path := "config.yaml"
 	data, err := os.ReadFile(path)
 	if err != nil {
-		log.Fatalf("failed to read config file at %s: %v. Make sure it exists.", path, err)
+		slog.Error("Failed to read config file. Make sure it exists.", "path", path, "error", err)
+		os.Exit(1)
 	}
 
 	var cfg Config
 	err = yaml.Unmarshal(data, &cfg)
 	if err != nil {
-		log.Fatalf("failed to unmarshal config: %v", err)
+		slog.Error("Failed to unmarshal config", "path", path, "error", err)
+		os.Exit(1)
+	}
+
+	if token := os.Getenv("SYNAPSE_ADMIN_TOKEN"); token != "" {
+		cfg.Server.AdminToken = token
 	}
 
 	return &cfg
 }
+
+// BreakerPolicy configures the circuit breaker of a model, which counts
+// consecutive server errors, timeouts and network failures. A zero
+// FailureThreshold disables it. While the circuit is open, requests go to
+// the Fallback model if one is set and fail fast otherwise.
+type BreakerPolicy struct {
+	FailureThreshold int           `yaml:"failure_threshold"`
+	OpenTimeout      time.Duration `yaml:"open_timeout"`
+	Fallback         string        `yaml:"fallback"`
+}
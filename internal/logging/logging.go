@@ -0,0 +1,116 @@
+// Package logging configures the process-wide slog logger and carries
+// per-request attributes, such as the task ID, in contexts so that every
+// line logged while serving a request is tagged with them.
+package logging
+
+import (
+	"context"
+	"log/slog"
+	"os"
+	"strings"
+
+	"github.com/sokinpui/synapse.go/internal/config"
+)
+
+// debug allows logging secrets and prompts in full. It is set by Setup.
+var debug bool
+
+// Setup installs the default slog logger described by cfg. Lines written
+// through the standard log package end up there too.
+func Setup(cfg config.LoggingConfig) {
+	debug = cfg.Debug
+
+	level := slog.LevelInfo
+	if cfg.Level != "" {
+		if err := level.UnmarshalText([]byte(cfg.Level)); err != nil {
+			slog.Warn("Unknown log level, using info", "level", cfg.Level)
+		}
+	}
+	if cfg.Debug {
+		level = slog.LevelDebug
+	}
+
+	opts := &slog.HandlerOptions{Level: level}
+	var h slog.Handler
+	if strings.EqualFold(cfg.Format, "json") {
+		h = slog.NewJSONHandler(os.Stderr, opts)
+	} else {
+		h = slog.NewTextHandler(os.Stderr, opts)
+	}
+	slog.SetDefault(slog.New(contextHandler{h}))
+}
+
+type attrsKey struct{}
+
+// With returns a context whose log lines carry attrs in addition to those
+// already attached to ctx.
+func With(ctx context.Context, attrs ...slog.Attr) context.Context {
+	prev, _ := ctx.Value(attrsKey{}).([]slog.Attr)
+	merged := make([]slog.Attr, 0, len(prev)+len(attrs))
+	merged = append(merged, prev...)
+	merged = append(merged, attrs...)
+	return context.WithValue(ctx, attrsKey{}, merged)
+}
+
+// contextHandler adds the attributes attached to a context by With to every
+// record logged with that context. Attributes given to the logging call
+// itself take precedence over context attributes with the same key.
+type contextHandler struct {
+	slog.Handler
+}
+
+func (h contextHandler) Handle(ctx context.Context, r slog.Record) error {
+	attrs, _ := ctx.Value(attrsKey{}).([]slog.Attr)
+	if len(attrs) == 0 {
+		return h.Handler.Handle(ctx, r)
+	}
+
+	seen := make(map[string]bool, r.NumAttrs()+len(attrs))
+	r.Attrs(func(a slog.Attr) bool {
+		seen[a.Key] = true
+		return true
+	})
+	// Later calls to With override earlier ones.
+	keep := make([]bool, len(attrs))
+	for i := len(attrs) - 1; i >= 0; i-- {
+		if !seen[attrs[i].Key] {
+			seen[attrs[i].Key] = true
+			keep[i] = true
+		}
+	}
+	for i, a := range attrs {
+		if keep[i] {
+			r.AddAttrs(a)
+		}
+	}
+	return h.Handler.Handle(ctx, r)
+}
+
+func (h contextHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return contextHandler{h.Handler.WithAttrs(attrs)}
+}
+
+func (h contextHandler) WithGroup(name string) slog.Handler {
+	return contextHandler{h.Handler.WithGroup(name)}
+}
+
+// Key returns an attribute for an API key, masked to its last four
+// characters unless debug logging is on.
+func Key(key string) slog.Attr {
+	if debug {
+		return slog.String("key", key)
+	}
+	if len(key) <= 4 {
+		return slog.String("key", "****")
+	}
+	return slog.String("key", "…"+key[len(key)-4:])
+}
+
+// Prompt returns an attribute describing a prompt: its full text if debug
+// logging is on and only its length otherwise.
+func Prompt(prompt string) slog.Attr {
+	if debug {
+		return slog.String("prompt", prompt)
+	}
+	return slog.Int("prompt_chars", len(prompt))
+}
@@ -0,0 +1,151 @@
+// Package metrics defines the Prometheus metrics exported on /metrics.
+package metrics
+
+import (
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "synapse"
+
+// latencyBuckets suit upstream LLM calls, which take from well under a
+// second to several minutes.
+var latencyBuckets = []float64{0.1, 0.25, 0.5, 1, 2.5, 5, 10, 20, 40, 80, 160, 320}
+
+var registry = prometheus.NewRegistry()
+
+var (
+	Requests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "http_requests_total",
+		Help:      "HTTP requests by endpoint, model and status code.",
+	}, []string{"endpoint", "model", "status"})
+
+	RequestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "http_request_duration_seconds",
+		Help:      "Time to serve HTTP requests by endpoint.",
+		Buckets:   latencyBuckets,
+	}, []string{"endpoint"})
+
+	QueueWait = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "queue_wait_seconds",
+		Help:      "Time tasks spent queued, in the broker or parked by the in-flight limiter, before a worker started them.",
+		Buckets:   prometheus.ExponentialBuckets(0.001, 4, 10),
+	})
+
+	WorkersBusy = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "workers_busy",
+		Help:      "Worker goroutines currently processing a task.",
+	})
+
+	ParkedTasks = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "parked_tasks",
+		Help:      "Tasks taken from the queue that wait for an in-flight slot of their model or provider.",
+	})
+
+	TimeToFirstToken = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "time_to_first_token_seconds",
+		Help:      "Time from the start of processing to the first generated chunk, by model.",
+		Buckets:   latencyBuckets,
+	}, []string{"model"})
+
+	GenerationDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "generation_duration_seconds",
+		Help:      "Total time to process a task, by model and outcome.",
+		Buckets:   latencyBuckets,
+	}, []string{"model", "outcome"})
+
+	Tokens = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "tokens_total",
+		Help:      "Tokens reported by upstream, by model and direction (input or output).",
+	}, []string{"model", "direction"})
+
+	KeyFailures = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "key_failures_total",
+		Help:      "Failed upstream calls by provider.",
+	}, []string{"provider"})
+
+	Cancellations = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "cancellations_total",
+		Help:      "Tasks canceled by their client, by model.",
+	}, []string{"model"})
+
+	CacheLookups = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "cache_lookups_total",
+		Help:      "Response cache lookups by result (hit, miss or bypass).",
+	}, []string{"result"})
+
+	CoalescedTasks = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "coalesced_tasks_total",
+		Help:      "Tasks that shared the generation of an identical pending task.",
+	})
+
+	AuditRecordsDropped = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "audit_records_dropped_total",
+		Help:      "Audit records dropped because the audit queue was full or closed.",
+	})
+
+	SlowSubscribersDropped = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "slow_subscribers_dropped_total",
+		Help:      "Task subscribers ended because they fell too far behind the results.",
+	})
+
+	RateLimitWait = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "rate_limit_wait_seconds",
+		Help:      "Time spent waiting for upstream rate budget, by provider and model.",
+		Buckets:   latencyBuckets,
+	}, []string{"provider", "model"})
+)
+
+func init() {
+	registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		Requests,
+		RequestDuration,
+		QueueWait,
+		WorkersBusy,
+		ParkedTasks,
+		TimeToFirstToken,
+		GenerationDuration,
+		Tokens,
+		KeyFailures,
+		Cancellations,
+		RateLimitWait,
+		CacheLookups,
+		CoalescedTasks,
+		AuditRecordsDropped,
+		SlowSubscribersDropped,
+	)
+}
+
+// RegisterQueueDepth exports the broker's queue depth, read on each scrape.
+func RegisterQueueDepth(depth func() int) {
+	registry.MustRegister(prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "queue_depth",
+		Help:      "Tasks waiting in the broker queue.",
+	}, func() float64 { return float64(depth()) }))
+}
+
+// Handler serves the metrics in the Prometheus text format.
+func Handler() http.Handler {
+	return promhttp.HandlerFor(registry, promhttp.HandlerOpts{})
+}
@@ -0,0 +1,83 @@
+package models
+
+// AnthropicMessagesRequest is the body of POST /v1/messages.
+type AnthropicMessagesRequest struct {
+	Model         string             `json:"model"`
+	System        any                `json:"system,omitempty"` // Can be string or []AnthropicContentBlock
+	Messages      []AnthropicMessage `json:"messages"`
+	MaxTokens     int32              `json:"max_tokens"`
+	StopSequences []string           `json:"stop_sequences,omitempty"`
+	Temperature   *float32           `json:"temperature,omitempty"`
+	TopP          *float32           `json:"top_p,omitempty"`
+	TopK          *float32           `json:"top_k,omitempty"`
+	Stream        bool               `json:"stream,omitempty"`
+}
+
+type AnthropicMessage struct {
+	Role    string `json:"role"`
+	Content any    `json:"content"` // Can be string or []AnthropicContentBlock
+}
+
+// AnthropicContentBlock is a block of message content: text, or an image
+// with its Source.
+type AnthropicContentBlock struct {
+	Type   string                `json:"type"`
+	Text   string                `json:"text"`
+	Source *AnthropicImageSource `json:"source,omitempty"`
+}
+
+type AnthropicImageSource struct {
+	Type      string `json:"type"`
+	MediaType string `json:"media_type,omitempty"`
+	Data      string `json:"data,omitempty"`
+}
+
+type AnthropicMessagesResponse struct {
+	ID           string                  `json:"id"`
+	Type         string                  `json:"type"`
+	Role         string                  `json:"role"`
+	Model        string                  `json:"model"`
+	Content      []AnthropicContentBlock `json:"content"`
+	StopReason   *string                 `json:"stop_reason"`
+	StopSequence *string                 `json:"stop_sequence"`
+	Usage        AnthropicUsage          `json:"usage"`
+}
+
+type AnthropicUsage struct {
+	InputTokens  int `json:"input_tokens"`
+	OutputTokens int `json:"output_tokens"`
+}
+
+// AnthropicStreamEvent is an event of a streamed message. Which fields are
+// set depends on Type.
+type AnthropicStreamEvent struct {
+	Type         string                     `json:"type"`
+	Message      *AnthropicMessagesResponse `json:"message,omitempty"`
+	Index        *int                       `json:"index,omitempty"`
+	ContentBlock *AnthropicContentBlock     `json:"content_block,omitempty"`
+	Delta        any                        `json:"delta,omitempty"`
+	Usage        *AnthropicUsage            `json:"usage,omitempty"`
+	Error        *AnthropicError            `json:"error,omitempty"`
+}
+
+// AnthropicTextDelta is the delta of a content_block_delta event.
+type AnthropicTextDelta struct {
+	Type string `json:"type"`
+	Text string `json:"text"`
+}
+
+// AnthropicMessageDelta is the delta of a message_delta event.
+type AnthropicMessageDelta struct {
+	StopReason   *string `json:"stop_reason"`
+	StopSequence *string `json:"stop_sequence"`
+}
+
+type AnthropicErrorResponse struct {
+	Type  string         `json:"type"`
+	Error AnthropicError `json:"error"`
+}
+
+type AnthropicError struct {
+	Type    string `json:"type"`
+	Message string `json:"message"`
+}
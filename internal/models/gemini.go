@@ -0,0 +1,113 @@
+package models
+
+import "encoding/json"
+
+// GeminiGenerateRequest is the body of the Gemini API's generateContent,
+// streamGenerateContent and countTokens methods. Fields are accepted in
+// both the camelCase and the snake_case form of the API.
+type GeminiGenerateRequest struct {
+	Contents          []GeminiContent         `json:"contents"`
+	SystemInstruction *GeminiContent          `json:"systemInstruction,omitempty"`
+	GenerationConfig  *GeminiGenerationConfig `json:"generationConfig,omitempty"`
+
+	SystemInstructionSnake *GeminiContent          `json:"system_instruction,omitempty"`
+	GenerationConfigSnake  *GeminiGenerationConfig `json:"generation_config,omitempty"`
+
+	// GenerateContentRequest is the request whose tokens countTokens
+	// counts, as an alternative to Contents.
+	GenerateContentRequest *GeminiGenerateRequest `json:"generateContentRequest,omitempty"`
+}
+
+type GeminiContent struct {
+	Role  string       `json:"role,omitempty"`
+	Parts []GeminiPart `json:"parts"`
+}
+
+type GeminiPart struct {
+	Text            string      `json:"text,omitempty"`
+	InlineData      *GeminiBlob `json:"inlineData,omitempty"`
+	InlineDataSnake *GeminiBlob `json:"inline_data,omitempty"`
+}
+
+type GeminiBlob struct {
+	MimeType      string `json:"mimeType,omitempty"`
+	MimeTypeSnake string `json:"mime_type,omitempty"`
+	Data          string `json:"data"`
+}
+
+type GeminiGenerationConfig struct {
+	Temperature     *float32 `json:"temperature,omitempty"`
+	TopP            *float32 `json:"topP,omitempty"`
+	TopK            *float32 `json:"topK,omitempty"`
+	MaxOutputTokens int32    `json:"maxOutputTokens,omitempty"`
+	StopSequences   []string `json:"stopSequences,omitempty"`
+	CandidateCount  int      `json:"candidateCount,omitempty"`
+}
+
+// UnmarshalJSON accepts the fields in both the camelCase and the snake_case
+// form of the API, preferring camelCase when a field is given in both.
+func (c *GeminiGenerationConfig) UnmarshalJSON(data []byte) error {
+	type camel GeminiGenerationConfig
+	var v struct {
+		camel
+		TopP            *float32 `json:"top_p"`
+		TopK            *float32 `json:"top_k"`
+		MaxOutputTokens int32    `json:"max_output_tokens"`
+		StopSequences   []string `json:"stop_sequences"`
+		CandidateCount  int      `json:"candidate_count"`
+	}
+	if err := json.Unmarshal(data, &v); err != nil {
+		return err
+	}
+
+	*c = GeminiGenerationConfig(v.camel)
+	if c.TopP == nil {
+		c.TopP = v.TopP
+	}
+	if c.TopK == nil {
+		c.TopK = v.TopK
+	}
+	if c.MaxOutputTokens == 0 {
+		c.MaxOutputTokens = v.MaxOutputTokens
+	}
+	if c.StopSequences == nil {
+		c.StopSequences = v.StopSequences
+	}
+	if c.CandidateCount == 0 {
+		c.CandidateCount = v.CandidateCount
+	}
+	return nil
+}
+
+type GeminiGenerateResponse struct {
+	Candidates    []GeminiCandidate    `json:"candidates"`
+	UsageMetadata *GeminiUsageMetadata `json:"usageMetadata,omitempty"`
+	ModelVersion  string               `json:"modelVersion,omitempty"`
+	ResponseID    string               `json:"responseId,omitempty"`
+}
+
+type GeminiCandidate struct {
+	Content      GeminiContent `json:"content"`
+	FinishReason string        `json:"finishReason,omitempty"`
+	Index        int           `json:"index"`
+}
+
+type GeminiUsageMetadata struct {
+	PromptTokenCount     int `json:"promptTokenCount"`
+	CandidatesTokenCount int `json:"candidatesTokenCount"`
+	TotalTokenCount      int `json:"totalTokenCount"`
+}
+
+type GeminiCountTokensResponse struct {
+	TotalTokens int `json:"totalTokens"`
+}
+
+type GeminiErrorResponse struct {
+	Error GeminiError `json:"error"`
+}
+
+type GeminiError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+	Status  string `json:"status"`
+}
@@ -0,0 +1,34 @@
+package models
+
+import (
+	"encoding/json"
+	"slices"
+	"testing"
+)
+
+func TestGeminiGenerationConfigAcceptsBothCases(t *testing.T) {
+	for _, body := range []string{
+		`{"temperature": 0.5, "topP": 0.9, "topK": 40, "maxOutputTokens": 64, "stopSequences": ["END"], "candidateCount": 1}`,
+		`{"temperature": 0.5, "top_p": 0.9, "top_k": 40, "max_output_tokens": 64, "stop_sequences": ["END"], "candidate_count": 1}`,
+	} {
+		var c GeminiGenerationConfig
+		if err := json.Unmarshal([]byte(body), &c); err != nil {
+			t.Fatal(err)
+		}
+		if c.Temperature == nil || *c.Temperature != 0.5 || c.TopP == nil || *c.TopP != 0.9 || c.TopK == nil || *c.TopK != 40 ||
+			c.MaxOutputTokens != 64 || !slices.Equal(c.StopSequences, []string{"END"}) || c.CandidateCount != 1 {
+			t.Errorf("%s: decoded %+v", body, c)
+		}
+	}
+}
+
+func TestGeminiGenerationConfigPrefersCamelCase(t *testing.T) {
+	var req GeminiGenerateRequest
+	body := `{"contents": [], "generation_config": {"maxOutputTokens": 10, "max_output_tokens": 20}}`
+	if err := json.Unmarshal([]byte(body), &req); err != nil {
+		t.Fatal(err)
+	}
+	if req.GenerationConfigSnake == nil || req.GenerationConfigSnake.MaxOutputTokens != 10 {
+		t.Errorf("decoded %+v, want maxOutputTokens 10", req.GenerationConfigSnake)
+	}
+}
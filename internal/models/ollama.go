@@ -0,0 +1,96 @@
+package models
+
+import "time"
+
+// OllamaOptions are the model options of an Ollama request.
+type OllamaOptions struct {
+	Temperature *float32 `json:"temperature,omitempty"`
+	TopP        *float32 `json:"top_p,omitempty"`
+	TopK        *float32 `json:"top_k,omitempty"`
+	NumPredict  int32    `json:"num_predict,omitempty"`
+	Stop        []string `json:"stop,omitempty"`
+}
+
+// OllamaGenerateRequest is the body of POST /api/generate. Stream defaults
+// to true.
+type OllamaGenerateRequest struct {
+	Model   string         `json:"model"`
+	Prompt  string         `json:"prompt"`
+	System  string         `json:"system,omitempty"`
+	Images  [][]byte       `json:"images,omitempty"`
+	Stream  *bool          `json:"stream,omitempty"`
+	Options *OllamaOptions `json:"options,omitempty"`
+}
+
+// OllamaChatRequest is the body of POST /api/chat. Stream defaults to true.
+type OllamaChatRequest struct {
+	Model    string          `json:"model"`
+	Messages []OllamaMessage `json:"messages"`
+	Stream   *bool           `json:"stream,omitempty"`
+	Options  *OllamaOptions  `json:"options,omitempty"`
+}
+
+type OllamaMessage struct {
+	Role    string   `json:"role"`
+	Content string   `json:"content"`
+	Images  [][]byte `json:"images,omitempty"`
+}
+
+// OllamaResponse is a response, or a streamed chunk of one, of /api/generate
+// (Response) or /api/chat (Message). The counts and durations are set on
+// the last, whose Done is set.
+type OllamaResponse struct {
+	Model              string         `json:"model"`
+	CreatedAt          time.Time      `json:"created_at"`
+	Response           *string        `json:"response,omitempty"`
+	Message            *OllamaMessage `json:"message,omitempty"`
+	Done               bool           `json:"done"`
+	DoneReason         string         `json:"done_reason,omitempty"`
+	TotalDuration      int64          `json:"total_duration,omitempty"`
+	PromptEvalCount    int            `json:"prompt_eval_count,omitempty"`
+	EvalCount          int            `json:"eval_count,omitempty"`
+	PromptEvalDuration int64          `json:"prompt_eval_duration,omitempty"`
+	EvalDuration       int64          `json:"eval_duration,omitempty"`
+}
+
+type OllamaTags struct {
+	Models []OllamaModel `json:"models"`
+}
+
+type OllamaModel struct {
+	Name       string             `json:"name"`
+	Model      string             `json:"model"`
+	ModifiedAt time.Time          `json:"modified_at"`
+	Size       int64              `json:"size"`
+	Digest     string             `json:"digest"`
+	Details    OllamaModelDetails `json:"details"`
+}
+
+type OllamaModelDetails struct {
+	Format            string   `json:"format"`
+	Family            string   `json:"family"`
+	Families          []string `json:"families"`
+	ParameterSize     string   `json:"parameter_size"`
+	QuantizationLevel string   `json:"quantization_level"`
+}
+
+// OllamaShowRequest is the body of POST /api/show. Name is the older form
+// of Model.
+type OllamaShowRequest struct {
+	Model string `json:"model"`
+	Name  string `json:"name"`
+}
+
+type OllamaShowResponse struct {
+	Modelfile    string             `json:"modelfile"`
+	Parameters   string             `json:"parameters"`
+	Template     string             `json:"template"`
+	Details      OllamaModelDetails `json:"details"`
+	ModelInfo    map[string]any     `json:"model_info"`
+	Capabilities []string           `json:"capabilities"`
+	ModifiedAt   time.Time          `json:"modified_at"`
+}
+
+type OllamaErrorResponse struct {
+	Error string `json:"error"`
+}
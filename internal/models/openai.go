@@ -74,3 +74,67 @@ type OpenAIModel struct {
 	Created int64  `json:"created"`
 	OwnedBy string `json:"owned_by"`
 }
+
+type OpenAIErrorResponse struct {
+	Error OpenAIError `json:"error"`
+}
+
+type OpenAIError struct {
+	Message string `json:"message"`
+	Type    string `json:"type"`
+	Code    string `json:"code,omitempty"`
+}
+
+type OpenAICompletionRequest struct {
+	Model       string   `json:"model"`
+	Prompt      any      `json:"prompt"` // Can be string or []string
+	MaxTokens   int32    `json:"max_tokens,omitempty"`
+	Temperature *float32 `json:"temperature,omitempty"`
+	TopP        *float32 `json:"top_p,omitempty"`
+	Stop        any      `json:"stop,omitempty"` // Can be string or []string
+	N           int      `json:"n,omitempty"`
+	Stream      bool     `json:"stream,omitempty"`
+	Echo        bool     `json:"echo,omitempty"`
+}
+
+type OpenAICompletionResponse struct {
+	ID      string             `json:"id"`
+	Object  string             `json:"object"`
+	Created int64              `json:"created"`
+	Model   string             `json:"model"`
+	Choices []CompletionChoice `json:"choices"`
+	Usage   *Usage             `json:"usage,omitempty"`
+}
+
+type CompletionChoice struct {
+	Text         string  `json:"text"`
+	Index        int     `json:"index"`
+	Logprobs     any     `json:"logprobs"`
+	FinishReason *string `json:"finish_reason"`
+}
+
+type OpenAIEmbeddingRequest struct {
+	Model          string `json:"model"`
+	Input          any    `json:"input"` // Can be string or []string
+	Dimensions     int32  `json:"dimensions,omitempty"`
+	EncodingFormat string `json:"encoding_format,omitempty"` // "float" or "base64"
+	User           string `json:"user,omitempty"`
+}
+
+type OpenAIEmbeddingResponse struct {
+	Object string            `json:"object"`
+	Data   []OpenAIEmbedding `json:"data"`
+	Model  string            `json:"model"`
+	Usage  EmbeddingUsage    `json:"usage"`
+}
+
+type OpenAIEmbedding struct {
+	Object    string `json:"object"`
+	Embedding any    `json:"embedding"` // []float32, or a base64 string
+	Index     int    `json:"index"`
+}
+
+type EmbeddingUsage struct {
+	PromptTokens int `json:"prompt_tokens"`
+	TotalTokens  int `json:"total_tokens"`
+}
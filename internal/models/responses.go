@@ -0,0 +1,105 @@
+package models
+
+import "encoding/json"
+
+// ResponseRequest is the body of POST /v1/responses.
+type ResponseRequest struct {
+	Model              string            `json:"model"`
+	Input              any               `json:"input"` // Can be string or []ResponseItem
+	Instructions       string            `json:"instructions,omitempty"`
+	PreviousResponseID string            `json:"previous_response_id,omitempty"`
+	Stream             bool              `json:"stream,omitempty"`
+	Store              *bool             `json:"store,omitempty"`
+	Temperature        *float32          `json:"temperature,omitempty"`
+	TopP               *float32          `json:"top_p,omitempty"`
+	MaxOutputTokens    int32             `json:"max_output_tokens,omitempty"`
+	Metadata           map[string]string `json:"metadata,omitempty"`
+	Tools              []ResponseTool    `json:"tools,omitempty"`
+	ToolChoice         any               `json:"tool_choice,omitempty"` // "none", "auto", "required" or {"type": "function", "name": ...}
+}
+
+// ResponseTool is a tool a response may call. Only functions are supported.
+type ResponseTool struct {
+	Type        string          `json:"type"`
+	Name        string          `json:"name"`
+	Description string          `json:"description,omitempty"`
+	Parameters  json.RawMessage `json:"parameters,omitempty"`
+	Strict      *bool           `json:"strict,omitempty"`
+}
+
+// ResponseItem is an input or output item of a response: a message, a
+// function call or the output of one.
+type ResponseItem struct {
+	Type    string `json:"type,omitempty"`
+	ID      string `json:"id,omitempty"`
+	Status  string `json:"status,omitempty"`
+	Role    string `json:"role,omitempty"`
+	Content any    `json:"content,omitempty"` // Can be string or []ResponseContent
+
+	// Function calls and their outputs.
+	CallID    string `json:"call_id,omitempty"`
+	Name      string `json:"name,omitempty"`
+	Arguments string `json:"arguments,omitempty"`
+	Output    string `json:"output,omitempty"`
+}
+
+// ResponseContent is a content part of a message item.
+type ResponseContent struct {
+	Type        string `json:"type"`
+	Text        string `json:"text"`
+	Annotations []any  `json:"annotations"`
+}
+
+type Response struct {
+	ID                 string            `json:"id"`
+	Object             string            `json:"object"`
+	CreatedAt          int64             `json:"created_at"`
+	Status             string            `json:"status"`
+	Model              string            `json:"model"`
+	Instructions       *string           `json:"instructions"`
+	PreviousResponseID *string           `json:"previous_response_id"`
+	Output             []ResponseItem    `json:"output"`
+	Error              *ResponseError    `json:"error"`
+	Usage              *ResponseUsage    `json:"usage"`
+	Temperature        *float32          `json:"temperature,omitempty"`
+	TopP               *float32          `json:"top_p,omitempty"`
+	MaxOutputTokens    *int32            `json:"max_output_tokens"`
+	Store              bool              `json:"store"`
+	Metadata           map[string]string `json:"metadata"`
+	Tools              []ResponseTool    `json:"tools"`
+	ToolChoice         any               `json:"tool_choice"`
+}
+
+type ResponseError struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+type ResponseUsage struct {
+	InputTokens  int `json:"input_tokens"`
+	OutputTokens int `json:"output_tokens"`
+	TotalTokens  int `json:"total_tokens"`
+}
+
+// ResponseStreamEvent is an event of a streamed response. Which fields are
+// set depends on Type.
+type ResponseStreamEvent struct {
+	Type           string           `json:"type"`
+	SequenceNumber int              `json:"sequence_number"`
+	Response       *Response        `json:"response,omitempty"`
+	OutputIndex    *int             `json:"output_index,omitempty"`
+	ContentIndex   *int             `json:"content_index,omitempty"`
+	ItemID         string           `json:"item_id,omitempty"`
+	Item           *ResponseItem    `json:"item,omitempty"`
+	Part           *ResponseContent `json:"part,omitempty"`
+	Delta          string           `json:"delta,omitempty"`
+	Text           *string          `json:"text,omitempty"`
+	Arguments      *string          `json:"arguments,omitempty"`
+}
+
+// ResponseDeleted is the body of DELETE /v1/responses/{id}.
+type ResponseDeleted struct {
+	ID      string `json:"id"`
+	Object  string `json:"object"`
+	Deleted bool   `json:"deleted"`
+}
@@ -1,6 +1,15 @@
 package models
 
-import "github.com/sokinpui/synapse.go/model"
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/sokinpui/synapse.go/model"
+)
 
 type GenerationTask struct {
 	TaskID    string        `json:"task_id"`
@@ -9,4 +18,120 @@ type GenerationTask struct {
 	Stream    bool          `json:"stream"`
 	Config    *model.Config `json:"config,omitempty"`
 	Images    [][]byte      `json:"images,omitempty"`
+
+	// Timeouts in seconds, overriding the server defaults.
+	Timeout           float64 `json:"timeout,omitempty"`
+	FirstTokenTimeout float64 `json:"first_token_timeout,omitempty"`
+	IdleTimeout       float64 `json:"idle_timeout,omitempty"`
+
+	// Hedge opts in to a second, racing upstream attempt when the first is
+	// slow to respond.
+	Hedge bool `json:"hedge,omitempty"`
+
+	// Tenant and RequestID identify who submitted the task and in which
+	// request, for logs. The server sets them from request headers.
+	Tenant    string `json:"tenant,omitempty"`
+	RequestID string `json:"request_id,omitempty"`
+
+	// CacheKey, if set, is the key under which the server caches the
+	// task's result.
+	CacheKey string `json:"cache_key,omitempty"`
+
+	// TraceContext carries the submitting request's trace across the broker.
+	TraceContext map[string]string `json:"trace_context,omitempty"`
+
+	// Background tasks, such as batch requests, wait in a queue of their own
+	// that workers take from only when no interactive task is waiting.
+	Background bool `json:"-"`
+
+	// EnqueuedAt is set by the broker when the task is queued.
+	EnqueuedAt time.Time `json:"-"`
+}
+
+// Fingerprint identifies what a task asks for and on whose behalf: it is a
+// digest of the tenant, model, normalized prompt, images and generation
+// config, and is equal for tasks that differ only in how they are delivered,
+// for example whether they stream.
+func (task *GenerationTask) Fingerprint() string {
+	h := sha256.New()
+	field := func(s string) {
+		binary.Write(h, binary.BigEndian, uint64(len(s)))
+		h.Write([]byte(s))
+	}
+
+	field(task.Tenant)
+	field(task.ModelCode)
+	field(normalize(task.Prompt))
+	for _, img := range task.Images {
+		sum := sha256.Sum256(img)
+		field(string(sum[:]))
+	}
+	if cfg := task.Config; cfg != nil {
+		field(fmt.Sprintf("t=%s p=%s k=%s n=%d", formatFloat(cfg.Temperature), formatFloat(cfg.TopP), formatFloat(cfg.TopK), cfg.OutputLength))
+		for _, stop := range cfg.StopSequences {
+			field(stop)
+		}
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// normalize makes prompts that differ only in line endings or trailing
+// whitespace equal.
+func normalize(prompt string) string {
+	prompt = strings.ReplaceAll(prompt, "\r\n", "\n")
+	lines := strings.Split(prompt, "\n")
+	for i, l := range lines {
+		lines[i] = strings.TrimRight(l, " \t")
+	}
+	return strings.TrimSpace(strings.Join(lines, "\n"))
+}
+
+func formatFloat(f *float32) string {
+	if f == nil {
+		return "-"
+	}
+	return fmt.Sprint(*f)
+}
+
+// TaskResult is a single message published on a task's result channel.
+// A task produces any number of Text chunks, at most one Err, and always
+// ends with a message whose Done is set.
+type TaskResult struct {
+	Text     string        `json:"text,omitempty"`
+	Err      *TaskError    `json:"error,omitempty"`
+	Metadata *TaskMetadata `json:"metadata,omitempty"`
+	Done     bool          `json:"done,omitempty"`
+}
+
+// Error codes reported in TaskError.
+const (
+	ErrCodeModelNotFound  = "model_not_found"
+	ErrCodeGeneration     = "generation_failed"
+	ErrCodeCircuitOpen    = "circuit_open"
+	ErrCodeTimeout        = "timeout"
+	ErrCodeShuttingDown   = "shutting_down"
+	ErrCodeBudgetExceeded = "budget_exceeded"
+	ErrCodeUnknownTenant  = "unknown_tenant"
+	ErrCodeInvalidRequest = "invalid_request"
+	ErrCodeSlowConsumer   = "slow_consumer"
+)
+
+type TaskError struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// TaskMetadata describes how a task was carried out.
+type TaskMetadata struct {
+	Attempts int    `json:"attempts"`
+	Usage    *Usage `json:"usage,omitempty"`
+	// Cached is set on results replayed from the response cache.
+	Cached bool `json:"cached,omitempty"`
+}
+
+// GenerateResponse is the body of a non-streaming /generate response.
+type GenerateResponse struct {
+	Text     string        `json:"text"`
+	Err      *TaskError    `json:"error,omitempty"`
+	Metadata *TaskMetadata `json:"metadata,omitempty"`
 }
@@ -0,0 +1,34 @@
+package models
+
+// WSMessage is a message a client sends on the /ws endpoint: either
+// "generate", to submit Request as a new task, or "cancel", to cancel a task
+// running on the same connection, named by TaskID or by the ID of the
+// generate message that started it.
+type WSMessage struct {
+	Type string `json:"type"`
+	// ID is the client's reference for a message. It is echoed on every
+	// frame of the task a generate message starts, so that the client can
+	// tell tasks apart before it learns their task IDs, and must be unique
+	// among the running tasks of the connection.
+	ID      string          `json:"id,omitempty"`
+	TaskID  string          `json:"task_id,omitempty"`
+	Request *GenerationTask `json:"request,omitempty"`
+}
+
+// WSFrame is a message the server sends on the /ws endpoint. A task's frames
+// are any number of "chunk" frames carrying Text, then a "usage" frame if
+// the provider reported usage, and finally either an "error" frame or a
+// "done" frame carrying Metadata. Frames of tasks on the same connection
+// interleave.
+//
+// An "error" frame without a TaskID answers a message the server could not
+// act on; it carries the ID of the message, if any.
+type WSFrame struct {
+	Type     string        `json:"type"`
+	ID       string        `json:"id,omitempty"`
+	TaskID   string        `json:"task_id,omitempty"`
+	Text     string        `json:"text,omitempty"`
+	Usage    *Usage        `json:"usage,omitempty"`
+	Error    *TaskError    `json:"error,omitempty"`
+	Metadata *TaskMetadata `json:"metadata,omitempty"`
+}
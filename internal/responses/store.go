@@ -0,0 +1,108 @@
+// Package responses stores the responses of the Responses API, so that a
+// request can continue the conversation of an earlier one with
+// previous_response_id.
+package responses
+
+import (
+	"container/list"
+	"sync"
+	"time"
+
+	"github.com/sokinpui/synapse.go/internal/config"
+	"github.com/sokinpui/synapse.go/internal/models"
+)
+
+// Entry is a stored response.
+type Entry struct {
+	Response models.Response
+	Tenant   string
+	// Transcript is the conversation up to and including the response,
+	// rendered as a prompt, without the instructions, which apply to one
+	// response only. Images are those sent in the conversation.
+	Transcript string
+	Images     [][]byte
+	Created    time.Time
+}
+
+// Store is an in-memory store of responses that evicts the least recently
+// used once full. A nil Store stores nothing.
+type Store struct {
+	maxStored int
+	ttl       time.Duration
+
+	mu      sync.Mutex
+	order   *list.List // front is most recently used
+	entries map[string]*list.Element
+}
+
+// New returns the store described by cfg.
+func New(cfg config.ResponsesConfig) *Store {
+	maxStored := cfg.MaxStored
+	if maxStored <= 0 {
+		maxStored = 10000
+	}
+	return &Store{maxStored: maxStored, ttl: cfg.TTL, order: list.New(), entries: make(map[string]*list.Element)}
+}
+
+// Get returns the live response id of tenant.
+func (s *Store) Get(tenant, id string) (Entry, bool) {
+	if s == nil {
+		return Entry{}, false
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	el, ok := s.entries[id]
+	if !ok {
+		return Entry{}, false
+	}
+	e := el.Value.(Entry)
+	if s.ttl > 0 && time.Since(e.Created) > s.ttl {
+		s.order.Remove(el)
+		delete(s.entries, id)
+		return Entry{}, false
+	}
+	if e.Tenant != tenant {
+		return Entry{}, false
+	}
+	s.order.MoveToFront(el)
+	return e, true
+}
+
+// Put stores e under the ID of its response.
+func (s *Store) Put(e Entry) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	id := e.Response.ID
+	if el, ok := s.entries[id]; ok {
+		el.Value = e
+		s.order.MoveToFront(el)
+		return
+	}
+	s.entries[id] = s.order.PushFront(e)
+	for s.order.Len() > s.maxStored {
+		oldest := s.order.Back()
+		s.order.Remove(oldest)
+		delete(s.entries, oldest.Value.(Entry).Response.ID)
+	}
+}
+
+// Delete removes the response id of tenant and reports whether it was
+// stored.
+func (s *Store) Delete(tenant, id string) bool {
+	if _, ok := s.Get(tenant, id); !ok {
+		return false
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if el, ok := s.entries[id]; ok {
+		s.order.Remove(el)
+		delete(s.entries, id)
+	}
+	return true
+}
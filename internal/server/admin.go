@@ -0,0 +1,221 @@
+package server
+
+import (
+	"crypto/subtle"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/sokinpui/synapse.go/internal/audit"
+	"github.com/sokinpui/synapse.go/internal/billing"
+)
+
+// SetAuditLog makes the audit log available to GET /admin/audit.
+func (s *HTTPServer) SetAuditLog(l *audit.Logger) {
+	s.auditLog = l
+}
+
+// SetLedger enforces the ledger's budgets on new tasks and makes it
+// available to GET /admin/usage.
+func (s *HTTPServer) SetLedger(l *billing.Ledger) {
+	s.ledger = l
+}
+
+// admin guards an /admin endpoint with the configured bearer token. Without
+// a token the admin endpoints are disabled.
+func (s *HTTPServer) admin(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		token := s.cfg.Server.AdminToken
+		if token == "" {
+			writeJSONError(w, http.StatusForbidden, "admin endpoints are disabled: no admin token is configured")
+			return
+		}
+		got, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(got), []byte(token)) != 1 {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			writeJSONError(w, http.StatusUnauthorized, "invalid admin token")
+			return
+		}
+		next(w, r)
+	}
+}
+
+func (s *HTTPServer) handleAudit(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	f := audit.Filter{
+		Tenant: q.Get("tenant"),
+		Model:  q.Get("model"),
+		TaskID: q.Get("task_id"),
+	}
+
+	var err error
+	if f.Since, err = parseTime(q.Get("since")); err != nil {
+		writeJSONError(w, http.StatusBadRequest, "invalid since: "+err.Error())
+		return
+	}
+	if f.Until, err = parseTime(q.Get("until")); err != nil {
+		writeJSONError(w, http.StatusBadRequest, "invalid until: "+err.Error())
+		return
+	}
+	if v := q.Get("limit"); v != "" {
+		if f.Limit, err = strconv.Atoi(v); err != nil || f.Limit < 0 {
+			writeJSONError(w, http.StatusBadRequest, "invalid limit")
+			return
+		}
+	}
+
+	recs, err := s.auditLog.Query(r.Context(), f)
+	if errors.Is(err, audit.ErrNotQueryable) {
+		writeJSONError(w, http.StatusNotImplemented, err.Error())
+		return
+	}
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if recs == nil {
+		recs = []audit.Record{}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{"records": recs})
+}
+
+// usageColumns are the dimension columns of /admin/usage, in order.
+var usageColumns = []string{billing.ByDay, billing.ByTenant, billing.ByProvider, billing.ByKey, billing.ByModel}
+
+// handleUsage reports spend from the billing ledger, as JSON or, with
+// format=csv, as CSV.
+func (s *HTTPServer) handleUsage(w http.ResponseWriter, r *http.Request) {
+	if s.ledger == nil {
+		writeJSONError(w, http.StatusNotImplemented, "cost accounting is not enabled")
+		return
+	}
+
+	q := r.URL.Query()
+	f := billing.Filter{
+		Since:  q.Get("since"),
+		Until:  q.Get("until"),
+		Tenant: q.Get("tenant"),
+		Model:  q.Get("model"),
+	}
+	for _, day := range []string{f.Since, f.Until} {
+		if _, err := time.Parse("2006-01-02", day); day != "" && err != nil {
+			writeJSONError(w, http.StatusBadRequest, "invalid day, want YYYY-MM-DD: "+day)
+			return
+		}
+	}
+	if v := q.Get("group_by"); v != "" {
+		f.GroupBy = strings.Split(v, ",")
+	}
+
+	rows, err := s.ledger.Query(f)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	columns := usageColumns
+	if len(f.GroupBy) > 0 {
+		columns = nil
+		for _, c := range usageColumns {
+			if slices.Contains(f.GroupBy, c) || (c == billing.ByProvider && slices.Contains(f.GroupBy, billing.ByKey)) {
+				columns = append(columns, c)
+			}
+		}
+	}
+
+	if q.Get("format") == "csv" || r.Header.Get("Accept") == "text/csv" {
+		writeUsageCSV(w, columns, rows)
+		return
+	}
+
+	data := make([]map[string]any, len(rows))
+	var total billing.Totals
+	for i, row := range rows {
+		data[i] = usageRecord(columns, row)
+		total.Requests += row.Requests
+		total.InputTokens += row.InputTokens
+		total.OutputTokens += row.OutputTokens
+		total.CachedTokens += row.CachedTokens
+		total.Images += row.Images
+		total.Cost += row.Cost
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{"rows": data, "total": total})
+}
+
+func usageDimension(row billing.Row, column string) any {
+	switch column {
+	case billing.ByDay:
+		return row.Day
+	case billing.ByTenant:
+		return row.Tenant
+	case billing.ByProvider:
+		return row.Provider
+	case billing.ByKey:
+		return row.APIKey
+	case billing.ByModel:
+		return row.Model
+	}
+	return nil
+}
+
+func usageRecord(columns []string, row billing.Row) map[string]any {
+	rec := map[string]any{
+		"requests":      row.Requests,
+		"input_tokens":  row.InputTokens,
+		"output_tokens": row.OutputTokens,
+		"cached_tokens": row.CachedTokens,
+		"images":        row.Images,
+		"cost":          row.Cost,
+	}
+	for _, c := range columns {
+		rec[c] = usageDimension(row, c)
+	}
+	return rec
+}
+
+func writeUsageCSV(w http.ResponseWriter, columns []string, rows []billing.Row) {
+	w.Header().Set("Content-Type", "text/csv")
+	w.Header().Set("Content-Disposition", `attachment; filename="usage.csv"`)
+
+	cw := csv.NewWriter(w)
+	cw.Write(append(slices.Clone(columns), "requests", "input_tokens", "output_tokens", "cached_tokens", "images", "cost"))
+	for _, row := range rows {
+		rec := make([]string, 0, len(columns)+6)
+		for _, c := range columns {
+			rec = append(rec, fmt.Sprint(usageDimension(row, c)))
+		}
+		rec = append(rec,
+			strconv.Itoa(row.Requests),
+			strconv.Itoa(row.InputTokens),
+			strconv.Itoa(row.OutputTokens),
+			strconv.Itoa(row.CachedTokens),
+			strconv.Itoa(row.Images),
+			strconv.FormatFloat(row.Cost, 'f', 6, 64))
+		cw.Write(rec)
+	}
+	cw.Flush()
+}
+
+// parseTime parses an RFC 3339 timestamp, or returns the zero time for an
+// empty string.
+func parseTime(s string) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+	return time.Parse(time.RFC3339, s)
+}
+
+func writeJSONError(w http.ResponseWriter, status int, msg string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]string{"error": msg})
+}
@@ -0,0 +1,243 @@
+package server
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/sokinpui/synapse.go/internal/logging"
+	"github.com/sokinpui/synapse.go/internal/models"
+	"github.com/sokinpui/synapse.go/model"
+)
+
+func (s *HTTPServer) handleAnthropicMessages(w http.ResponseWriter, r *http.Request) {
+	var req models.AnthropicMessagesRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeAnthropicError(w, http.StatusBadRequest, "invalid_request_error", "invalid request body")
+		return
+	}
+	if req.MaxTokens <= 0 {
+		writeAnthropicError(w, http.StatusBadRequest, "invalid_request_error", "max_tokens: must be positive")
+		return
+	}
+	if len(req.Messages) == 0 {
+		writeAnthropicError(w, http.StatusBadRequest, "invalid_request_error", "messages: at least one message is required")
+		return
+	}
+
+	prompt, images, err := parseAnthropicMessages(req)
+	if err != nil {
+		writeAnthropicError(w, http.StatusBadRequest, "invalid_request_error", err.Error())
+		return
+	}
+
+	s.observeModel(r, req.Model)
+
+	task := &models.GenerationTask{
+		TaskID:    uuid.New().String(),
+		Prompt:    prompt,
+		ModelCode: req.Model,
+		Stream:    req.Stream,
+		Config: &model.Config{
+			Temperature:   req.Temperature,
+			TopP:          req.TopP,
+			TopK:          req.TopK,
+			OutputLength:  req.MaxTokens,
+			StopSequences: req.StopSequences,
+		},
+		Images: images,
+	}
+	taskID := task.TaskID
+	ctx := s.taskContext(r, task)
+	slog.InfoContext(ctx, "Received request", "api", "anthropic", logging.Prompt(task.Prompt))
+
+	resCh, err := s.submit(ctx, task, cacheControlOf(r))
+	if err != nil {
+		taskErr := unavailable(err)
+		writeAnthropicError(w, statusForTaskError(taskErr), anthropicErrorType(taskErr), taskErr.Message)
+		return
+	}
+	defer s.broker.Unsubscribe(taskID)
+	w.Header().Set(taskIDHeader, taskID)
+
+	if task.Stream {
+		s.streamAnthropicResults(w, r, task, resCh)
+		return
+	}
+
+	result := collectResults(resCh)
+	if result.Err != nil {
+		writeAnthropicError(w, statusForTaskError(result.Err), anthropicErrorType(result.Err), result.Err.Message)
+		return
+	}
+	resp := anthropicMessage(task)
+	resp.Content = []models.AnthropicContentBlock{{Type: "text", Text: result.Text}}
+	endTurn := "end_turn"
+	resp.StopReason = &endTurn
+	resp.Usage = anthropicUsage(result.Metadata)
+	writeJSON(w, resp)
+}
+
+// parseAnthropicMessages renders the system prompt and messages of req as a
+// prompt in the form of parseOpenAIMessages, collecting their images.
+func parseAnthropicMessages(req models.AnthropicMessagesRequest) (string, [][]byte, error) {
+	var sb strings.Builder
+	var images [][]byte
+
+	if req.System != nil {
+		system, err := anthropicContent(req.System, &images)
+		if err != nil {
+			return "", nil, fmt.Errorf("system: %w", err)
+		}
+		if system != "" {
+			fmt.Fprintf(&sb, "system: %s\n", system)
+		}
+	}
+	for i, msg := range req.Messages {
+		if msg.Role != "user" && msg.Role != "assistant" {
+			return "", nil, fmt.Errorf("messages.%d.role: must be user or assistant", i)
+		}
+		text, err := anthropicContent(msg.Content, &images)
+		if err != nil {
+			return "", nil, fmt.Errorf("messages.%d.content: %w", i, err)
+		}
+		fmt.Fprintf(&sb, "%s: %s\n", msg.Role, text)
+	}
+	return sb.String(), images, nil
+}
+
+// anthropicContent returns the text of content, a string or a list of
+// content blocks, appending its images to images.
+func anthropicContent(content any, images *[][]byte) (string, error) {
+	if str, ok := content.(string); ok {
+		return str, nil
+	}
+
+	data, err := json.Marshal(content)
+	if err != nil {
+		return "", err
+	}
+	var blocks []models.AnthropicContentBlock
+	if err := json.Unmarshal(data, &blocks); err != nil {
+		return "", errors.New("must be a string or a list of content blocks")
+	}
+
+	var sb strings.Builder
+	for i, block := range blocks {
+		switch block.Type {
+		case "text":
+			sb.WriteString(block.Text)
+		case "image":
+			if block.Source == nil || block.Source.Type != "base64" {
+				return "", fmt.Errorf("%d: only base64 image sources are supported", i)
+			}
+			img, err := base64.StdEncoding.DecodeString(block.Source.Data)
+			if err != nil {
+				return "", fmt.Errorf("%d: invalid image data: %w", i, err)
+			}
+			*images = append(*images, img)
+		default:
+			return "", fmt.Errorf("%d: unsupported content block type %q", i, block.Type)
+		}
+	}
+	return sb.String(), nil
+}
+
+// anthropicMessage returns the message answering task, without content.
+func anthropicMessage(task *models.GenerationTask) models.AnthropicMessagesResponse {
+	return models.AnthropicMessagesResponse{
+		ID:      fmt.Sprintf("msg_%s", task.TaskID),
+		Type:    "message",
+		Role:    "assistant",
+		Model:   task.ModelCode,
+		Content: []models.AnthropicContentBlock{},
+	}
+}
+
+func anthropicUsage(meta *models.TaskMetadata) models.AnthropicUsage {
+	if meta == nil || meta.Usage == nil {
+		return models.AnthropicUsage{}
+	}
+	return models.AnthropicUsage{InputTokens: meta.Usage.PromptTokens, OutputTokens: meta.Usage.CompletionTokens}
+}
+
+func (s *HTTPServer) streamAnthropicResults(w http.ResponseWriter, r *http.Request, task *models.GenerationTask, ch <-chan models.TaskResult) {
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "Streaming not supported", http.StatusInternalServerError)
+		return
+	}
+
+	send := func(event models.AnthropicStreamEvent) {
+		jsonData, err := json.Marshal(event)
+		if err != nil {
+			slog.ErrorContext(r.Context(), "Error marshalling stream event", "error", err)
+			return
+		}
+		fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event.Type, jsonData)
+		flusher.Flush()
+	}
+
+	msg := anthropicMessage(task)
+	send(models.AnthropicStreamEvent{Type: "message_start", Message: &msg})
+	zero := 0
+	send(models.AnthropicStreamEvent{Type: "content_block_start", Index: &zero, ContentBlock: &models.AnthropicContentBlock{Type: "text"}})
+	send(models.AnthropicStreamEvent{Type: "ping"})
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case res, ok := <-ch:
+			if res.Err != nil {
+				send(models.AnthropicStreamEvent{Type: "error", Error: &models.AnthropicError{Type: anthropicErrorType(res.Err), Message: res.Err.Message}})
+				return
+			}
+			if res.Text != "" {
+				send(models.AnthropicStreamEvent{Type: "content_block_delta", Index: &zero, Delta: models.AnthropicTextDelta{Type: "text_delta", Text: res.Text}})
+			}
+			if !ok || res.Done {
+				send(models.AnthropicStreamEvent{Type: "content_block_stop", Index: &zero})
+				endTurn := "end_turn"
+				usage := anthropicUsage(res.Metadata)
+				send(models.AnthropicStreamEvent{Type: "message_delta", Delta: models.AnthropicMessageDelta{StopReason: &endTurn}, Usage: &usage})
+				send(models.AnthropicStreamEvent{Type: "message_stop"})
+				return
+			}
+		}
+	}
+}
+
+// anthropicErrorType maps a task error to the error type of the Anthropic
+// API.
+func anthropicErrorType(err *models.TaskError) string {
+	switch err.Code {
+	case models.ErrCodeModelNotFound:
+		return "not_found_error"
+	case models.ErrCodeBudgetExceeded:
+		return "rate_limit_error"
+	case models.ErrCodeUnknownTenant:
+		return "permission_error"
+	case models.ErrCodeCircuitOpen, models.ErrCodeShuttingDown:
+		return "overloaded_error"
+	}
+	return "api_error"
+}
+
+func writeAnthropicError(w http.ResponseWriter, status int, errType, msg string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(models.AnthropicErrorResponse{
+		Type:  "error",
+		Error: models.AnthropicError{Type: errType, Message: msg},
+	})
+}
@@ -0,0 +1,98 @@
+package server
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/sokinpui/synapse.go/internal/models"
+)
+
+func TestParseAnthropicMessages(t *testing.T) {
+	// "aW1n" is the base64 of "img".
+	body := `{"model": "m", "max_tokens": 16, "system": [{"type": "text", "text": "Be brief."}], "messages": [
+		{"role": "user", "content": [{"type": "text", "text": "What is this?"},
+			{"type": "image", "source": {"type": "base64", "media_type": "image/png", "data": "aW1n"}}]},
+		{"role": "assistant", "content": "A picture."}]}`
+	var req models.AnthropicMessagesRequest
+	if err := json.Unmarshal([]byte(body), &req); err != nil {
+		t.Fatal(err)
+	}
+
+	prompt, images, err := parseAnthropicMessages(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "system: Be brief.\nuser: What is this?\nassistant: A picture.\n"; prompt != want {
+		t.Errorf("prompt = %q, want %q", prompt, want)
+	}
+	if len(images) != 1 || string(images[0]) != "img" {
+		t.Errorf("images = %q, want the decoded image", images)
+	}
+}
+
+func TestParseAnthropicMessagesRejects(t *testing.T) {
+	tests := []struct {
+		name, messages, want string
+	}{
+		{"role", `[{"role": "system", "content": "hi"}]`, "messages.0.role"},
+		{"image url", `[{"role": "user", "content": [{"type": "image", "source": {"type": "url"}}]}]`, "only base64 image sources"},
+		{"image data", `[{"role": "user", "content": [{"type": "image", "source": {"type": "base64", "data": "!"}}]}]`, "invalid image data"},
+		{"block type", `[{"role": "user", "content": [{"type": "document"}]}]`, `unsupported content block type "document"`},
+		{"content", `[{"role": "user", "content": 4}]`, "must be a string or a list of content blocks"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var req models.AnthropicMessagesRequest
+			if err := json.Unmarshal([]byte(`{"messages": `+tt.messages+`}`), &req); err != nil {
+				t.Fatal(err)
+			}
+			if _, _, err := parseAnthropicMessages(req); err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("error = %v, want one mentioning %q", err, tt.want)
+			}
+		})
+	}
+}
+
+// sseEvents returns the event names of a server-sent event stream.
+func sseEvents(body string) []string {
+	var events []string
+	sc := bufio.NewScanner(strings.NewReader(body))
+	for sc.Scan() {
+		if name, ok := strings.CutPrefix(sc.Text(), "event: "); ok {
+			events = append(events, name)
+		}
+	}
+	return events
+}
+
+func TestAnthropicStreamEventOrder(t *testing.T) {
+	h, _ := responsesServer(t)
+
+	tests := []struct {
+		model string
+		want  []string
+	}{
+		{"scripted-test", []string{"message_start", "content_block_start", "ping", "content_block_delta",
+			"content_block_stop", "message_delta", "message_stop"}},
+		{"failing-test", []string{"message_start", "content_block_start", "ping", "error"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.model, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			body := `{"model": "` + tt.model + `", "max_tokens": 16, "stream": true, "messages": [{"role": "user", "content": "hi"}]}`
+			h.ServeHTTP(rec, httptest.NewRequest("POST", "/v1/messages", strings.NewReader(body)))
+
+			if rec.Code != http.StatusOK {
+				t.Fatalf("POST /v1/messages = %d %s", rec.Code, rec.Body)
+			}
+			if got := sseEvents(rec.Body.String()); !slices.Equal(got, tt.want) {
+				t.Errorf("events = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
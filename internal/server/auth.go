@@ -0,0 +1,66 @@
+package server
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"strings"
+)
+
+// publicPaths are served without a tenant API key: the probes and metrics
+// scraped by infrastructure, and the admin endpoints, which check a token of
+// their own.
+var publicPaths = []string{"/healthz", "/readyz", "/health", "/metrics", "/admin/"}
+
+// Authenticate identifies the tenant of every API request by its API key,
+// given as a bearer token, or in the x-api-key or x-goog-api-key header or
+// the key query parameter as Anthropic and Gemini clients send it. tenants
+// maps tenant names to their keys. The tenant found replaces the request's
+// X-Tenant-ID header, which is otherwise taken on trust; requests without a
+// known key are refused. Without tenants every request passes as is.
+func Authenticate(tenants map[string]string, next http.Handler) http.Handler {
+	if len(tenants) == 0 {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		for _, p := range publicPaths {
+			if r.URL.Path == p || (strings.HasSuffix(p, "/") && strings.HasPrefix(r.URL.Path, p)) {
+				next.ServeHTTP(w, r)
+				return
+			}
+		}
+
+		tenant, ok := tenantOf(tenants, apiKey(r))
+		if !ok {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			writeJSONError(w, http.StatusUnauthorized, "missing or invalid API key")
+			return
+		}
+		r.Header.Set(tenantHeader, tenant)
+		next.ServeHTTP(w, r)
+	})
+}
+
+// apiKey returns the API key r carries, if any.
+func apiKey(r *http.Request) string {
+	if key, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok {
+		return key
+	}
+	for _, h := range []string{"X-Api-Key", "X-Goog-Api-Key"} {
+		if key := r.Header.Get(h); key != "" {
+			return key
+		}
+	}
+	return r.URL.Query().Get("key")
+}
+
+// tenantOf returns the tenant whose API key is key, comparing every key in
+// constant time.
+func tenantOf(tenants map[string]string, key string) (string, bool) {
+	found, ok := "", false
+	for tenant, k := range tenants {
+		if k != "" && subtle.ConstantTimeCompare([]byte(k), []byte(key)) == 1 {
+			found, ok = tenant, true
+		}
+	}
+	return found, ok
+}
@@ -0,0 +1,56 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestAuthenticate(t *testing.T) {
+	var tenant string
+	h := Authenticate(map[string]string{"acme": "acme-key", "beta": "beta-key"},
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			tenant = r.Header.Get(tenantHeader)
+		}))
+
+	tests := []struct {
+		name   string
+		path   string
+		header http.Header
+		status int
+		tenant string
+	}{
+		{"bearer", "/generate", http.Header{"Authorization": {"Bearer acme-key"}}, 200, "acme"},
+		{"anthropic", "/v1/messages", http.Header{"X-Api-Key": {"beta-key"}}, 200, "beta"},
+		{"gemini header", "/v1beta/models/m:generateContent", http.Header{"X-Goog-Api-Key": {"acme-key"}}, 200, "acme"},
+		{"gemini query", "/v1beta/models/m:generateContent?key=beta-key", nil, 200, "beta"},
+		{"spoofed tenant", "/generate", http.Header{"Authorization": {"Bearer acme-key"}, "X-Tenant-Id": {"beta"}}, 200, "acme"},
+		{"unknown key", "/generate", http.Header{"Authorization": {"Bearer nope"}}, 401, ""},
+		{"tenant header only", "/generate", http.Header{"X-Tenant-Id": {"acme"}}, 401, ""},
+		{"probe", "/readyz", nil, 200, ""},
+		{"admin", "/admin/usage", http.Header{"Authorization": {"Bearer admin-token"}}, 200, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tenant = ""
+			r := httptest.NewRequest("GET", tt.path, nil)
+			for k, v := range tt.header {
+				r.Header[k] = v
+			}
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, r)
+			if w.Code != tt.status || tenant != tt.tenant {
+				t.Errorf("got %d as %q, want %d as %q", w.Code, tenant, tt.status, tt.tenant)
+			}
+		})
+	}
+}
+
+func TestAuthenticateWithoutTenants(t *testing.T) {
+	called := false
+	h := Authenticate(nil, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { called = true }))
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/generate", nil))
+	if !called {
+		t.Error("request without tenants configured was refused")
+	}
+}
@@ -0,0 +1,204 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/sokinpui/synapse.go/internal/batch"
+	"github.com/sokinpui/synapse.go/internal/broker"
+	"github.com/sokinpui/synapse.go/internal/logging"
+	"github.com/sokinpui/synapse.go/internal/models"
+)
+
+// SetBatches enables the Batch API, served by m.
+func (s *HTTPServer) SetBatches(m *batch.Manager) {
+	s.batches = m
+}
+
+// ExecuteBatchRequest runs one request of a batch, a chat completion body,
+// as a background task. It is the batch.Executor of the server.
+func (s *HTTPServer) ExecuteBatchRequest(ctx context.Context, tenant string, body json.RawMessage) (batch.Response, error) {
+	var req models.OpenAIChatRequest
+	if err := json.Unmarshal(body, &req); err != nil {
+		return batch.Response{
+			StatusCode: http.StatusBadRequest,
+			Body:       models.OpenAIErrorResponse{Error: models.OpenAIError{Message: "invalid request body: " + err.Error(), Type: "invalid_request_error"}},
+		}, nil
+	}
+
+	task := s.chatTask(req)
+	task.Stream = false
+	task.Tenant = tenant
+	task.Background = true
+	ctx = logging.With(ctx, slog.String("task_id", task.TaskID), slog.String("model", task.ModelCode))
+
+	resCh, err := s.submit(ctx, task, cacheControl{})
+	if errors.Is(err, broker.ErrClosed) {
+		return batch.Response{}, err
+	}
+	if err != nil {
+		taskErr := unavailable(err)
+		return batch.Response{StatusCode: statusForTaskError(taskErr), RequestID: task.TaskID, Body: openAIError(taskErr)}, nil
+	}
+	defer s.broker.Unsubscribe(task.TaskID)
+
+	done := make(chan models.GenerateResponse, 1)
+	go func() { done <- collectResults(resCh) }()
+
+	var result models.GenerateResponse
+	select {
+	case result = <-done:
+	case <-ctx.Done():
+		return batch.Response{}, context.Cause(ctx)
+	}
+
+	if result.Err != nil {
+		if result.Err.Code == models.ErrCodeShuttingDown {
+			return batch.Response{}, errors.New(result.Err.Message)
+		}
+		return batch.Response{StatusCode: statusForTaskError(result.Err), RequestID: task.TaskID, Body: openAIError(result.Err)}, nil
+	}
+	return batch.Response{StatusCode: http.StatusOK, RequestID: task.TaskID, Body: chatCompletion(task, result)}, nil
+}
+
+// batchAPI guards a Batch API endpoint, which is disabled without a batch
+// store.
+func (s *HTTPServer) batchAPI(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if s.batches == nil {
+			writeOpenAIError(w, http.StatusNotFound, models.OpenAIErrorResponse{
+				Error: models.OpenAIError{Message: "the batch API is disabled: no batch.dir is configured", Type: "invalid_request_error"},
+			})
+			return
+		}
+		next(w, r)
+	}
+}
+
+func writeBatchError(w http.ResponseWriter, err error) {
+	status, errType := http.StatusInternalServerError, "server_error"
+	switch {
+	case errors.Is(err, batch.ErrNotFound):
+		status, errType = http.StatusNotFound, "invalid_request_error"
+	case errors.Is(err, batch.ErrInvalid):
+		status, errType = http.StatusBadRequest, "invalid_request_error"
+	}
+	writeOpenAIError(w, status, models.OpenAIErrorResponse{Error: models.OpenAIError{Message: err.Error(), Type: errType}})
+}
+
+func writeJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
+
+func (s *HTTPServer) handleUploadFile(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, batch.MaxFileBytes+1<<20)
+	if err := r.ParseMultipartForm(32 << 20); err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			writeOpenAIError(w, http.StatusRequestEntityTooLarge, models.OpenAIErrorResponse{
+				Error: models.OpenAIError{Message: "file exceeds the maximum size of 200 MB", Type: "invalid_request_error"},
+			})
+			return
+		}
+		writeBatchError(w, fmt.Errorf("%w: %v", batch.ErrInvalid, err))
+		return
+	}
+	defer r.MultipartForm.RemoveAll()
+
+	content, header, err := r.FormFile("file")
+	if err != nil {
+		writeBatchError(w, fmt.Errorf("%w: a file is required", batch.ErrInvalid))
+		return
+	}
+	defer content.Close()
+
+	f, err := s.batches.CreateFile(r.Header.Get(tenantHeader), header.Filename, r.FormValue("purpose"), content)
+	if err != nil {
+		writeBatchError(w, err)
+		return
+	}
+	slog.InfoContext(r.Context(), "File uploaded", "file_id", f.ID, "bytes", f.Bytes)
+	writeJSON(w, f)
+}
+
+func (s *HTTPServer) handleGetFile(w http.ResponseWriter, r *http.Request) {
+	f, err := s.batches.File(r.Header.Get(tenantHeader), r.PathValue("id"))
+	if err != nil {
+		writeBatchError(w, err)
+		return
+	}
+	writeJSON(w, f)
+}
+
+func (s *HTTPServer) handleFileContent(w http.ResponseWriter, r *http.Request) {
+	content, f, err := s.batches.OpenFile(r.Header.Get(tenantHeader), r.PathValue("id"))
+	if err != nil {
+		writeBatchError(w, err)
+		return
+	}
+	defer content.Close()
+
+	w.Header().Set("Content-Type", "application/jsonl")
+	http.ServeContent(w, r, f.Filename, time.Unix(f.CreatedAt, 0), content)
+}
+
+func (s *HTTPServer) handleCreateBatch(w http.ResponseWriter, r *http.Request) {
+	var req batch.CreateRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeBatchError(w, fmt.Errorf("%w: invalid request body", batch.ErrInvalid))
+		return
+	}
+	b, err := s.batches.Create(r.Header.Get(tenantHeader), req)
+	if err != nil {
+		writeBatchError(w, err)
+		return
+	}
+	slog.InfoContext(r.Context(), "Batch created", "batch_id", b.ID, "input_file_id", b.InputFileID)
+	writeJSON(w, b)
+}
+
+func (s *HTTPServer) handleListBatches(w http.ResponseWriter, r *http.Request) {
+	limit := 20
+	if v := r.URL.Query().Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > 100 {
+			writeBatchError(w, fmt.Errorf("%w: limit must be between 1 and 100", batch.ErrInvalid))
+			return
+		}
+		limit = n
+	}
+
+	batches, more := s.batches.List(r.Header.Get(tenantHeader), r.URL.Query().Get("after"), limit)
+	resp := map[string]any{"object": "list", "data": batches, "has_more": more}
+	if len(batches) > 0 {
+		resp["first_id"] = batches[0].ID
+		resp["last_id"] = batches[len(batches)-1].ID
+	}
+	writeJSON(w, resp)
+}
+
+func (s *HTTPServer) handleGetBatch(w http.ResponseWriter, r *http.Request) {
+	b, err := s.batches.Get(r.Header.Get(tenantHeader), r.PathValue("id"))
+	if err != nil {
+		writeBatchError(w, err)
+		return
+	}
+	writeJSON(w, b)
+}
+
+func (s *HTTPServer) handleCancelBatch(w http.ResponseWriter, r *http.Request) {
+	b, err := s.batches.Cancel(r.Header.Get(tenantHeader), r.PathValue("id"))
+	if err != nil {
+		writeBatchError(w, err)
+		return
+	}
+	slog.InfoContext(r.Context(), "Batch cancelled", "batch_id", b.ID)
+	writeJSON(w, b)
+}
@@ -0,0 +1,102 @@
+package server
+
+import (
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/sokinpui/synapse.go/internal/cache"
+	"github.com/sokinpui/synapse.go/internal/metrics"
+	"github.com/sokinpui/synapse.go/internal/models"
+	"github.com/sokinpui/synapse.go/internal/worker"
+)
+
+// SetCache answers repeated requests from c.
+func (s *HTTPServer) SetCache(c *cache.Cache) {
+	s.cache = c
+}
+
+// cacheControl is what a request's Cache-Control header asks of the
+// response cache: no-cache skips the lookup, and no-store keeps the result
+// out of the cache.
+type cacheControl struct {
+	noCache bool
+	noStore bool
+}
+
+func cacheControlOf(r *http.Request) cacheControl {
+	var cc cacheControl
+	for _, directive := range strings.Split(r.Header.Get("Cache-Control"), ",") {
+		switch strings.ToLower(strings.TrimSpace(directive)) {
+		case "no-cache":
+			cc.noCache = true
+		case "no-store":
+			cc.noStore = true
+		}
+	}
+	return cc
+}
+
+// lookup returns the cached result of task, if any, and sets the key its
+// result should be cached under.
+func (s *HTTPServer) lookup(task *models.GenerationTask, cc cacheControl) (cache.Entry, bool) {
+	task.CacheKey = ""
+	if s.cache == nil {
+		return cache.Entry{}, false
+	}
+
+	key := task.Fingerprint()
+	if !cc.noStore {
+		task.CacheKey = key
+	}
+	if cc.noCache {
+		metrics.CacheLookups.WithLabelValues("bypass").Inc()
+		return cache.Entry{}, false
+	}
+
+	e, ok := s.cache.Get(key)
+	if !ok {
+		metrics.CacheLookups.WithLabelValues("miss").Inc()
+		return cache.Entry{}, false
+	}
+	metrics.CacheLookups.WithLabelValues("hit").Inc()
+	return e, true
+}
+
+// replay returns a result channel serving a cached result, split into
+// chunks like a live stream if the task streams.
+func replay(task *models.GenerationTask, e cache.Entry) <-chan models.TaskResult {
+	chunks := []string{e.Text}
+	if task.Stream {
+		chunks = cache.Chunks(e.Text)
+	}
+
+	meta := models.TaskMetadata{}
+	if e.Metadata != nil {
+		meta = *e.Metadata
+	}
+	meta.Cached = true
+
+	ch := make(chan models.TaskResult, len(chunks)+1)
+	for _, c := range chunks {
+		ch <- models.TaskResult{Text: c}
+	}
+	ch <- models.TaskResult{Done: true, Metadata: &meta}
+	close(ch)
+	return ch
+}
+
+// auditCached records a task answered from the cache in the audit log, as
+// the worker does for the tasks it runs. No upstream call was made, so the
+// record carries no usage.
+func (s *HTTPServer) auditCached(task *models.GenerationTask, e cache.Entry) {
+	now := time.Now()
+	s.auditLog.Observe(worker.Completion{
+		Task:     task,
+		Text:     e.Text,
+		Metadata: &models.TaskMetadata{Cached: true},
+		Outcome:  "cached",
+		Started:  now,
+		Finished: now,
+	})
+}
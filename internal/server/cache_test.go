@@ -0,0 +1,100 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/sokinpui/synapse.go/internal/audit"
+	"github.com/sokinpui/synapse.go/internal/billing"
+	"github.com/sokinpui/synapse.go/internal/broker"
+	"github.com/sokinpui/synapse.go/internal/cache"
+	"github.com/sokinpui/synapse.go/internal/config"
+	"github.com/sokinpui/synapse.go/internal/models"
+	"github.com/sokinpui/synapse.go/internal/worker"
+	"github.com/sokinpui/synapse.go/model"
+)
+
+// cachingServer returns a server with a memory cache that already holds the
+// answer to prompt for tenant acme.
+func cachingServer(t *testing.T, billingCfg config.BillingConfig) (*HTTPServer, *cache.Cache) {
+	t.Helper()
+	c, err := cache.New(config.CacheConfig{Backend: "memory"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ledger, err := billing.New(billingCfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := NewHTTPServer(broker.NewMemoryBroker(10), nil, &config.Config{})
+	s.SetCache(c)
+	s.SetLedger(ledger)
+
+	cached := cacheableTask("acme")
+	s.lookup(cached, cacheControl{})
+	c.Observe(worker.Completion{Task: cached, Text: "cached answer", Outcome: "ok", Finished: time.Now()})
+	return s, c
+}
+
+func cacheableTask(tenant string) *models.GenerationTask {
+	zero := float32(0)
+	return &models.GenerationTask{
+		TaskID:    tenant + "-task",
+		Tenant:    tenant,
+		ModelCode: "m",
+		Prompt:    "What is 2+2?",
+		Config:    &model.Config{Temperature: &zero},
+	}
+}
+
+func TestCacheIsPerTenant(t *testing.T) {
+	s, _ := cachingServer(t, config.BillingConfig{})
+
+	if _, ok := s.lookup(cacheableTask("acme"), cacheControl{}); !ok {
+		t.Error("acme missed its own cached answer")
+	}
+	if _, ok := s.lookup(cacheableTask("other"), cacheControl{}); ok {
+		t.Error("another tenant was served acme's cached answer")
+	}
+}
+
+func TestCacheHitIsAdmittedByBudget(t *testing.T) {
+	s, _ := cachingServer(t, config.BillingConfig{Budgets: map[string]config.Budget{"other": {Daily: 1}}})
+
+	_, err := s.submit(context.Background(), cacheableTask("acme"), cacheControl{})
+	if !errors.Is(err, billing.ErrUnknownTenant) {
+		t.Errorf("submit = %v, want the tenant refused before the cache is consulted", err)
+	}
+}
+
+func TestCacheHitIsAudited(t *testing.T) {
+	s, _ := cachingServer(t, config.BillingConfig{})
+	path := filepath.Join(t.TempDir(), "audit.jsonl")
+	auditLog, err := audit.New(config.AuditConfig{JSONL: &config.JSONLSinkConfig{Path: path}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.SetAuditLog(auditLog)
+
+	ch, err := s.submit(context.Background(), cacheableTask("acme"), cacheControl{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp := collectResults(ch); resp.Text != "cached answer" || !resp.Metadata.Cached {
+		t.Fatalf("got %+v, want the cached answer", resp)
+	}
+	if err := auditLog.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	recs, err := auditLog.Query(context.Background(), audit.Filter{Tenant: "acme"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(recs) != 1 || recs[0].Outcome != "cached" || recs[0].TaskID != "acme-task" {
+		t.Errorf("audit records = %+v, want one cached record for acme-task", recs)
+	}
+}
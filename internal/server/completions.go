@@ -0,0 +1,297 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/sokinpui/synapse.go/internal/logging"
+	"github.com/sokinpui/synapse.go/internal/models"
+	"github.com/sokinpui/synapse.go/model"
+)
+
+// maxCompletionChoices bounds the choices of one completion request, that
+// is the number of prompts times n.
+const maxCompletionChoices = 128
+
+// completionTask is one choice of a completion request: the task generating
+// it and its index among the choices.
+type completionTask struct {
+	index  int
+	prompt string
+	task   *models.GenerationTask
+	ch     <-chan models.TaskResult
+}
+
+func (s *HTTPServer) handleOpenAICompletions(w http.ResponseWriter, r *http.Request) {
+	var req models.OpenAICompletionRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeInvalidRequest(w, fmt.Sprintf("invalid request body: %v", err))
+		return
+	}
+
+	prompts, err := stringOrList(req.Prompt)
+	if err == nil && len(prompts) == 0 {
+		err = errors.New("prompt is required")
+	}
+	if err != nil {
+		writeInvalidRequest(w, fmt.Sprintf("prompt: %v", err))
+		return
+	}
+	stop, err := stringOrList(req.Stop)
+	if err != nil {
+		writeInvalidRequest(w, fmt.Sprintf("stop: %v", err))
+		return
+	}
+	n := req.N
+	if n < 1 {
+		n = 1
+	}
+	if len(prompts)*n > maxCompletionChoices {
+		writeInvalidRequest(w, fmt.Sprintf("at most %d choices may be requested", maxCompletionChoices))
+		return
+	}
+
+	s.observeModel(r, req.Model)
+
+	cc := cacheControlOf(r)
+	choices := make([]*completionTask, 0, len(prompts)*n)
+	for i, prompt := range prompts {
+		for j := 0; j < n; j++ {
+			task := &models.GenerationTask{
+				TaskID:    uuid.New().String(),
+				Prompt:    prompt,
+				ModelCode: req.Model,
+				Stream:    req.Stream,
+				Config: &model.Config{
+					Temperature:   req.Temperature,
+					TopP:          req.TopP,
+					OutputLength:  req.MaxTokens,
+					StopSequences: stop,
+				},
+			}
+			ctx := s.taskContext(r, task)
+			slog.InfoContext(ctx, "Received request", "api", "openai", logging.Prompt(task.Prompt))
+
+			// Further choices for the same prompt must not be answered with
+			// the cached result of the first.
+			taskCC := cc
+			if j > 0 {
+				taskCC.noCache = true
+			}
+			resCh, err := s.submit(ctx, task, taskCC)
+			if err != nil {
+				for _, c := range choices {
+					s.broker.Unsubscribe(c.task.TaskID)
+				}
+				taskErr := unavailable(err)
+				writeOpenAIError(w, statusForTaskError(taskErr), openAIError(taskErr))
+				return
+			}
+			w.Header().Add(taskIDHeader, task.TaskID)
+			choices = append(choices, &completionTask{index: i*n + j, prompt: prompt, task: task, ch: resCh})
+		}
+	}
+	defer func() {
+		for _, c := range choices {
+			s.broker.Unsubscribe(c.task.TaskID)
+		}
+	}()
+
+	id := fmt.Sprintf("cmpl-%s", choices[0].task.TaskID)
+	if req.Stream {
+		s.streamCompletionResults(w, r, id, req, choices)
+		return
+	}
+	s.aggregateCompletionResults(w, id, req, choices)
+}
+
+// stringOrList decodes a JSON value that is a string or an array of strings.
+func stringOrList(v any) ([]string, error) {
+	switch v := v.(type) {
+	case nil:
+		return nil, nil
+	case string:
+		return []string{v}, nil
+	case []any:
+		list := make([]string, len(v))
+		for i, item := range v {
+			s, ok := item.(string)
+			if !ok {
+				return nil, errors.New("must be a string or an array of strings")
+			}
+			list[i] = s
+		}
+		return list, nil
+	}
+	return nil, errors.New("must be a string or an array of strings")
+}
+
+func writeInvalidRequest(w http.ResponseWriter, msg string) {
+	writeOpenAIError(w, http.StatusBadRequest, models.OpenAIErrorResponse{
+		Error: models.OpenAIError{Message: msg, Type: "invalid_request_error"},
+	})
+}
+
+func (s *HTTPServer) aggregateCompletionResults(w http.ResponseWriter, id string, req models.OpenAICompletionRequest, choices []*completionTask) {
+	// Read every task's results at once, so that none waits on another.
+	results := make([]models.GenerateResponse, len(choices))
+	var wg sync.WaitGroup
+	for i, c := range choices {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			results[i] = collectResults(c.ch)
+		}()
+	}
+	wg.Wait()
+
+	resp := models.OpenAICompletionResponse{
+		ID:      id,
+		Object:  "text_completion",
+		Created: time.Now().Unix(),
+		Model:   req.Model,
+		Choices: make([]models.CompletionChoice, len(choices)),
+		Usage:   &models.Usage{},
+	}
+	for i, result := range results {
+		if result.Err != nil {
+			writeOpenAIError(w, statusForTaskError(result.Err), openAIError(result.Err))
+			return
+		}
+		text := result.Text
+		if req.Echo {
+			text = choices[i].prompt + text
+		}
+		stop := "stop"
+		resp.Choices[i] = models.CompletionChoice{Text: text, Index: choices[i].index, FinishReason: &stop}
+		addUsage(resp.Usage, result.Metadata)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// indexedResult is a result of the task generating the choice index.
+type indexedResult struct {
+	index int
+	models.TaskResult
+}
+
+func (s *HTTPServer) streamCompletionResults(w http.ResponseWriter, r *http.Request, id string, req models.OpenAICompletionRequest, choices []*completionTask) {
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "Streaming not supported", http.StatusInternalServerError)
+		return
+	}
+	// Send the headers, with the task IDs, while the tasks may still be queued.
+	flusher.Flush()
+
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
+	merged := mergeResults(ctx, choices)
+
+	now := time.Now().Unix()
+	send := func(choice models.CompletionChoice, usage *models.Usage) {
+		chunk := models.OpenAICompletionResponse{
+			ID:      id,
+			Object:  "text_completion",
+			Created: now,
+			Model:   req.Model,
+			Choices: []models.CompletionChoice{choice},
+			Usage:   usage,
+		}
+		if jsonData, err := json.Marshal(chunk); err == nil {
+			fmt.Fprintf(w, "data: %s\n\n", jsonData)
+			flusher.Flush()
+		}
+	}
+
+	if req.Echo {
+		for _, c := range choices {
+			send(models.CompletionChoice{Text: c.prompt, Index: c.index}, nil)
+		}
+	}
+
+	usage := &models.Usage{}
+	remaining := len(choices)
+	for remaining > 0 {
+		var res indexedResult
+		select {
+		case <-ctx.Done():
+			return
+		case res = <-merged:
+		}
+
+		if res.Err != nil {
+			if jsonData, err := json.Marshal(openAIError(res.Err)); err == nil {
+				fmt.Fprintf(w, "data: %s\n\n", jsonData)
+			}
+			io.WriteString(w, "data: [DONE]\n\n")
+			flusher.Flush()
+			return
+		}
+		if res.Text != "" {
+			send(models.CompletionChoice{Text: res.Text, Index: res.index}, nil)
+		}
+		if res.Done {
+			addUsage(usage, res.Metadata)
+			remaining--
+			stop := "stop"
+			var final *models.Usage
+			if remaining == 0 {
+				final = usage
+			}
+			send(models.CompletionChoice{Index: res.index, FinishReason: &stop}, final)
+		}
+	}
+
+	io.WriteString(w, "data: [DONE]\n\n")
+	flusher.Flush()
+}
+
+// mergeResults forwards the results of every choice's task to one channel
+// until ctx ends. A task whose channel closes early is reported done.
+func mergeResults(ctx context.Context, choices []*completionTask) <-chan indexedResult {
+	merged := make(chan indexedResult)
+	for _, c := range choices {
+		go func() {
+			for {
+				res, ok := <-c.ch
+				if !ok {
+					res.Done = true
+				}
+				select {
+				case merged <- indexedResult{index: c.index, TaskResult: res}:
+				case <-ctx.Done():
+					return
+				}
+				if res.Done {
+					return
+				}
+			}
+		}()
+	}
+	return merged
+}
+
+// addUsage adds the token usage reported in meta, if any, to total.
+func addUsage(total *models.Usage, meta *models.TaskMetadata) {
+	if meta == nil || meta.Usage == nil {
+		return
+	}
+	total.PromptTokens += meta.Usage.PromptTokens
+	total.CompletionTokens += meta.Usage.CompletionTokens
+	total.TotalTokens += meta.Usage.TotalTokens
+}
@@ -0,0 +1,29 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/sokinpui/synapse.go/internal/broker"
+	"github.com/sokinpui/synapse.go/internal/config"
+	"github.com/sokinpui/synapse.go/internal/models"
+)
+
+func TestCompletionsRejectsInvalidBodyAsOpenAIError(t *testing.T) {
+	mux := http.NewServeMux()
+	NewHTTPServer(broker.NewMemoryBroker(10), nil, &config.Config{}).RegisterRoutes(mux)
+
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest("POST", "/v1/completions", strings.NewReader(`{"prompt":`)))
+
+	var resp models.OpenAIErrorResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("body %q is not an OpenAI error: %v", rec.Body, err)
+	}
+	if rec.Code != http.StatusBadRequest || resp.Error.Type != "invalid_request_error" {
+		t.Errorf("got %d %+v, want 400 invalid_request_error", rec.Code, resp.Error)
+	}
+}
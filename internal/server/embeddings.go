@@ -0,0 +1,126 @@
+package server
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/sokinpui/synapse.go/internal/models"
+	"github.com/sokinpui/synapse.go/internal/worker"
+	"github.com/sokinpui/synapse.go/model"
+)
+
+// maxEmbeddingInputs bounds the inputs of one embeddings request.
+const maxEmbeddingInputs = 2048
+
+func (s *HTTPServer) handleOpenAIEmbeddings(w http.ResponseWriter, r *http.Request) {
+	var req models.OpenAIEmbeddingRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeInvalidRequest(w, fmt.Sprintf("invalid request body: %v", err))
+		return
+	}
+
+	inputs, err := stringOrList(req.Input)
+	if err == nil && len(inputs) == 0 {
+		err = errors.New("input is required")
+	}
+	if err == nil && len(inputs) > maxEmbeddingInputs {
+		err = fmt.Errorf("at most %d inputs may be sent at once", maxEmbeddingInputs)
+	}
+	if err != nil {
+		writeInvalidRequest(w, fmt.Sprintf("input: %v", err))
+		return
+	}
+	switch req.EncodingFormat {
+	case "", "float", "base64":
+	default:
+		writeInvalidRequest(w, fmt.Sprintf("encoding_format: unsupported format %q", req.EncodingFormat))
+		return
+	}
+	if req.Dimensions < 0 {
+		writeInvalidRequest(w, "dimensions: must be positive")
+		return
+	}
+
+	s.observeModel(r, req.Model)
+	ctx := r.Context()
+	slog.InfoContext(ctx, "Received embeddings request", "api", "openai", "model", req.Model, "inputs", len(inputs))
+
+	var embedder model.Embedder
+	err = fmt.Errorf("%w: %s", model.ErrModelNotFound, req.Model)
+	if s.llmRegistry != nil {
+		embedder, err = s.llmRegistry.GetEmbedder(req.Model)
+	}
+	if err != nil {
+		taskErr := &models.TaskError{Code: models.ErrCodeModelNotFound, Message: err.Error()}
+		writeOpenAIError(w, statusForTaskError(taskErr), openAIError(taskErr))
+		return
+	}
+	tenant := r.Header.Get(tenantHeader)
+	if err := s.ledger.Allow(tenant); err != nil {
+		taskErr := unavailable(err)
+		writeOpenAIError(w, statusForTaskError(taskErr), openAIError(taskErr))
+		return
+	}
+
+	started := time.Now()
+	ctx, report := model.WithReport(ctx)
+	embeddings, err := embedder.Embed(ctx, inputs, &model.EmbedOptions{Dimensions: req.Dimensions})
+	s.bill(tenant, req.Model, report.Charges(), started)
+	if err != nil {
+		slog.ErrorContext(ctx, "Embedding failed", "model", req.Model, "error", err)
+		taskErr := &models.TaskError{Code: models.ErrCodeGeneration, Message: err.Error()}
+		writeOpenAIError(w, statusForTaskError(taskErr), openAIError(taskErr))
+		return
+	}
+
+	resp := models.OpenAIEmbeddingResponse{
+		Object: "list",
+		Data:   make([]models.OpenAIEmbedding, len(embeddings.Vectors)),
+		Model:  req.Model,
+		Usage: models.EmbeddingUsage{
+			PromptTokens: embeddings.InputTokens,
+			TotalTokens:  embeddings.InputTokens,
+		},
+	}
+	for i, vec := range embeddings.Vectors {
+		var embedding any = vec
+		if req.EncodingFormat == "base64" {
+			embedding = encodeVector(vec)
+		}
+		resp.Data[i] = models.OpenAIEmbedding{Object: "embedding", Embedding: embedding, Index: i}
+	}
+	writeJSON(w, resp)
+}
+
+// bill charges tenant for the upstream calls of a request served outside the
+// worker.
+func (s *HTTPServer) bill(tenant, modelCode string, charges []model.Charge, started time.Time) {
+	if s.ledger == nil || len(charges) == 0 {
+		return
+	}
+	s.ledger.Observe(worker.Completion{
+		Task:     &models.GenerationTask{TaskID: uuid.New().String(), Tenant: tenant, ModelCode: modelCode},
+		Charges:  charges,
+		Outcome:  "ok",
+		Started:  started,
+		Finished: time.Now(),
+	})
+}
+
+// encodeVector encodes vec as base64 of its little-endian float32s, as the
+// OpenAI API does.
+func encodeVector(vec []float32) string {
+	buf := make([]byte, 4*len(vec))
+	for i, f := range vec {
+		binary.LittleEndian.PutUint32(buf[4*i:], math.Float32bits(f))
+	}
+	return base64.StdEncoding.EncodeToString(buf)
+}
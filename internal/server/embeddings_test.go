@@ -0,0 +1,77 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/sokinpui/synapse.go/internal/billing"
+	"github.com/sokinpui/synapse.go/internal/broker"
+	"github.com/sokinpui/synapse.go/internal/config"
+	"github.com/sokinpui/synapse.go/internal/models"
+	"github.com/sokinpui/synapse.go/model"
+)
+
+func embeddingsServer(t *testing.T) (http.Handler, *billing.Ledger) {
+	t.Helper()
+	fakeGemini(t)
+	cfg := &config.Config{}
+	cfg.Models.Gemini.EmbeddingCodes = []string{"embed-test"}
+	registry, err := model.New(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ledger, err := billing.New(config.BillingConfig{Prices: map[string]config.Price{"embed-test": {Input: 1e6}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := NewHTTPServer(broker.NewMemoryBroker(10), registry, cfg)
+	s.SetLedger(ledger)
+	mux := http.NewServeMux()
+	s.RegisterRoutes(mux)
+	return mux, ledger
+}
+
+func TestEmbeddingsAreBilled(t *testing.T) {
+	mux, ledger := embeddingsServer(t)
+
+	req := httptest.NewRequest("POST", "/v1/embeddings", strings.NewReader(`{"model":"embed-test","input":"four words of input"}`))
+	req.Header.Set(tenantHeader, "acme")
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("POST /v1/embeddings = %d %s", rec.Code, rec.Body)
+	}
+	var resp models.OpenAIEmbeddingResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+
+	rows, err := ledger.Query(billing.Filter{Tenant: "acme"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 1 || rows[0].Requests != 1 || rows[0].Model != "embed-test" || rows[0].Provider != "gemini" {
+		t.Fatalf("ledger rows = %+v, want one embedding request", rows)
+	}
+	if rows[0].InputTokens != resp.Usage.PromptTokens || rows[0].Cost != float64(resp.Usage.PromptTokens) {
+		t.Errorf("billed %d tokens for %v, want the %d tokens reported", rows[0].InputTokens, rows[0].Cost, resp.Usage.PromptTokens)
+	}
+}
+
+func TestEmbeddingsRejectsInvalidBodyAsOpenAIError(t *testing.T) {
+	mux, _ := embeddingsServer(t)
+
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest("POST", "/v1/embeddings", strings.NewReader(`[`)))
+
+	var resp models.OpenAIErrorResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("body %q is not an OpenAI error: %v", rec.Body, err)
+	}
+	if rec.Code != http.StatusBadRequest || resp.Error.Type != "invalid_request_error" {
+		t.Errorf("got %d %+v, want 400 invalid_request_error", rec.Code, resp.Error)
+	}
+}
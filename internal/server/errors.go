@@ -0,0 +1,96 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/sokinpui/synapse.go/internal/billing"
+	"github.com/sokinpui/synapse.go/internal/models"
+)
+
+// collectResults reads a task's results until it is done, concatenating the
+// text chunks.
+func collectResults(ch <-chan models.TaskResult) models.GenerateResponse {
+	var sb strings.Builder
+	var resp models.GenerateResponse
+	for res := range ch {
+		sb.WriteString(res.Text)
+		if res.Err != nil {
+			resp.Err = res.Err
+		}
+		if res.Metadata != nil {
+			resp.Metadata = res.Metadata
+		}
+		if res.Done {
+			break
+		}
+	}
+	resp.Text = sb.String()
+	return resp
+}
+
+// statusForTaskError maps a task error to the HTTP status reported to clients.
+func statusForTaskError(err *models.TaskError) int {
+	switch err.Code {
+	case models.ErrCodeModelNotFound:
+		return http.StatusNotFound
+	case models.ErrCodeGeneration:
+		return http.StatusBadGateway
+	case models.ErrCodeCircuitOpen, models.ErrCodeShuttingDown:
+		return http.StatusServiceUnavailable
+	case models.ErrCodeTimeout:
+		return http.StatusGatewayTimeout
+	case models.ErrCodeBudgetExceeded:
+		return http.StatusTooManyRequests
+	case models.ErrCodeUnknownTenant:
+		return http.StatusForbidden
+	}
+	return http.StatusInternalServerError
+}
+
+// unavailable reports a task the server could not accept.
+func unavailable(err error) *models.TaskError {
+	code := models.ErrCodeShuttingDown
+	switch {
+	case errors.Is(err, billing.ErrBudgetExceeded):
+		code = models.ErrCodeBudgetExceeded
+	case errors.Is(err, billing.ErrUnknownTenant):
+		code = models.ErrCodeUnknownTenant
+	}
+	return &models.TaskError{Code: code, Message: err.Error()}
+}
+
+func writeTaskError(w http.ResponseWriter, err *models.TaskError) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusForTaskError(err))
+	json.NewEncoder(w).Encode(models.GenerateResponse{Err: err})
+}
+
+func openAIError(err *models.TaskError) models.OpenAIErrorResponse {
+	errType := "server_error"
+	switch err.Code {
+	case models.ErrCodeModelNotFound:
+		errType = "invalid_request_error"
+	case models.ErrCodeTimeout:
+		errType = "timeout"
+	case models.ErrCodeBudgetExceeded:
+		errType = "insufficient_quota"
+	case models.ErrCodeUnknownTenant:
+		errType = "permission_error"
+	}
+	return models.OpenAIErrorResponse{
+		Error: models.OpenAIError{
+			Message: err.Message,
+			Type:    errType,
+			Code:    err.Code,
+		},
+	}
+}
+
+func writeOpenAIError(w http.ResponseWriter, status int, resp models.OpenAIErrorResponse) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(resp)
+}
@@ -0,0 +1,326 @@
+package server
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/sokinpui/synapse.go/internal/logging"
+	"github.com/sokinpui/synapse.go/internal/models"
+	"github.com/sokinpui/synapse.go/model"
+)
+
+// handleGeminiModels serves the methods of the Gemini API on a model, whose
+// path is the model code and the method joined by a colon. Model codes may
+// themselves contain colons and slashes, so the method follows the last
+// colon.
+func (s *HTTPServer) handleGeminiModels(w http.ResponseWriter, r *http.Request) {
+	path := r.PathValue("path")
+	i := strings.LastIndex(path, ":")
+	if i < 0 {
+		writeGeminiError(w, http.StatusNotFound, fmt.Sprintf("unknown method on %q", path))
+		return
+	}
+	modelCode, method := path[:i], path[i+1:]
+
+	var req models.GeminiGenerateRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeGeminiError(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+
+	switch method {
+	case "generateContent":
+		s.geminiGenerate(w, r, modelCode, req, false)
+	case "streamGenerateContent":
+		s.geminiGenerate(w, r, modelCode, req, true)
+	case "countTokens":
+		s.geminiCountTokens(w, r, modelCode, req)
+	default:
+		writeGeminiError(w, http.StatusNotFound, fmt.Sprintf("unknown method %q", method))
+	}
+}
+
+func (s *HTTPServer) geminiGenerate(w http.ResponseWriter, r *http.Request, modelCode string, req models.GeminiGenerateRequest, stream bool) {
+	prompt, images, err := parseGeminiContents(req)
+	if err != nil {
+		writeGeminiError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	cfg := &model.Config{}
+	if gc := first(req.GenerationConfig, req.GenerationConfigSnake); gc != nil {
+		if gc.CandidateCount > 1 {
+			writeGeminiError(w, http.StatusBadRequest, "generationConfig.candidateCount: only one candidate is supported")
+			return
+		}
+		cfg = &model.Config{
+			Temperature:   gc.Temperature,
+			TopP:          gc.TopP,
+			TopK:          gc.TopK,
+			OutputLength:  gc.MaxOutputTokens,
+			StopSequences: gc.StopSequences,
+		}
+	}
+
+	s.observeModel(r, modelCode)
+
+	task := &models.GenerationTask{
+		TaskID:    uuid.New().String(),
+		Prompt:    prompt,
+		ModelCode: modelCode,
+		Stream:    stream,
+		Config:    cfg,
+		Images:    images,
+	}
+	taskID := task.TaskID
+	ctx := s.taskContext(r, task)
+	slog.InfoContext(ctx, "Received request", "api", "gemini", logging.Prompt(task.Prompt))
+
+	resCh, err := s.submit(ctx, task, cacheControlOf(r))
+	if err != nil {
+		taskErr := unavailable(err)
+		writeGeminiError(w, statusForTaskError(taskErr), taskErr.Message)
+		return
+	}
+	defer s.broker.Unsubscribe(taskID)
+	w.Header().Set(taskIDHeader, taskID)
+
+	if stream {
+		s.streamGeminiResults(w, r, task, resCh, r.URL.Query().Get("alt") == "sse")
+		return
+	}
+
+	result := collectResults(resCh)
+	if result.Err != nil {
+		writeGeminiError(w, statusForTaskError(result.Err), result.Err.Message)
+		return
+	}
+	resp := geminiResponse(task, result.Text)
+	resp.Candidates[0].FinishReason = "STOP"
+	resp.UsageMetadata = geminiUsage(result.Metadata)
+	writeJSON(w, resp)
+}
+
+func (s *HTTPServer) geminiCountTokens(w http.ResponseWriter, r *http.Request, modelCode string, req models.GeminiGenerateRequest) {
+	if req.GenerateContentRequest != nil {
+		req = *req.GenerateContentRequest
+	}
+	prompt, _, err := parseGeminiContents(req)
+	if err != nil {
+		writeGeminiError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	s.observeModel(r, modelCode)
+
+	var llm model.LLM
+	err = fmt.Errorf("%w: %s", model.ErrModelNotFound, modelCode)
+	if s.llmRegistry != nil {
+		llm, err = s.llmRegistry.GetModel(modelCode)
+	}
+	if err != nil {
+		writeGeminiError(w, http.StatusNotFound, err.Error())
+		return
+	}
+	n, err := llm.CountTokens(prompt)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Token counting failed", "model", modelCode, "error", err)
+		writeGeminiError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	writeJSON(w, models.GeminiCountTokensResponse{TotalTokens: n})
+}
+
+// parseGeminiContents renders the system instruction and contents of req as
+// a prompt in the form of parseOpenAIMessages, collecting their images. The
+// "model" role is rendered as "assistant".
+func parseGeminiContents(req models.GeminiGenerateRequest) (string, [][]byte, error) {
+	if len(req.Contents) == 0 {
+		return "", nil, errors.New("contents: at least one content is required")
+	}
+
+	var sb strings.Builder
+	var images [][]byte
+	if system := first(req.SystemInstruction, req.SystemInstructionSnake); system != nil {
+		text, err := geminiParts(system.Parts, &images)
+		if err != nil {
+			return "", nil, fmt.Errorf("systemInstruction: %w", err)
+		}
+		fmt.Fprintf(&sb, "system: %s\n", text)
+	}
+	for i, content := range req.Contents {
+		role := content.Role
+		switch role {
+		case "", "user":
+			role = "user"
+		case "model":
+			role = "assistant"
+		default:
+			return "", nil, fmt.Errorf("contents[%d].role: must be user or model", i)
+		}
+		text, err := geminiParts(content.Parts, &images)
+		if err != nil {
+			return "", nil, fmt.Errorf("contents[%d]: %w", i, err)
+		}
+		fmt.Fprintf(&sb, "%s: %s\n", role, text)
+	}
+	return sb.String(), images, nil
+}
+
+// geminiParts returns the text of parts, appending their inline data to
+// images.
+func geminiParts(parts []models.GeminiPart, images *[][]byte) (string, error) {
+	var sb strings.Builder
+	for i, part := range parts {
+		sb.WriteString(part.Text)
+		blob := first(part.InlineData, part.InlineDataSnake)
+		if blob == nil {
+			continue
+		}
+		data, err := base64.StdEncoding.DecodeString(blob.Data)
+		if err != nil {
+			return "", fmt.Errorf("parts[%d].inlineData: %w", i, err)
+		}
+		*images = append(*images, data)
+	}
+	return sb.String(), nil
+}
+
+// first returns the first of its arguments that is not nil.
+func first[T any](vs ...*T) *T {
+	for _, v := range vs {
+		if v != nil {
+			return v
+		}
+	}
+	return nil
+}
+
+// geminiResponse returns a response to task with a candidate holding text.
+func geminiResponse(task *models.GenerationTask, text string) models.GeminiGenerateResponse {
+	return models.GeminiGenerateResponse{
+		Candidates: []models.GeminiCandidate{{
+			Content: models.GeminiContent{Role: "model", Parts: []models.GeminiPart{{Text: text}}},
+			Index:   0,
+		}},
+		ModelVersion: task.ModelCode,
+		ResponseID:   task.TaskID,
+	}
+}
+
+func geminiUsage(meta *models.TaskMetadata) *models.GeminiUsageMetadata {
+	if meta == nil || meta.Usage == nil {
+		return nil
+	}
+	return &models.GeminiUsageMetadata{
+		PromptTokenCount:     meta.Usage.PromptTokens,
+		CandidatesTokenCount: meta.Usage.CompletionTokens,
+		TotalTokenCount:      meta.Usage.TotalTokens,
+	}
+}
+
+// streamGeminiResults streams responses holding the text of each result as
+// it arrives: as server-sent events if sse is set, and otherwise as the
+// elements of a JSON array, like the Gemini API.
+func (s *HTTPServer) streamGeminiResults(w http.ResponseWriter, r *http.Request, task *models.GenerationTask, ch <-chan models.TaskResult, sse bool) {
+	if sse {
+		w.Header().Set("Content-Type", "text/event-stream")
+	} else {
+		w.Header().Set("Content-Type", "application/json")
+	}
+	w.Header().Set("Cache-Control", "no-cache")
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "Streaming not supported", http.StatusInternalServerError)
+		return
+	}
+	// Send the headers, with the task ID, while the task may still be queued.
+	flusher.Flush()
+
+	count := 0
+	send := func(v any) {
+		jsonData, err := json.Marshal(v)
+		if err != nil {
+			slog.ErrorContext(r.Context(), "Error marshalling stream response", "error", err)
+			return
+		}
+		switch {
+		case sse:
+			fmt.Fprintf(w, "data: %s\n\n", jsonData)
+		case count == 0:
+			fmt.Fprintf(w, "[%s", jsonData)
+		default:
+			fmt.Fprintf(w, "\n,%s", jsonData)
+		}
+		count++
+		flusher.Flush()
+	}
+	end := func() {
+		if sse {
+			return
+		}
+		if count == 0 {
+			io.WriteString(w, "[")
+		}
+		io.WriteString(w, "]")
+		flusher.Flush()
+	}
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case res, ok := <-ch:
+			if res.Err != nil {
+				send(geminiError(statusForTaskError(res.Err), res.Err.Message))
+				end()
+				return
+			}
+			if res.Text != "" {
+				send(geminiResponse(task, res.Text))
+			}
+			if !ok || res.Done {
+				final := geminiResponse(task, "")
+				final.Candidates[0].FinishReason = "STOP"
+				final.UsageMetadata = geminiUsage(res.Metadata)
+				send(final)
+				end()
+				return
+			}
+		}
+	}
+}
+
+func geminiError(status int, msg string) models.GeminiErrorResponse {
+	statusName := "INTERNAL"
+	switch status {
+	case http.StatusBadRequest:
+		statusName = "INVALID_ARGUMENT"
+	case http.StatusUnauthorized:
+		statusName = "UNAUTHENTICATED"
+	case http.StatusForbidden:
+		statusName = "PERMISSION_DENIED"
+	case http.StatusNotFound:
+		statusName = "NOT_FOUND"
+	case http.StatusTooManyRequests:
+		statusName = "RESOURCE_EXHAUSTED"
+	case http.StatusServiceUnavailable:
+		statusName = "UNAVAILABLE"
+	case http.StatusGatewayTimeout:
+		statusName = "DEADLINE_EXCEEDED"
+	}
+	return models.GeminiErrorResponse{Error: models.GeminiError{Code: status, Message: msg, Status: statusName}}
+}
+
+func writeGeminiError(w http.ResponseWriter, status int, msg string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(geminiError(status, msg))
+}
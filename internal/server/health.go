@@ -0,0 +1,105 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/sokinpui/synapse.go/model"
+)
+
+// handleLive reports that the process is up and serving HTTP.
+func (s *HTTPServer) handleLive(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"status": "ok"})
+}
+
+// handleReady reports whether the server should receive traffic. It fails
+// while draining for shutdown, without a model registry, when no provider
+// has a usable API key, or when the queue is backed up.
+func (s *HTTPServer) handleReady(w http.ResponseWriter, r *http.Request) {
+	status, reasons := "ready", s.notReadyReasons()
+	code := http.StatusOK
+	if s.broker.Closed() {
+		status, code = "draining", http.StatusServiceUnavailable
+	} else if len(reasons) > 0 {
+		status, code = "not_ready", http.StatusServiceUnavailable
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(map[string]any{
+		"status":  status,
+		"reasons": reasons,
+		"queued":  s.broker.QueueDepth(),
+		"pending": s.broker.Pending(),
+	})
+}
+
+func (s *HTTPServer) notReadyReasons() []string {
+	reasons := []string{}
+	if s.llmRegistry == nil || len(s.llmRegistry.ListModels()) == 0 {
+		reasons = append(reasons, "no models registered")
+	} else {
+		usable := false
+		for _, p := range s.llmRegistry.Providers() {
+			if p.UsableKeys > 0 {
+				usable = true
+				break
+			}
+		}
+		if !usable {
+			reasons = append(reasons, "no provider has a usable API key")
+		}
+	}
+
+	if s.broker.QueueDepth() >= s.readyQueueDepth() {
+		reasons = append(reasons, "task queue is backed up")
+	}
+	return reasons
+}
+
+func (s *HTTPServer) readyQueueDepth() int {
+	if s.cfg.Server.ReadyQueueDepth > 0 {
+		return s.cfg.Server.ReadyQueueDepth
+	}
+	return s.broker.Capacity() * 9 / 10
+}
+
+// handleHealth reports the state of providers, circuit breakers and the
+// queue. The status is degraded while any circuit is not closed or any
+// provider has lost all of its keys.
+func (s *HTTPServer) handleHealth(w http.ResponseWriter, r *http.Request) {
+	status := "ok"
+	providers := map[string]model.ProviderStatus{}
+	circuits := map[string]model.CircuitStatus{}
+	if s.llmRegistry != nil {
+		providers = s.llmRegistry.Providers()
+		circuits = s.llmRegistry.Circuits()
+	}
+
+	for _, c := range circuits {
+		if c.State != model.CircuitClosed {
+			status = "degraded"
+		}
+	}
+	for _, p := range providers {
+		if p.UsableKeys == 0 {
+			status = "degraded"
+		}
+	}
+	if s.broker.Closed() {
+		status = "draining"
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{
+		"status":    status,
+		"providers": providers,
+		"circuits":  circuits,
+		"queue": map[string]int{
+			"depth":    s.broker.QueueDepth(),
+			"capacity": s.broker.Capacity(),
+			"pending":  s.broker.Pending(),
+		},
+	})
+}
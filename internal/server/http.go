@@ -1,53 +1,147 @@
 package server
 
 import (
+	"context"
 	"encoding/base64"
 	"encoding/json"
 	"fmt"
 	"io"
-	"log"
+	"log/slog"
 	"net/http"
 	"strings"
 	"time"
 
 	"github.com/google/uuid"
+	"github.com/sokinpui/synapse.go/internal/audit"
+	"github.com/sokinpui/synapse.go/internal/batch"
+	"github.com/sokinpui/synapse.go/internal/billing"
 	"github.com/sokinpui/synapse.go/internal/broker"
-	"github.com/sokinpui/synapse.go/internal/color"
+	"github.com/sokinpui/synapse.go/internal/cache"
+	"github.com/sokinpui/synapse.go/internal/config"
+	"github.com/sokinpui/synapse.go/internal/logging"
+	"github.com/sokinpui/synapse.go/internal/metrics"
 	"github.com/sokinpui/synapse.go/internal/models"
+	"github.com/sokinpui/synapse.go/internal/responses"
+	"github.com/sokinpui/synapse.go/internal/tracing"
 	"github.com/sokinpui/synapse.go/model"
 )
 
-const sentinel = "[DONE]"
-
 type HTTPServer struct {
 	broker      *broker.MemoryBroker
 	llmRegistry *model.Registry
+	cfg         *config.Config
+	auditLog    *audit.Logger
+	ledger      *billing.Ledger
+	cache       *cache.Cache
+	batches     *batch.Manager
+	responses   *responses.Store
 }
 
-func NewHTTPServer(b *broker.MemoryBroker, llmRegistry *model.Registry) *HTTPServer {
+func NewHTTPServer(b *broker.MemoryBroker, llmRegistry *model.Registry, cfg *config.Config) *HTTPServer {
 	return &HTTPServer{
 		broker:      b,
 		llmRegistry: llmRegistry,
+		cfg:         cfg,
 	}
 }
 
 func (s *HTTPServer) RegisterRoutes(mux *http.ServeMux) {
 	mux.HandleFunc("GET /models", s.handleListModels)
 	mux.HandleFunc("POST /generate", s.handleGenerate)
+	mux.HandleFunc("GET /stats", s.handleStats)
+	mux.HandleFunc("GET /health", s.handleHealth)
+	mux.HandleFunc("GET /healthz", s.handleLive)
+	mux.HandleFunc("GET /readyz", s.handleReady)
+	mux.HandleFunc("GET /tasks/{id}", s.handleTaskStatus)
+	mux.HandleFunc("POST /tasks/{id}/cancel", s.handleCancelTask)
+	mux.HandleFunc("GET /ws", s.handleWebSocket)
+	mux.Handle("GET /metrics", metrics.Handler())
 
 	// OpenAI Compatible API
 	mux.HandleFunc("GET /v1/models", s.handleOpenAIListModels)
 	mux.HandleFunc("POST /v1/chat/completions", s.handleOpenAIChatCompletions)
+	mux.HandleFunc("POST /v1/completions", s.handleOpenAICompletions)
+	mux.HandleFunc("POST /v1/embeddings", s.handleOpenAIEmbeddings)
+	mux.HandleFunc("POST /v1/responses", s.handleCreateResponse)
+	mux.HandleFunc("GET /v1/responses/{id}", s.handleGetResponse)
+	mux.HandleFunc("DELETE /v1/responses/{id}", s.handleDeleteResponse)
+
+	// Anthropic Compatible API
+	mux.HandleFunc("POST /v1/messages", s.handleAnthropicMessages)
+
+	// Gemini Compatible API
+	mux.HandleFunc("POST /v1beta/models/{path...}", s.handleGeminiModels)
+
+	// Ollama Compatible API
+	mux.HandleFunc("POST /api/generate", s.handleOllamaGenerate)
+	mux.HandleFunc("POST /api/chat", s.handleOllamaChat)
+	mux.HandleFunc("GET /api/tags", s.handleOllamaTags)
+	mux.HandleFunc("POST /api/show", s.handleOllamaShow)
+
+	// OpenAI Batch API
+	mux.HandleFunc("POST /v1/files", s.batchAPI(s.handleUploadFile))
+	mux.HandleFunc("GET /v1/files/{id}", s.batchAPI(s.handleGetFile))
+	mux.HandleFunc("GET /v1/files/{id}/content", s.batchAPI(s.handleFileContent))
+	mux.HandleFunc("POST /v1/batches", s.batchAPI(s.handleCreateBatch))
+	mux.HandleFunc("GET /v1/batches", s.batchAPI(s.handleListBatches))
+	mux.HandleFunc("GET /v1/batches/{id}", s.batchAPI(s.handleGetBatch))
+	mux.HandleFunc("POST /v1/batches/{id}/cancel", s.batchAPI(s.handleCancelBatch))
+
+	// Admin API
+	mux.HandleFunc("GET /admin/audit", s.admin(s.handleAudit))
+	mux.HandleFunc("GET /admin/usage", s.admin(s.handleUsage))
 }
 
 func (s *HTTPServer) handleListModels(w http.ResponseWriter, r *http.Request) {
 	modelCodes := s.llmRegistry.ListModels()
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string][]string{"models": modelCodes})
+	json.NewEncoder(w).Encode(map[string]any{
+		"models":   modelCodes,
+		"circuits": s.llmRegistry.Circuits(),
+	})
+}
+
+func (s *HTTPServer) handleStats(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{
+		"rate_limit_waits": model.RateLimitWaits(),
+	})
+}
+
+// taskContext stamps task with the request's ID and tenant and returns the
+// request's context with the task's attributes attached for logging.
+func (s *HTTPServer) taskContext(r *http.Request, task *models.GenerationTask) context.Context {
+	task.RequestID = requestID(r)
+	task.Tenant = r.Header.Get(tenantHeader)
+	return logging.With(r.Context(), slog.String("task_id", task.TaskID), slog.String("model", task.ModelCode))
+}
+
+// submit subscribes to the task's results and enqueues it, passing along
+// the trace of ctx, unless the response cache already has its result. Either
+// way the tenant must be within its budget. On success the caller must
+// unsubscribe once done with the results.
+func (s *HTTPServer) submit(ctx context.Context, task *models.GenerationTask, cc cacheControl) (<-chan models.TaskResult, error) {
+	if err := s.ledger.Allow(task.Tenant); err != nil {
+		return nil, err
+	}
+	if e, ok := s.lookup(task, cc); ok {
+		slog.InfoContext(ctx, "Served from cache")
+		s.broker.Served(task)
+		s.auditCached(task, e)
+		return replay(task, e), nil
+	}
+	task.TraceContext = tracing.Inject(tracing.TagTask(ctx, task.TaskID))
+
+	resCh := s.broker.Subscribe(task.TaskID)
+	if err := s.broker.Enqueue(task); err != nil {
+		s.broker.Unsubscribe(task.TaskID)
+		return nil, err
+	}
+	return resCh, nil
 }
 
 func (s *HTTPServer) handleOpenAIListModels(w http.ResponseWriter, r *http.Request) {
-	modelCodes := s.llmRegistry.ListModels()
+	modelCodes := append(s.llmRegistry.ListModels(), s.llmRegistry.ListEmbedders()...)
 	now := time.Now().Unix()
 	data := make([]models.OpenAIModel, len(modelCodes))
 	for i, m := range modelCodes {
@@ -71,12 +165,17 @@ func (s *HTTPServer) handleGenerate(w http.ResponseWriter, r *http.Request) {
 
 	taskID := uuid.New().String()
 	req.TaskID = taskID
-	log.Printf("-> %s (HTTP) [%s], assigned task_id: %s", color.BlueString("Received request"), req.ModelCode, taskID)
+	s.observeModel(r, req.ModelCode)
+	ctx := s.taskContext(r, &req)
+	slog.InfoContext(ctx, "Received request", "api", "http", logging.Prompt(req.Prompt))
 
-	resCh := s.broker.Subscribe(taskID)
+	resCh, err := s.submit(ctx, &req, cacheControlOf(r))
+	if err != nil {
+		writeTaskError(w, unavailable(err))
+		return
+	}
 	defer s.broker.Unsubscribe(taskID)
-
-	s.broker.Enqueue(&req)
+	w.Header().Set(taskIDHeader, taskID)
 
 	if req.Stream {
 		s.streamHTTPResults(w, r, resCh)
@@ -93,25 +192,21 @@ func (s *HTTPServer) handleOpenAIChatCompletions(w http.ResponseWriter, r *http.
 		return
 	}
 
-	taskID := uuid.New().String()
-	log.Printf("-> %s (OpenAI) [%s], assigned task_id: %s", color.BlueString("Received request"), oaiReq.Model, taskID)
+	s.observeModel(r, oaiReq.Model)
 
-	prompt, images := s.parseOpenAIMessages(oaiReq.Messages)
-	task := &models.GenerationTask{
-		TaskID:    taskID,
-		Prompt:    prompt,
-		ModelCode: oaiReq.Model,
-		Stream:    oaiReq.Stream,
-		Config: &model.Config{
-			Temperature:  oaiReq.Temperature,
-			OutputLength: oaiReq.MaxTokens,
-		},
-		Images: images,
-	}
+	task := s.chatTask(oaiReq)
+	taskID := task.TaskID
+	ctx := s.taskContext(r, task)
+	slog.InfoContext(ctx, "Received request", "api", "openai", logging.Prompt(task.Prompt))
 
-	resCh := s.broker.Subscribe(taskID)
+	resCh, err := s.submit(ctx, task, cacheControlOf(r))
+	if err != nil {
+		taskErr := unavailable(err)
+		writeOpenAIError(w, statusForTaskError(taskErr), openAIError(taskErr))
+		return
+	}
 	defer s.broker.Unsubscribe(taskID)
-	s.broker.Enqueue(task)
+	w.Header().Set(taskIDHeader, taskID)
 
 	if task.Stream {
 		s.streamOpenAIResults(w, r, task, resCh)
@@ -120,7 +215,23 @@ func (s *HTTPServer) handleOpenAIChatCompletions(w http.ResponseWriter, r *http.
 	s.aggregateOpenAIResults(w, task, resCh)
 }
 
-func (s *HTTPServer) streamHTTPResults(w http.ResponseWriter, r *http.Request, ch <-chan string) {
+// chatTask converts a chat completion request to a task.
+func (s *HTTPServer) chatTask(req models.OpenAIChatRequest) *models.GenerationTask {
+	prompt, images := s.parseOpenAIMessages(req.Messages)
+	return &models.GenerationTask{
+		TaskID:    uuid.New().String(),
+		Prompt:    prompt,
+		ModelCode: req.Model,
+		Stream:    req.Stream,
+		Config: &model.Config{
+			Temperature:  req.Temperature,
+			OutputLength: req.MaxTokens,
+		},
+		Images: images,
+	}
+}
+
+func (s *HTTPServer) streamHTTPResults(w http.ResponseWriter, r *http.Request, ch <-chan models.TaskResult) {
 	w.Header().Set("Content-Type", "text/event-stream")
 	w.Header().Set("Cache-Control", "no-cache")
 	w.Header().Set("Connection", "keep-alive")
@@ -130,42 +241,45 @@ func (s *HTTPServer) streamHTTPResults(w http.ResponseWriter, r *http.Request, c
 		http.Error(w, "Streaming not supported", http.StatusInternalServerError)
 		return
 	}
+	// Send the headers, with the task ID, while the task may still be queued.
+	flusher.Flush()
 
 	for {
 		select {
 		case <-r.Context().Done():
 			return
-		case data, ok := <-ch:
-			if !ok || data == sentinel {
+		case res, ok := <-ch:
+			if !ok {
 				return
 			}
-			jsonData, err := json.Marshal(map[string]string{"text": data})
+			jsonData, err := json.Marshal(res)
 			if err != nil {
-				log.Printf("Error marshalling stream response: %v", err)
+				slog.ErrorContext(r.Context(), "Error marshalling stream response", "error", err)
 				continue
 			}
 			fmt.Fprintf(w, "data: %s\n\n", jsonData)
 			flusher.Flush()
+			if res.Done {
+				return
+			}
 		}
 	}
 }
 
-func (s *HTTPServer) aggregateHTTPResults(w http.ResponseWriter, ch <-chan string) {
-	var sb strings.Builder
-	for data := range ch {
-		if data == sentinel {
-			break
-		}
-		sb.WriteString(data)
+func (s *HTTPServer) aggregateHTTPResults(w http.ResponseWriter, ch <-chan models.TaskResult) {
+	resp := collectResults(ch)
+
+	status := http.StatusOK
+	if resp.Err != nil {
+		status = statusForTaskError(resp.Err)
 	}
 
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string]string{
-		"text": sb.String(),
-	})
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(resp)
 }
 
-func (s *HTTPServer) streamOpenAIResults(w http.ResponseWriter, r *http.Request, task *models.GenerationTask, ch <-chan string) {
+func (s *HTTPServer) streamOpenAIResults(w http.ResponseWriter, r *http.Request, task *models.GenerationTask, ch <-chan models.TaskResult) {
 	w.Header().Set("Content-Type", "text/event-stream")
 	w.Header().Set("Cache-Control", "no-cache")
 	w.Header().Set("Connection", "keep-alive")
@@ -175,6 +289,8 @@ func (s *HTTPServer) streamOpenAIResults(w http.ResponseWriter, r *http.Request,
 		http.Error(w, "Streaming not supported", http.StatusInternalServerError)
 		return
 	}
+	// Send the headers, with the task ID, while the task may still be queued.
+	flusher.Flush()
 
 	now := time.Now().Unix()
 	first := true
@@ -183,8 +299,17 @@ func (s *HTTPServer) streamOpenAIResults(w http.ResponseWriter, r *http.Request,
 		select {
 		case <-r.Context().Done():
 			return
-		case data, ok := <-ch:
-			if !ok || data == sentinel {
+		case res, ok := <-ch:
+			if res.Err != nil {
+				if jsonData, err := json.Marshal(openAIError(res.Err)); err == nil {
+					fmt.Fprintf(w, "data: %s\n\n", jsonData)
+				}
+				io.WriteString(w, "data: [DONE]\n\n")
+				flusher.Flush()
+				return
+			}
+
+			if !ok || res.Done {
 				stop := "stop"
 				finalChunk := models.ChatCompletionChunk{
 					ID:      fmt.Sprintf("chatcmpl-%s", task.TaskID),
@@ -199,6 +324,9 @@ func (s *HTTPServer) streamOpenAIResults(w http.ResponseWriter, r *http.Request,
 						},
 					},
 				}
+				if res.Metadata != nil {
+					finalChunk.Usage = res.Metadata.Usage
+				}
 
 				if jsonData, err := json.Marshal(finalChunk); err == nil {
 					fmt.Fprintf(w, "data: %s\n\n", jsonData)
@@ -217,7 +345,7 @@ func (s *HTTPServer) streamOpenAIResults(w http.ResponseWriter, r *http.Request,
 				Model:   task.ModelCode,
 			}
 
-			delta := models.OpenAIChatMessage{Content: data}
+			delta := models.OpenAIChatMessage{Content: res.Text}
 			if first {
 				delta.Role = "assistant"
 				first = false
@@ -241,15 +369,20 @@ func (s *HTTPServer) streamOpenAIResults(w http.ResponseWriter, r *http.Request,
 	}
 }
 
-func (s *HTTPServer) aggregateOpenAIResults(w http.ResponseWriter, task *models.GenerationTask, ch <-chan string) {
-	var sb strings.Builder
-	for data := range ch {
-		if data == sentinel {
-			break
-		}
-		sb.WriteString(data)
+func (s *HTTPServer) aggregateOpenAIResults(w http.ResponseWriter, task *models.GenerationTask, ch <-chan models.TaskResult) {
+	result := collectResults(ch)
+	if result.Err != nil {
+		writeOpenAIError(w, statusForTaskError(result.Err), openAIError(result.Err))
+		return
 	}
 
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(chatCompletion(task, result))
+}
+
+// chatCompletion converts the successful result of a task to a chat
+// completion response.
+func chatCompletion(task *models.GenerationTask, result models.GenerateResponse) models.OpenAIChatResponse {
 	now := time.Now().Unix()
 
 	resp := models.OpenAIChatResponse{
@@ -262,20 +395,16 @@ func (s *HTTPServer) aggregateOpenAIResults(w http.ResponseWriter, task *models.
 				Index: 0,
 				Message: models.OpenAIChatMessage{
 					Role:    "assistant",
-					Content: sb.String(),
+					Content: result.Text,
 				},
 				FinishReason: "stop",
 			},
 		},
-		Usage: models.Usage{
-			PromptTokens:     0,
-			CompletionTokens: 0,
-			TotalTokens:      0,
-		},
 	}
-
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(resp)
+	if result.Metadata != nil && result.Metadata.Usage != nil {
+		resp.Usage = *result.Metadata.Usage
+	}
+	return resp
 }
 
 func (s *HTTPServer) parseOpenAIMessages(messages []models.OpenAIChatMessage) (string, [][]byte) {
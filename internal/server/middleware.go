@@ -0,0 +1,182 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"log/slog"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/sokinpui/synapse.go/internal/logging"
+	"github.com/sokinpui/synapse.go/internal/metrics"
+	"github.com/sokinpui/synapse.go/internal/tracing"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+)
+
+// requestInfo carries details a handler learns about a request, such as the
+// model it asked for, back to the middleware that records metrics.
+type requestInfo struct {
+	model string
+}
+
+type requestInfoKey struct{}
+
+// statusRecorder captures the status code written by a handler.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	if r.status == 0 {
+		r.status = status
+	}
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *statusRecorder) Write(b []byte) (int, error) {
+	if r.status == 0 {
+		r.status = http.StatusOK
+	}
+	return r.ResponseWriter.Write(b)
+}
+
+func (r *statusRecorder) Flush() {
+	if f, ok := r.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Hijack lets handlers take over the connection, as WebSocket upgrades do.
+func (r *statusRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	h, ok := r.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("hijacking not supported")
+	}
+	conn, rw, err := h.Hijack()
+	if err == nil && r.status == 0 {
+		r.status = http.StatusSwitchingProtocols
+	}
+	return conn, rw, err
+}
+
+func (r *statusRecorder) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
+
+const (
+	requestIDHeader = "X-Request-ID"
+	tenantHeader    = "X-Tenant-ID"
+	// taskIDHeader carries the ID of the task serving a generation
+	// request, for GET /tasks/{id} and cancellation.
+	taskIDHeader = "X-Task-ID"
+)
+
+// maxRequestIDLength bounds caller-supplied request IDs, which end up in
+// every log line of the request.
+const maxRequestIDLength = 128
+
+type requestIDKey struct{}
+
+// RequestID assigns every request an ID, taken from its X-Request-ID header
+// if it has one, echoes it in the response and attaches it, along with the
+// X-Tenant-ID header, to the request's log lines.
+func RequestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(requestIDHeader)
+		if id == "" || len(id) > maxRequestIDLength {
+			id = uuid.New().String()
+		}
+		w.Header().Set(requestIDHeader, id)
+
+		ctx := context.WithValue(r.Context(), requestIDKey{}, id)
+		ctx = logging.With(ctx, slog.String("request_id", id))
+		if tenant := r.Header.Get(tenantHeader); tenant != "" {
+			ctx = logging.With(ctx, slog.String("tenant", tenant))
+		}
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// requestID returns the ID RequestID assigned to r, if any.
+func requestID(r *http.Request) string {
+	id, _ := r.Context().Value(requestIDKey{}).(string)
+	return id
+}
+
+// Instrument records request counts and latencies for every request served
+// by next. Endpoints are labeled by their route pattern so that paths with
+// IDs in them do not each get their own series.
+func Instrument(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		info := &requestInfo{}
+		rec := &statusRecorder{ResponseWriter: w}
+		r = r.WithContext(context.WithValue(r.Context(), requestInfoKey{}, info))
+
+		next.ServeHTTP(rec, r)
+
+		endpoint := r.Pattern
+		if endpoint == "" {
+			endpoint = "unmatched"
+		}
+		status := rec.status
+		if status == 0 {
+			status = http.StatusOK
+		}
+		metrics.Requests.WithLabelValues(endpoint, info.model, strconv.Itoa(status)).Inc()
+		metrics.RequestDuration.WithLabelValues(endpoint).Observe(time.Since(start).Seconds())
+	})
+}
+
+// Trace starts a span for every request served by next, continuing the
+// caller's trace if the request carries one.
+func Trace(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := tracing.ExtractHTTP(r.Context(), r.Header)
+		ctx, span := tracing.Start(ctx, "http.request",
+			attribute.String("http.request.method", r.Method),
+			attribute.String("url.path", r.URL.Path))
+		defer span.End()
+
+		rec := &statusRecorder{ResponseWriter: w}
+		r = r.WithContext(ctx)
+		next.ServeHTTP(rec, r)
+
+		if r.Pattern != "" {
+			span.SetName(r.Pattern)
+		}
+		status := rec.status
+		if status == 0 {
+			status = http.StatusOK
+		}
+		span.SetAttributes(attribute.Int("http.response.status_code", status))
+		if status >= http.StatusInternalServerError {
+			span.SetStatus(codes.Error, http.StatusText(status))
+		}
+	})
+}
+
+// observeModel labels the request's metrics with the model it asked for.
+// Codes the registry does not know are labeled "unknown", so that clients
+// cannot create series at will.
+func (s *HTTPServer) observeModel(r *http.Request, modelCode string) {
+	info, ok := r.Context().Value(requestInfoKey{}).(*requestInfo)
+	if !ok {
+		return
+	}
+	info.model = "unknown"
+	if s.llmRegistry == nil {
+		return
+	}
+	if _, err := s.llmRegistry.GetModel(modelCode); err == nil {
+		info.model = modelCode
+	} else if _, err := s.llmRegistry.GetEmbedder(modelCode); err == nil {
+		info.model = modelCode
+	}
+}
@@ -0,0 +1,258 @@
+package server
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/sokinpui/synapse.go/internal/logging"
+	"github.com/sokinpui/synapse.go/internal/models"
+	"github.com/sokinpui/synapse.go/model"
+)
+
+// ollamaModel returns the model code that the Ollama model name refers to.
+// Ollama clients may add the default ":latest" tag to names without one.
+func (s *HTTPServer) ollamaModel(name string) (string, bool) {
+	if s.llmRegistry == nil {
+		return "", false
+	}
+	for _, code := range []string{name, strings.TrimSuffix(name, ":latest")} {
+		if _, err := s.llmRegistry.GetModel(code); err == nil {
+			return code, true
+		}
+	}
+	return "", false
+}
+
+// ollamaConfig converts the options of an Ollama request to a generation
+// config.
+func ollamaConfig(opts *models.OllamaOptions) *model.Config {
+	if opts == nil {
+		return &model.Config{}
+	}
+	return &model.Config{
+		Temperature:   opts.Temperature,
+		TopP:          opts.TopP,
+		TopK:          opts.TopK,
+		OutputLength:  opts.NumPredict,
+		StopSequences: opts.Stop,
+	}
+}
+
+func (s *HTTPServer) handleOllamaGenerate(w http.ResponseWriter, r *http.Request) {
+	var req models.OllamaGenerateRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeOllamaError(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+
+	prompt := req.Prompt
+	if req.System != "" {
+		prompt = fmt.Sprintf("system: %s\nuser: %s\n", req.System, req.Prompt)
+	}
+	task := &models.GenerationTask{
+		Prompt: prompt,
+		Config: ollamaConfig(req.Options),
+		Images: req.Images,
+	}
+	s.runOllamaTask(w, r, req.Model, req.Stream, task, false)
+}
+
+func (s *HTTPServer) handleOllamaChat(w http.ResponseWriter, r *http.Request) {
+	var req models.OllamaChatRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeOllamaError(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+	if len(req.Messages) == 0 {
+		writeOllamaError(w, http.StatusBadRequest, "messages are required")
+		return
+	}
+
+	var sb strings.Builder
+	var images [][]byte
+	for _, msg := range req.Messages {
+		fmt.Fprintf(&sb, "%s: %s\n", msg.Role, msg.Content)
+		images = append(images, msg.Images...)
+	}
+	task := &models.GenerationTask{
+		Prompt: sb.String(),
+		Config: ollamaConfig(req.Options),
+		Images: images,
+	}
+	s.runOllamaTask(w, r, req.Model, req.Stream, task, true)
+}
+
+// runOllamaTask runs task on the model named name and writes its result as
+// a chat response if chat is set, and a generate response otherwise. Ollama
+// streams unless told not to.
+func (s *HTTPServer) runOllamaTask(w http.ResponseWriter, r *http.Request, name string, stream *bool, task *models.GenerationTask, chat bool) {
+	start := time.Now()
+	s.observeModel(r, name)
+	code, ok := s.ollamaModel(name)
+	if !ok {
+		writeOllamaError(w, http.StatusNotFound, fmt.Sprintf("model '%s' not found", name))
+		return
+	}
+
+	task.TaskID = uuid.New().String()
+	task.ModelCode = code
+	task.Stream = stream == nil || *stream
+	taskID := task.TaskID
+	ctx := s.taskContext(r, task)
+	slog.InfoContext(ctx, "Received request", "api", "ollama", logging.Prompt(task.Prompt))
+
+	resCh, err := s.submit(ctx, task, cacheControlOf(r))
+	if err != nil {
+		taskErr := unavailable(err)
+		writeOllamaError(w, statusForTaskError(taskErr), taskErr.Message)
+		return
+	}
+	defer s.broker.Unsubscribe(taskID)
+	w.Header().Set(taskIDHeader, taskID)
+
+	// chunk returns a response carrying text in the form of the endpoint.
+	chunk := func(text string) models.OllamaResponse {
+		resp := models.OllamaResponse{Model: name, CreatedAt: time.Now().UTC()}
+		if chat {
+			resp.Message = &models.OllamaMessage{Role: "assistant", Content: text}
+		} else {
+			resp.Response = &text
+		}
+		return resp
+	}
+	final := func(text string, meta *models.TaskMetadata) models.OllamaResponse {
+		resp := chunk(text)
+		resp.Done = true
+		resp.DoneReason = "stop"
+		resp.TotalDuration = time.Since(start).Nanoseconds()
+		if meta != nil && meta.Usage != nil {
+			resp.PromptEvalCount = meta.Usage.PromptTokens
+			resp.EvalCount = meta.Usage.CompletionTokens
+		}
+		return resp
+	}
+
+	if !task.Stream {
+		result := collectResults(resCh)
+		if result.Err != nil {
+			writeOllamaError(w, statusForTaskError(result.Err), result.Err.Message)
+			return
+		}
+		writeJSON(w, final(result.Text, result.Metadata))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "Streaming not supported", http.StatusInternalServerError)
+		return
+	}
+	// Send the headers, with the task ID, while the task may still be queued.
+	flusher.Flush()
+
+	enc := json.NewEncoder(w)
+	send := func(v any) {
+		if err := enc.Encode(v); err != nil {
+			slog.ErrorContext(r.Context(), "Error writing stream response", "error", err)
+			return
+		}
+		flusher.Flush()
+	}
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case res, ok := <-resCh:
+			if res.Err != nil {
+				send(models.OllamaErrorResponse{Error: res.Err.Message})
+				return
+			}
+			if res.Text != "" {
+				send(chunk(res.Text))
+			}
+			if !ok || res.Done {
+				send(final("", res.Metadata))
+				return
+			}
+		}
+	}
+}
+
+func (s *HTTPServer) handleOllamaTags(w http.ResponseWriter, r *http.Request) {
+	var codes []string
+	if s.llmRegistry != nil {
+		codes = s.llmRegistry.ListModels()
+	}
+	sort.Strings(codes)
+
+	now := time.Now().UTC()
+	tags := models.OllamaTags{Models: make([]models.OllamaModel, len(codes))}
+	for i, code := range codes {
+		tags.Models[i] = models.OllamaModel{
+			Name:       code,
+			Model:      code,
+			ModifiedAt: now,
+			Digest:     ollamaDigest(code),
+			Details:    s.ollamaDetails(code),
+		}
+	}
+	writeJSON(w, tags)
+}
+
+func (s *HTTPServer) handleOllamaShow(w http.ResponseWriter, r *http.Request) {
+	var req models.OllamaShowRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeOllamaError(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+	name := req.Model
+	if name == "" {
+		name = req.Name
+	}
+	code, ok := s.ollamaModel(name)
+	if !ok {
+		writeOllamaError(w, http.StatusNotFound, fmt.Sprintf("model '%s' not found", name))
+		return
+	}
+
+	details := s.ollamaDetails(code)
+	writeJSON(w, models.OllamaShowResponse{
+		Modelfile:    fmt.Sprintf("# Served by Synapse from the %s provider\nFROM %s\n", details.Family, code),
+		Details:      details,
+		ModelInfo:    map[string]any{"general.architecture": details.Family},
+		Capabilities: []string{"completion"},
+		ModifiedAt:   time.Now().UTC(),
+	})
+}
+
+// ollamaDetails describes the model code, whose family is its provider.
+func (s *HTTPServer) ollamaDetails(code string) models.OllamaModelDetails {
+	provider := s.llmRegistry.ProviderOf(code)
+	return models.OllamaModelDetails{
+		Format:   "remote",
+		Family:   provider,
+		Families: []string{provider},
+	}
+}
+
+// ollamaDigest returns a stable digest for the model code, which Ollama
+// clients use to tell models apart.
+func ollamaDigest(code string) string {
+	sum := sha256.Sum256([]byte(code))
+	return hex.EncodeToString(sum[:])
+}
+
+func writeOllamaError(w http.ResponseWriter, status int, msg string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(models.OllamaErrorResponse{Error: msg})
+}
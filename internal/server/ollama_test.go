@@ -0,0 +1,83 @@
+package server
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/sokinpui/synapse.go/internal/models"
+)
+
+func postOllama(t *testing.T, h http.Handler, path, body string) *httptest.ResponseRecorder {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("POST", path, strings.NewReader(body)))
+	return rec
+}
+
+func TestOllamaResolvesLatestTag(t *testing.T) {
+	h, _ := responsesServer(t)
+
+	rec := postOllama(t, h, "/api/chat", `{"model": "scripted-test:latest", "stream": false, "messages": [{"role": "user", "content": "hi"}]}`)
+	var resp models.OllamaResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil || rec.Code != http.StatusOK {
+		t.Fatalf("POST /api/chat = %d %s", rec.Code, rec.Body)
+	}
+	if resp.Model != "scripted-test:latest" || !resp.Done || resp.Message == nil || resp.Message.Content != "plain answer" {
+		t.Errorf("response = %+v, want the answer under the requested name", resp)
+	}
+
+	rec = postOllama(t, h, "/api/show", `{"model": "scripted-test:latest"}`)
+	if rec.Code != http.StatusOK {
+		t.Errorf("POST /api/show = %d %s, want 200", rec.Code, rec.Body)
+	}
+	rec = postOllama(t, h, "/api/chat", `{"model": "nope:latest", "messages": [{"role": "user", "content": "hi"}]}`)
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("unknown model: got %d, want 404", rec.Code)
+	}
+}
+
+// ndjsonLines decodes each line of an NDJSON stream as a generic object.
+func ndjsonLines(t *testing.T, body string) []map[string]any {
+	t.Helper()
+	var lines []map[string]any
+	sc := bufio.NewScanner(strings.NewReader(body))
+	for sc.Scan() {
+		var line map[string]any
+		if err := json.Unmarshal(sc.Bytes(), &line); err != nil {
+			t.Fatalf("line %q is not JSON: %v", sc.Text(), err)
+		}
+		lines = append(lines, line)
+	}
+	return lines
+}
+
+func TestOllamaStreamOrder(t *testing.T) {
+	h, _ := responsesServer(t)
+
+	// Ollama streams unless told not to.
+	rec := postOllama(t, h, "/api/generate", `{"model": "scripted-test", "prompt": "hi"}`)
+	if ct := rec.Header().Get("Content-Type"); ct != "application/x-ndjson" {
+		t.Fatalf("Content-Type = %q, want application/x-ndjson", ct)
+	}
+	lines := ndjsonLines(t, rec.Body.String())
+	if len(lines) != 2 {
+		t.Fatalf("got %d lines, want a chunk and the final one:\n%s", len(lines), rec.Body)
+	}
+	if lines[0]["response"] != "plain answer" || lines[0]["done"] != false {
+		t.Errorf("first line = %v, want the text", lines[0])
+	}
+	if lines[1]["done"] != true || lines[1]["done_reason"] != "stop" {
+		t.Errorf("last line = %v, want it done", lines[1])
+	}
+
+	// A failure ends the stream with an error line and no final one.
+	rec = postOllama(t, h, "/api/chat", `{"model": "failing-test", "messages": [{"role": "user", "content": "hi"}]}`)
+	lines = ndjsonLines(t, rec.Body.String())
+	if len(lines) != 1 || lines[0]["error"] == nil {
+		t.Errorf("lines = %v, want only an error", lines)
+	}
+}
@@ -0,0 +1,413 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/sokinpui/synapse.go/internal/logging"
+	"github.com/sokinpui/synapse.go/internal/models"
+	"github.com/sokinpui/synapse.go/internal/responses"
+	"github.com/sokinpui/synapse.go/model"
+)
+
+// SetResponses keeps the responses of the Responses API in store, for
+// previous_response_id.
+func (s *HTTPServer) SetResponses(store *responses.Store) {
+	s.responses = store
+}
+
+func (s *HTTPServer) handleCreateResponse(w http.ResponseWriter, r *http.Request) {
+	var req models.ResponseRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeInvalidRequest(w, fmt.Sprintf("invalid request body: %v", err))
+		return
+	}
+
+	transcript, images, err := s.renderResponseInput(req.Input)
+	if err != nil {
+		writeInvalidRequest(w, fmt.Sprintf("input: %v", err))
+		return
+	}
+	tenant := r.Header.Get(tenantHeader)
+	if req.PreviousResponseID != "" {
+		prev, ok := s.responses.Get(tenant, req.PreviousResponseID)
+		if !ok {
+			writeOpenAIError(w, http.StatusNotFound, models.OpenAIErrorResponse{Error: models.OpenAIError{
+				Message: fmt.Sprintf("Previous response with id '%s' not found.", req.PreviousResponseID),
+				Type:    "invalid_request_error",
+			}})
+			return
+		}
+		transcript = prev.Transcript + transcript
+		images = append(append([][]byte{}, prev.Images...), images...)
+	}
+	tools, toolPrompt, err := offeredTools(req)
+	if err != nil {
+		writeInvalidRequest(w, err.Error())
+		return
+	}
+	prompt := toolPrompt + transcript
+	if req.Instructions != "" {
+		prompt = fmt.Sprintf("system: %s\n", req.Instructions) + prompt
+	}
+
+	s.observeModel(r, req.Model)
+
+	task := &models.GenerationTask{
+		TaskID:    uuid.New().String(),
+		Prompt:    prompt,
+		ModelCode: req.Model,
+		Stream:    req.Stream,
+		Config: &model.Config{
+			Temperature:  req.Temperature,
+			TopP:         req.TopP,
+			OutputLength: req.MaxOutputTokens,
+		},
+		Images: images,
+	}
+	taskID := task.TaskID
+	ctx := s.taskContext(r, task)
+	slog.InfoContext(ctx, "Received request", "api", "responses", logging.Prompt(task.Prompt))
+
+	resCh, err := s.submit(ctx, task, cacheControlOf(r))
+	if err != nil {
+		taskErr := unavailable(err)
+		writeOpenAIError(w, statusForTaskError(taskErr), openAIError(taskErr))
+		return
+	}
+	defer s.broker.Unsubscribe(taskID)
+	w.Header().Set(taskIDHeader, taskID)
+
+	resp := newResponse(req, task)
+	entry := responses.Entry{Tenant: tenant, Transcript: transcript, Images: images}
+	if req.Stream {
+		if !s.streamResponseEvents(w, r, &resp, resCh, tools) {
+			return
+		}
+	} else {
+		result := collectResults(resCh)
+		finishResponse(&resp, result, tools)
+		if result.Err != nil {
+			// The client never learns the response's ID, so there is
+			// nothing to store.
+			writeOpenAIError(w, statusForTaskError(result.Err), openAIError(result.Err))
+			return
+		}
+		writeJSON(w, resp)
+	}
+
+	if resp.Store {
+		entry.Response = resp
+		if resp.Status == "completed" {
+			entry.Transcript += renderOutput(resp)
+		}
+		entry.Created = time.Now()
+		s.responses.Put(entry)
+	}
+}
+
+func (s *HTTPServer) handleGetResponse(w http.ResponseWriter, r *http.Request) {
+	entry, ok := s.responses.Get(r.Header.Get(tenantHeader), r.PathValue("id"))
+	if !ok {
+		writeResponseNotFound(w, r.PathValue("id"))
+		return
+	}
+	writeJSON(w, entry.Response)
+}
+
+func (s *HTTPServer) handleDeleteResponse(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	if !s.responses.Delete(r.Header.Get(tenantHeader), id) {
+		writeResponseNotFound(w, id)
+		return
+	}
+	writeJSON(w, models.ResponseDeleted{ID: id, Object: "response", Deleted: true})
+}
+
+func writeResponseNotFound(w http.ResponseWriter, id string) {
+	writeOpenAIError(w, http.StatusNotFound, models.OpenAIErrorResponse{Error: models.OpenAIError{
+		Message: fmt.Sprintf("Response with id '%s' not found.", id),
+		Type:    "invalid_request_error",
+	}})
+}
+
+// renderResponseInput renders the input of a response request, a string or
+// a list of items, as a prompt in the form of parseOpenAIMessages.
+func (s *HTTPServer) renderResponseInput(input any) (string, [][]byte, error) {
+	switch input := input.(type) {
+	case nil:
+		return "", nil, errors.New("input is required")
+	case string:
+		return fmt.Sprintf("user: %s\n", input), nil, nil
+	case []any:
+	default:
+		return "", nil, errors.New("must be a string or a list of items")
+	}
+
+	// Decode the items again, now that input is known to be a list.
+	data, err := json.Marshal(input)
+	if err != nil {
+		return "", nil, err
+	}
+	var items []models.ResponseItem
+	if err := json.Unmarshal(data, &items); err != nil {
+		return "", nil, errors.New("must be a string or a list of items")
+	}
+
+	var sb strings.Builder
+	var images [][]byte
+	for i, item := range items {
+		switch item.Type {
+		case "", "message":
+			if item.Role == "" {
+				return "", nil, fmt.Errorf("item %d: role is required", i)
+			}
+			fmt.Fprintf(&sb, "%s: ", item.Role)
+			s.appendResponseContent(&sb, &images, item.Content)
+			sb.WriteString("\n")
+		case "function_call":
+			sb.WriteString(renderFunctionCall(item))
+		case "function_call_output":
+			fmt.Fprintf(&sb, "tool: output of call ID %s: %s\n", item.CallID, item.Output)
+		default:
+			return "", nil, fmt.Errorf("item %d: unsupported type %q", i, item.Type)
+		}
+	}
+	return sb.String(), images, nil
+}
+
+// appendResponseContent appends the text of a message item's content to
+// sb, and its images to images.
+func (s *HTTPServer) appendResponseContent(sb *strings.Builder, images *[][]byte, content any) {
+	if str, ok := content.(string); ok {
+		sb.WriteString(str)
+		return
+	}
+
+	parts, _ := content.([]any)
+	for _, p := range parts {
+		m, ok := p.(map[string]any)
+		if !ok {
+			continue
+		}
+		switch m["type"] {
+		case "input_text", "output_text", "text":
+			text, _ := m["text"].(string)
+			sb.WriteString(text)
+		case "input_image":
+			url, _ := m["image_url"].(string)
+			if data := s.decodeBase64Image(url); data != nil {
+				*images = append(*images, data)
+			}
+		}
+	}
+}
+
+// newResponse returns the in-progress response to req, generated by task.
+func newResponse(req models.ResponseRequest, task *models.GenerationTask) models.Response {
+	resp := models.Response{
+		ID:          fmt.Sprintf("resp_%s", task.TaskID),
+		Object:      "response",
+		CreatedAt:   time.Now().Unix(),
+		Status:      "in_progress",
+		Model:       task.ModelCode,
+		Output:      []models.ResponseItem{},
+		Temperature: req.Temperature,
+		TopP:        req.TopP,
+		Store:       req.Store == nil || *req.Store,
+		Metadata:    req.Metadata,
+	}
+	if req.Instructions != "" {
+		resp.Instructions = &req.Instructions
+	}
+	if req.PreviousResponseID != "" {
+		resp.PreviousResponseID = &req.PreviousResponseID
+	}
+	if req.MaxOutputTokens > 0 {
+		resp.MaxOutputTokens = &req.MaxOutputTokens
+	}
+	if resp.Metadata == nil {
+		resp.Metadata = map[string]string{}
+	}
+	resp.Tools = req.Tools
+	if resp.Tools == nil {
+		resp.Tools = []models.ResponseTool{}
+	}
+	resp.ToolChoice = req.ToolChoice
+	if resp.ToolChoice == nil {
+		resp.ToolChoice = "auto"
+	}
+	return resp
+}
+
+// messageItem returns the assistant message of resp with text.
+func messageItem(resp *models.Response, text, status string) models.ResponseItem {
+	return models.ResponseItem{
+		Type:    "message",
+		ID:      "msg_" + strings.TrimPrefix(resp.ID, "resp_"),
+		Status:  status,
+		Role:    "assistant",
+		Content: []models.ResponseContent{outputTextPart(text)},
+	}
+}
+
+func outputTextPart(text string) models.ResponseContent {
+	return models.ResponseContent{Type: "output_text", Text: text, Annotations: []any{}}
+}
+
+// finishResponse completes resp with the result of its task, which is a
+// list of function calls if it asks for any of tools.
+func finishResponse(resp *models.Response, result models.GenerateResponse, tools []models.ResponseTool) {
+	if result.Err != nil {
+		resp.Status = "failed"
+		resp.Error = &models.ResponseError{Code: result.Err.Code, Message: result.Err.Message}
+		return
+	}
+	resp.Status = "completed"
+	if calls, ok := parseFunctionCalls(result.Text, tools); ok {
+		resp.Output = calls
+	} else {
+		resp.Output = []models.ResponseItem{messageItem(resp, result.Text, "completed")}
+	}
+	if result.Metadata != nil && result.Metadata.Usage != nil {
+		u := result.Metadata.Usage
+		resp.Usage = &models.ResponseUsage{InputTokens: u.PromptTokens, OutputTokens: u.CompletionTokens, TotalTokens: u.TotalTokens}
+	}
+}
+
+// renderOutput renders the output of resp as the assistant's turn of a
+// prompt, like renderResponseInput renders input items.
+func renderOutput(resp models.Response) string {
+	var sb strings.Builder
+	for _, item := range resp.Output {
+		if item.Type == "function_call" {
+			sb.WriteString(renderFunctionCall(item))
+			continue
+		}
+		sb.WriteString("assistant: ")
+		parts, _ := item.Content.([]models.ResponseContent)
+		for _, p := range parts {
+			sb.WriteString(p.Text)
+		}
+		sb.WriteString("\n")
+	}
+	return sb.String()
+}
+
+func renderFunctionCall(item models.ResponseItem) string {
+	return fmt.Sprintf("assistant: called function %s(%s), call ID %s\n", item.Name, item.Arguments, item.CallID)
+}
+
+// streamResponseEvents streams the events of resp as its task's results
+// arrive, completing resp. It reports false if the client went away first.
+// With tools offered, the output is only known to be a message or function
+// calls once complete, so its events are all sent at the end.
+func (s *HTTPServer) streamResponseEvents(w http.ResponseWriter, r *http.Request, resp *models.Response, ch <-chan models.TaskResult, tools []models.ResponseTool) bool {
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "Streaming not supported", http.StatusInternalServerError)
+		return false
+	}
+
+	seq := 0
+	send := func(event models.ResponseStreamEvent) {
+		event.SequenceNumber = seq
+		seq++
+		jsonData, err := json.Marshal(event)
+		if err != nil {
+			slog.ErrorContext(r.Context(), "Error marshalling stream event", "error", err)
+			return
+		}
+		fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event.Type, jsonData)
+		flusher.Flush()
+	}
+	snapshot := func() *models.Response {
+		c := *resp
+		return &c
+	}
+
+	send(models.ResponseStreamEvent{Type: "response.created", Response: snapshot()})
+	send(models.ResponseStreamEvent{Type: "response.in_progress", Response: snapshot()})
+
+	zero := 0
+	itemID := messageItem(resp, "", "").ID
+	started := false
+	start := func() {
+		if started {
+			return
+		}
+		started = true
+		item := messageItem(resp, "", "in_progress")
+		item.Content = []models.ResponseContent{}
+		send(models.ResponseStreamEvent{Type: "response.output_item.added", OutputIndex: &zero, Item: &item})
+		part := outputTextPart("")
+		send(models.ResponseStreamEvent{Type: "response.content_part.added", OutputIndex: &zero, ContentIndex: &zero, ItemID: itemID, Part: &part})
+	}
+
+	var sb strings.Builder
+	var result models.GenerateResponse
+	for done := false; !done; {
+		select {
+		case <-r.Context().Done():
+			return false
+		case res, ok := <-ch:
+			if res.Err != nil {
+				result.Err = res.Err
+			}
+			if res.Metadata != nil {
+				result.Metadata = res.Metadata
+			}
+			if res.Text != "" {
+				sb.WriteString(res.Text)
+				if len(tools) == 0 {
+					start()
+					send(models.ResponseStreamEvent{Type: "response.output_text.delta", OutputIndex: &zero, ContentIndex: &zero, ItemID: itemID, Delta: res.Text})
+				}
+			}
+			done = !ok || res.Done
+		}
+	}
+	result.Text = sb.String()
+	finishResponse(resp, result, tools)
+
+	if result.Err != nil {
+		send(models.ResponseStreamEvent{Type: "response.failed", Response: snapshot()})
+		return true
+	}
+
+	for i, item := range resp.Output {
+		index := i
+		if item.Type == "function_call" {
+			added := item
+			added.Status, added.Arguments = "in_progress", ""
+			send(models.ResponseStreamEvent{Type: "response.output_item.added", OutputIndex: &index, Item: &added})
+			send(models.ResponseStreamEvent{Type: "response.function_call_arguments.delta", OutputIndex: &index, ItemID: item.ID, Delta: item.Arguments})
+			args := item.Arguments
+			send(models.ResponseStreamEvent{Type: "response.function_call_arguments.done", OutputIndex: &index, ItemID: item.ID, Arguments: &args})
+			send(models.ResponseStreamEvent{Type: "response.output_item.done", OutputIndex: &index, Item: &item})
+			continue
+		}
+
+		start()
+		text := result.Text
+		if len(tools) > 0 && text != "" {
+			send(models.ResponseStreamEvent{Type: "response.output_text.delta", OutputIndex: &zero, ContentIndex: &zero, ItemID: itemID, Delta: text})
+		}
+		send(models.ResponseStreamEvent{Type: "response.output_text.done", OutputIndex: &zero, ContentIndex: &zero, ItemID: itemID, Text: &text})
+		part := outputTextPart(text)
+		send(models.ResponseStreamEvent{Type: "response.content_part.done", OutputIndex: &zero, ContentIndex: &zero, ItemID: itemID, Part: &part})
+		send(models.ResponseStreamEvent{Type: "response.output_item.done", OutputIndex: &zero, Item: &item})
+	}
+	send(models.ResponseStreamEvent{Type: "response.completed", Response: snapshot()})
+	return true
+}
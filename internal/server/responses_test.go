@@ -0,0 +1,197 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/sokinpui/synapse.go/internal/broker"
+	"github.com/sokinpui/synapse.go/internal/config"
+	"github.com/sokinpui/synapse.go/internal/models"
+	"github.com/sokinpui/synapse.go/internal/responses"
+	"github.com/sokinpui/synapse.go/internal/worker"
+	"github.com/sokinpui/synapse.go/model"
+)
+
+// scriptedLLM answers with the function call of its script when offered
+// functions, and with plain text otherwise. It fails with err if set.
+type scriptedLLM struct {
+	call string
+	err  error
+}
+
+func (m *scriptedLLM) answer(prompt string) (string, error) {
+	if m.err != nil {
+		return "", m.err
+	}
+	if strings.Contains(prompt, "function_calls") {
+		return m.call, nil
+	}
+	return "plain answer", nil
+}
+
+func (m *scriptedLLM) Generate(ctx context.Context, prompt string, images [][]byte, config *model.Config) (string, error) {
+	return m.answer(prompt)
+}
+
+func (m *scriptedLLM) GenerateStream(ctx context.Context, prompt string, images [][]byte, config *model.Config) (<-chan string, <-chan error) {
+	outCh := make(chan string, 1)
+	errCh := make(chan error, 1)
+	if text, err := m.answer(prompt); err != nil {
+		errCh <- err
+	} else {
+		outCh <- text
+	}
+	close(outCh)
+	close(errCh)
+	return outCh, errCh
+}
+
+func (m *scriptedLLM) CountTokens(prompt string) (int, error) { return 0, nil }
+
+func init() {
+	model.RegisterProvider("scripted", func(cfg *config.Config) (map[string]model.LLM, error) {
+		return map[string]model.LLM{
+			"scripted-test": &scriptedLLM{call: "```json\n" + `{"function_calls": [{"name": "get_weather", "arguments": {"city": "Paris"}}]}` + "\n```"},
+			"failing-test":  &scriptedLLM{err: errors.New("upstream failed")},
+		}, nil
+	})
+}
+
+// responsesServer runs a server and worker over the scripted models.
+func responsesServer(t *testing.T) (http.Handler, *responses.Store) {
+	t.Helper()
+	cfg := &config.Config{}
+	registry, err := model.New(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := broker.NewMemoryBroker(10)
+	w := worker.New(b, registry, 1, cfg.Worker)
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	go w.Run(ctx)
+
+	store := responses.New(config.ResponsesConfig{})
+	s := NewHTTPServer(b, registry, cfg)
+	s.SetResponses(store)
+	mux := http.NewServeMux()
+	s.RegisterRoutes(mux)
+	return mux, store
+}
+
+func postResponse(t *testing.T, h http.Handler, body string) *httptest.ResponseRecorder {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("POST", "/v1/responses", strings.NewReader(body)))
+	return rec
+}
+
+const weatherTool = `"tools": [{"type": "function", "name": "get_weather", "description": "Get the weather.",
+	"parameters": {"type": "object", "properties": {"city": {"type": "string"}}}}]`
+
+func TestResponseCallsFunctions(t *testing.T) {
+	h, store := responsesServer(t)
+
+	rec := postResponse(t, h, `{"model": "scripted-test", "input": "Weather in Paris?", `+weatherTool+`}`)
+	var resp models.Response
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil || rec.Code != http.StatusOK {
+		t.Fatalf("POST /v1/responses = %d %s", rec.Code, rec.Body)
+	}
+	if len(resp.Output) != 1 {
+		t.Fatalf("output = %+v, want one function call", resp.Output)
+	}
+	call := resp.Output[0]
+	if call.Type != "function_call" || call.Name != "get_weather" || call.Arguments != `{"city":"Paris"}` || call.CallID == "" {
+		t.Errorf("output item = %+v, want a call of get_weather for Paris", call)
+	}
+	if len(resp.Tools) != 1 || resp.ToolChoice != "auto" {
+		t.Errorf("tools = %+v, tool_choice = %v; want the request's", resp.Tools, resp.ToolChoice)
+	}
+
+	// The call is part of the conversation continued from the response.
+	entry, ok := store.Get("", resp.ID)
+	if !ok || !strings.Contains(entry.Transcript, "called function get_weather") {
+		t.Errorf("stored transcript = %q, want the function call", entry.Transcript)
+	}
+}
+
+func TestResponseWithoutToolsAnswersText(t *testing.T) {
+	h, _ := responsesServer(t)
+
+	for _, body := range []string{
+		`{"model": "scripted-test", "input": "hi"}`,
+		`{"model": "scripted-test", "input": "hi", "tool_choice": "none", ` + weatherTool + `}`,
+	} {
+		rec := postResponse(t, h, body)
+		var resp models.Response
+		json.Unmarshal(rec.Body.Bytes(), &resp)
+		if len(resp.Output) != 1 || resp.Output[0].Type != "message" {
+			t.Errorf("%s: output = %+v, want a message", body, resp.Output)
+		}
+	}
+}
+
+func TestResponseStreamsFunctionCalls(t *testing.T) {
+	h, _ := responsesServer(t)
+
+	rec := postResponse(t, h, `{"model": "scripted-test", "input": "Weather?", "stream": true, `+weatherTool+`}`)
+	body := rec.Body.String()
+	for _, event := range []string{
+		"response.output_item.added",
+		"response.function_call_arguments.delta",
+		"response.function_call_arguments.done",
+		"response.output_item.done",
+		"response.completed",
+	} {
+		if !strings.Contains(body, "event: "+event+"\n") {
+			t.Errorf("stream lacks %s:\n%s", event, body)
+		}
+	}
+	if strings.Contains(body, "response.output_text.delta") {
+		t.Errorf("stream sent the function call as text:\n%s", body)
+	}
+}
+
+func TestResponseRejectsUnsupportedTools(t *testing.T) {
+	h, _ := responsesServer(t)
+
+	for _, body := range []string{
+		`{"model": "scripted-test", "input": "hi", "tools": [{"type": "web_search"}]}`,
+		`{"model": "scripted-test", "input": "hi", "tool_choice": {"type": "function", "name": "nope"}, ` + weatherTool + `}`,
+	} {
+		if rec := postResponse(t, h, body); rec.Code != http.StatusBadRequest {
+			t.Errorf("%s: got %d, want 400", body, rec.Code)
+		}
+	}
+}
+
+func TestResponseRejectsInvalidBodyAsOpenAIError(t *testing.T) {
+	h, _ := responsesServer(t)
+
+	rec := postResponse(t, h, `{"model":`)
+	var resp models.OpenAIErrorResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("body %q is not an OpenAI error: %v", rec.Body, err)
+	}
+	if rec.Code != http.StatusBadRequest || resp.Error.Type != "invalid_request_error" {
+		t.Errorf("got %d %+v, want 400 invalid_request_error", rec.Code, resp.Error)
+	}
+}
+
+func TestFailedResponseIsNotStored(t *testing.T) {
+	h, store := responsesServer(t)
+
+	rec := postResponse(t, h, `{"model": "failing-test", "input": "hi"}`)
+	if rec.Code == http.StatusOK {
+		t.Fatalf("POST /v1/responses = %d, want an error", rec.Code)
+	}
+	taskID := rec.Header().Get(taskIDHeader)
+	if _, ok := store.Get("", "resp_"+taskID); ok {
+		t.Error("the failed response was stored")
+	}
+}
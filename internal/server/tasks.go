@@ -0,0 +1,47 @@
+package server
+
+import (
+	"log/slog"
+	"net/http"
+
+	"github.com/sokinpui/synapse.go/internal/broker"
+)
+
+// taskStatus returns the status of the task named in the request, if the
+// requesting tenant submitted it.
+func (s *HTTPServer) taskStatus(w http.ResponseWriter, r *http.Request) (broker.TaskStatus, bool) {
+	st, ok := s.broker.Status(r.PathValue("id"))
+	if !ok || st.Tenant != r.Header.Get(tenantHeader) {
+		writeJSONError(w, http.StatusNotFound, "unknown task, or finished too long ago")
+		return broker.TaskStatus{}, false
+	}
+	return st, true
+}
+
+func (s *HTTPServer) handleTaskStatus(w http.ResponseWriter, r *http.Request) {
+	if st, ok := s.taskStatus(w, r); ok {
+		writeJSON(w, st)
+	}
+}
+
+// handleCancelTask cancels a pending task. The request serving the task
+// receives its final result at once.
+func (s *HTTPServer) handleCancelTask(w http.ResponseWriter, r *http.Request) {
+	st, ok := s.taskStatus(w, r)
+	if !ok {
+		return
+	}
+	if st.Finished() {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusConflict)
+		writeJSON(w, st)
+		return
+	}
+
+	s.broker.SignalCancel(st.TaskID)
+	slog.InfoContext(r.Context(), "Task cancel requested", "task_id", st.TaskID)
+	st, _ = s.broker.Status(st.TaskID)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	writeJSON(w, st)
+}
@@ -0,0 +1,142 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/sokinpui/synapse.go/internal/models"
+)
+
+// Models take text only, so the function tools of a response request are
+// offered in the prompt, and the model asks to call them by answering with
+// a JSON object of the calls alone:
+//
+//	{"function_calls": [{"name": "get_weather", "arguments": {"city": "Paris"}}]}
+
+// offeredTools returns the tools of req the model may call, and the system
+// prompt that offers them. With tool_choice "none" no tools are offered.
+func offeredTools(req models.ResponseRequest) ([]models.ResponseTool, string, error) {
+	names := map[string]bool{}
+	for i, tool := range req.Tools {
+		if tool.Type != "function" {
+			return nil, "", fmt.Errorf("tools[%d]: unsupported tool type %q, only functions are supported", i, tool.Type)
+		}
+		if tool.Name == "" {
+			return nil, "", fmt.Errorf("tools[%d]: name is required", i)
+		}
+		names[tool.Name] = true
+	}
+
+	var rule string
+	switch choice := req.ToolChoice.(type) {
+	case nil, string:
+		switch choice {
+		case nil, "auto":
+			rule = "If no function is needed, answer as usual instead."
+		case "required":
+			rule = "You must call at least one function."
+		case "none":
+			return nil, "", nil
+		default:
+			return nil, "", fmt.Errorf("tool_choice: unsupported value %q", choice)
+		}
+	case map[string]any:
+		name, _ := choice["name"].(string)
+		if choice["type"] != "function" || !names[name] {
+			return nil, "", errors.New("tool_choice: must name a function among tools")
+		}
+		rule = fmt.Sprintf("You must call the function %s.", name)
+	default:
+		return nil, "", errors.New("tool_choice: must be a string or a function")
+	}
+	if len(req.Tools) == 0 {
+		return nil, "", nil
+	}
+
+	var sb strings.Builder
+	sb.WriteString("system: You can call the following functions, each given with what it does and the JSON Schema of its arguments.\n")
+	for _, tool := range req.Tools {
+		fmt.Fprintf(&sb, "- %s: %s Arguments: %s\n", tool.Name, tool.Description, bytes.TrimSpace(tool.Parameters))
+	}
+	sb.WriteString(`To call functions, answer with only a JSON object of the form {"function_calls": [{"name": "<function>", "arguments": {<arguments>}}]} and no other text. `)
+	sb.WriteString(rule)
+	sb.WriteString("\n")
+	return req.Tools, sb.String(), nil
+}
+
+// parseFunctionCalls returns the function call items text asks for, if it
+// is a JSON object of calls to tools, possibly in a code fence.
+func parseFunctionCalls(text string, tools []models.ResponseTool) ([]models.ResponseItem, bool) {
+	if len(tools) == 0 {
+		return nil, false
+	}
+	text = strings.TrimSpace(text)
+	if fenced, ok := strings.CutPrefix(text, "```"); ok {
+		fenced = strings.TrimPrefix(fenced, "json")
+		text = strings.TrimSpace(strings.TrimSuffix(fenced, "```"))
+	}
+	var answer struct {
+		FunctionCalls []struct {
+			Name      string          `json:"name"`
+			Arguments json.RawMessage `json:"arguments"`
+		} `json:"function_calls"`
+	}
+	if err := json.Unmarshal([]byte(text), &answer); err != nil || len(answer.FunctionCalls) == 0 {
+		return nil, false
+	}
+
+	items := make([]models.ResponseItem, 0, len(answer.FunctionCalls))
+	for _, call := range answer.FunctionCalls {
+		if !offers(tools, call.Name) {
+			return nil, false
+		}
+		args, ok := callArguments(call.Arguments)
+		if !ok {
+			return nil, false
+		}
+		id := uuid.New().String()
+		items = append(items, models.ResponseItem{
+			Type:      "function_call",
+			ID:        "fc_" + id,
+			Status:    "completed",
+			CallID:    "call_" + id,
+			Name:      call.Name,
+			Arguments: args,
+		})
+	}
+	return items, true
+}
+
+func offers(tools []models.ResponseTool, name string) bool {
+	for _, tool := range tools {
+		if tool.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// callArguments returns the arguments of a call as a compact JSON object,
+// also accepting them encoded as a string, as the OpenAI API sends them.
+func callArguments(raw json.RawMessage) (string, bool) {
+	if len(raw) == 0 || string(raw) == "null" {
+		return "{}", true
+	}
+	var encoded string
+	if json.Unmarshal(raw, &encoded) == nil {
+		raw = json.RawMessage(encoded)
+	}
+	var args map[string]any
+	if json.Unmarshal(raw, &args) != nil {
+		return "", false
+	}
+	var buf bytes.Buffer
+	if json.Compact(&buf, raw) != nil {
+		return "", false
+	}
+	return buf.String(), true
+}
@@ -0,0 +1,139 @@
+package server
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/sokinpui/synapse.go/internal/broker"
+	"github.com/sokinpui/synapse.go/internal/config"
+	"github.com/sokinpui/synapse.go/internal/tracing"
+	"github.com/sokinpui/synapse.go/internal/worker"
+	"github.com/sokinpui/synapse.go/model"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+// fakeGemini answers every generateContent call with the same text, and
+// every embedding call with the same vector.
+func fakeGemini(t *testing.T) {
+	t.Helper()
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if strings.HasSuffix(r.URL.Path, "mbedContents") {
+			io.WriteString(w, `{"embeddings":[{"values":[0.5,0.25]}]}`)
+			return
+		}
+		io.WriteString(w, `{"candidates":[{"content":{"role":"model","parts":[{"text":"hello"}]}}],
+			"usageMetadata":{"promptTokenCount":3,"candidatesTokenCount":1}}`)
+	}))
+	t.Cleanup(upstream.Close)
+	t.Setenv("GOOGLE_GEMINI_BASE_URL", upstream.URL)
+	t.Setenv("GENAI_API_KEYS", "test-key")
+}
+
+func TestTraceSpansRequestWorkerAndProvider(t *testing.T) {
+	fakeGemini(t)
+	exporter := tracetest.NewInMemoryExporter()
+	tp := tracing.NewProvider(exporter, config.TracingConfig{})
+	t.Cleanup(func() { tp.Shutdown(context.Background()) })
+
+	cfg := &config.Config{}
+	cfg.Models.Gemini.Codes = []string{"gemini-test"}
+	registry, err := model.New(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := broker.NewMemoryBroker(10)
+	w := worker.New(b, registry, 1, cfg.Worker)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go w.Run(ctx)
+
+	mux := http.NewServeMux()
+	NewHTTPServer(b, registry, cfg).RegisterRoutes(mux)
+	srv := httptest.NewServer(Trace(mux))
+	defer srv.Close()
+
+	resp, err := http.Post(srv.URL+"/generate", "application/json",
+		strings.NewReader(`{"prompt":"hi","model_code":"gemini-test"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || !strings.Contains(string(body), "hello") {
+		t.Fatalf("POST /generate = %d %s", resp.StatusCode, body)
+	}
+
+	if err := tp.ForceFlush(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	spans := map[string]tracetest.SpanStub{}
+	for _, s := range exporter.GetSpans() {
+		spans[s.Name] = s
+	}
+	httpSpan, ok := spans["POST /generate"]
+	if !ok {
+		t.Fatalf("no HTTP span among %v", names(spans))
+	}
+
+	// Each span's parent, and the attributes it must carry.
+	want := []struct {
+		name, parent string
+		attrs        []attribute.KeyValue
+	}{
+		{"broker.queue", "POST /generate", nil},
+		{"worker.process", "POST /generate", []attribute.KeyValue{
+			attribute.String("model", "gemini-test"),
+			attribute.String("outcome", "ok"),
+			attribute.Int("attempts", 1),
+		}},
+		{"gemini.key_attempt", "worker.process", []attribute.KeyValue{
+			attribute.String("model", "gemini-test"),
+			attribute.Int("key_index", 0),
+		}},
+		{"gemini.GenerateContent", "gemini.key_attempt", nil},
+	}
+	taskID := ""
+	for _, w := range want {
+		span, ok := spans[w.name]
+		if !ok {
+			t.Errorf("no %s span among %v", w.name, names(spans))
+			continue
+		}
+		parent := spans[w.parent]
+		if span.Parent.SpanID() != parent.SpanContext.SpanID() {
+			t.Errorf("%s is not a child of %s", w.name, w.parent)
+		}
+		if span.SpanContext.TraceID() != httpSpan.SpanContext.TraceID() {
+			t.Errorf("%s is not in the request's trace", w.name)
+		}
+
+		attrs := map[attribute.Key]attribute.Value{}
+		for _, a := range span.Attributes {
+			attrs[a.Key] = a.Value
+		}
+		for _, a := range w.attrs {
+			if got, ok := attrs[a.Key]; !ok || got != a.Value {
+				t.Errorf("%s: %s = %v, want %v", w.name, a.Key, got.Emit(), a.Value.Emit())
+			}
+		}
+		id := attrs["task_id"].AsString()
+		if id == "" || (taskID != "" && id != taskID) {
+			t.Errorf("%s: task_id = %q, want the task's ID", w.name, id)
+		}
+		taskID = id
+	}
+}
+
+func names(spans map[string]tracetest.SpanStub) []string {
+	var out []string
+	for name := range spans {
+		out = append(out, name)
+	}
+	return out
+}
@@ -0,0 +1,259 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/websocket"
+	"github.com/sokinpui/synapse.go/internal/logging"
+	"github.com/sokinpui/synapse.go/internal/models"
+)
+
+const (
+	// maxWSTasks bounds the tasks a WebSocket connection may run at once.
+	maxWSTasks = 64
+	// maxWSMessageBytes bounds a client message, which may carry images.
+	maxWSMessageBytes = 32 << 20
+	wsWriteTimeout    = 10 * time.Second
+	// The server pings the client every wsPingInterval and drops it if it
+	// hears nothing back within wsPongTimeout.
+	wsPingInterval = 30 * time.Second
+	wsPongTimeout  = 60 * time.Second
+)
+
+var upgrader = websocket.Upgrader{}
+
+// wsConn is a WebSocket connection over which a client runs any number of
+// tasks at once.
+type wsConn struct {
+	s    *HTTPServer
+	r    *http.Request
+	conn *websocket.Conn
+	cc   cacheControl
+
+	// ctx is canceled when the connection ends, which stops the tasks
+	// running on it.
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	writeMu sync.Mutex
+
+	// tasks maps the IDs of the tasks running on the connection to the
+	// IDs of the messages that started them.
+	mu    sync.Mutex
+	tasks map[string]string
+	wg    sync.WaitGroup
+}
+
+// handleWebSocket serves tasks over a WebSocket connection. The client sends
+// WSMessages and the server answers with WSFrames, tagged by task ID, as the
+// tasks produce them. The headers of the upgrade request, such as the tenant
+// and Cache-Control, apply to every task on the connection.
+func (s *HTTPServer) handleWebSocket(w http.ResponseWriter, r *http.Request) {
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		// Upgrade has already replied with an error.
+		slog.DebugContext(r.Context(), "WebSocket upgrade failed", "error", err)
+		return
+	}
+
+	ctx, cancel := context.WithCancel(r.Context())
+	c := &wsConn{
+		s:      s,
+		r:      r,
+		conn:   conn,
+		cc:     cacheControlOf(r),
+		ctx:    ctx,
+		cancel: cancel,
+		tasks:  make(map[string]string),
+	}
+	defer func() {
+		c.close()
+		c.wg.Wait()
+	}()
+
+	go c.ping()
+	c.readMessages()
+}
+
+// readMessages handles the client's messages until the connection ends.
+func (c *wsConn) readMessages() {
+	c.conn.SetReadLimit(maxWSMessageBytes)
+	c.conn.SetReadDeadline(time.Now().Add(wsPongTimeout))
+	c.conn.SetPongHandler(func(string) error {
+		return c.conn.SetReadDeadline(time.Now().Add(wsPongTimeout))
+	})
+
+	for {
+		_, data, err := c.conn.ReadMessage()
+		if err != nil {
+			if websocket.IsUnexpectedCloseError(err, websocket.CloseNormalClosure, websocket.CloseGoingAway) {
+				slog.InfoContext(c.r.Context(), "WebSocket connection lost", "error", err)
+			}
+			return
+		}
+		c.conn.SetReadDeadline(time.Now().Add(wsPongTimeout))
+
+		var msg models.WSMessage
+		if err := json.Unmarshal(data, &msg); err != nil {
+			c.reject(msg.ID, "invalid message")
+			continue
+		}
+		switch msg.Type {
+		case "generate":
+			c.generate(msg)
+		case "cancel":
+			c.cancelTask(msg)
+		default:
+			c.reject(msg.ID, fmt.Sprintf("unknown message type %q", msg.Type))
+		}
+	}
+}
+
+func (c *wsConn) generate(msg models.WSMessage) {
+	if msg.Request == nil {
+		c.reject(msg.ID, "request is required")
+		return
+	}
+	c.mu.Lock()
+	running := len(c.tasks)
+	_, inUse := c.lookup(msg.ID)
+	c.mu.Unlock()
+	if running >= maxWSTasks {
+		c.reject(msg.ID, fmt.Sprintf("at most %d tasks may run at once on a connection", maxWSTasks))
+		return
+	}
+	if inUse {
+		c.reject(msg.ID, fmt.Sprintf("id %q is in use by a running task", msg.ID))
+		return
+	}
+
+	task := msg.Request
+	task.TaskID = uuid.New().String()
+	c.s.observeModel(c.r, task.ModelCode)
+	ctx := c.s.taskContext(c.r, task)
+	slog.InfoContext(ctx, "Received request", "api", "websocket", logging.Prompt(task.Prompt))
+
+	resCh, err := c.s.submit(ctx, task, c.cc)
+	if err != nil {
+		c.send(models.WSFrame{Type: "error", ID: msg.ID, Error: unavailable(err)})
+		return
+	}
+
+	c.mu.Lock()
+	c.tasks[task.TaskID] = msg.ID
+	c.mu.Unlock()
+	c.wg.Add(1)
+	go c.forward(msg.ID, task.TaskID, resCh)
+}
+
+// cancelTask cancels a task running on the connection, named by its task ID
+// or, before the client has learned that, by the ID of the message that
+// started it. The task then ends with its last frame as usual.
+func (c *wsConn) cancelTask(msg models.WSMessage) {
+	c.mu.Lock()
+	taskID := msg.TaskID
+	_, running := c.tasks[taskID]
+	if taskID == "" {
+		taskID, running = c.lookup(msg.ID)
+	}
+	c.mu.Unlock()
+	if !running {
+		c.reject(msg.ID, "no such task is running on this connection")
+		return
+	}
+	c.s.broker.SignalCancel(taskID)
+}
+
+// lookup returns the running task started by the message with the given ID.
+// The caller must hold c.mu.
+func (c *wsConn) lookup(id string) (string, bool) {
+	if id == "" {
+		return "", false
+	}
+	for taskID, msgID := range c.tasks {
+		if msgID == id {
+			return taskID, true
+		}
+	}
+	return "", false
+}
+
+// forward sends the results of a task as frames until it is done or the
+// connection ends.
+func (c *wsConn) forward(id, taskID string, ch <-chan models.TaskResult) {
+	defer c.wg.Done()
+	defer func() {
+		c.mu.Lock()
+		delete(c.tasks, taskID)
+		c.mu.Unlock()
+		c.s.broker.Unsubscribe(taskID)
+	}()
+
+	for {
+		select {
+		case <-c.ctx.Done():
+			return
+		case res, ok := <-ch:
+			if res.Err != nil {
+				c.send(models.WSFrame{Type: "error", ID: id, TaskID: taskID, Error: res.Err, Metadata: res.Metadata})
+				return
+			}
+			if res.Text != "" {
+				c.send(models.WSFrame{Type: "chunk", ID: id, TaskID: taskID, Text: res.Text})
+			}
+			if !ok || res.Done {
+				if res.Metadata != nil && res.Metadata.Usage != nil {
+					c.send(models.WSFrame{Type: "usage", ID: id, TaskID: taskID, Usage: res.Metadata.Usage})
+				}
+				c.send(models.WSFrame{Type: "done", ID: id, TaskID: taskID, Metadata: res.Metadata})
+				return
+			}
+		}
+	}
+}
+
+// reject answers a message the server could not act on.
+func (c *wsConn) reject(id, msg string) {
+	c.send(models.WSFrame{Type: "error", ID: id, Error: &models.TaskError{Code: models.ErrCodeInvalidRequest, Message: msg}})
+}
+
+// send writes a frame, ending the connection if the client cannot take it.
+func (c *wsConn) send(frame models.WSFrame) {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+
+	c.conn.SetWriteDeadline(time.Now().Add(wsWriteTimeout))
+	if err := c.conn.WriteJSON(frame); err != nil {
+		slog.DebugContext(c.r.Context(), "Error writing WebSocket frame", "error", err)
+		c.close()
+	}
+}
+
+func (c *wsConn) ping() {
+	ticker := time.NewTicker(wsPingInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-c.ctx.Done():
+			return
+		case <-ticker.C:
+			if err := c.conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(wsWriteTimeout)); err != nil {
+				c.close()
+				return
+			}
+		}
+	}
+}
+
+// close ends the connection, which also ends the read loop.
+func (c *wsConn) close() {
+	c.cancel()
+	c.conn.Close()
+}
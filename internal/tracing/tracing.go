@@ -0,0 +1,135 @@
+// Package tracing sets up OpenTelemetry tracing and carries trace context
+// across the broker, where a task leaves the request's goroutine.
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/sokinpui/synapse.go/internal/config"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const tracerName = "github.com/sokinpui/synapse.go"
+
+var propagator = propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{})
+
+// Setup exports spans over OTLP/HTTP to the configured endpoint. Without an
+// endpoint tracing stays disabled and spans cost next to nothing. The
+// returned function flushes and stops the exporter.
+func Setup(ctx context.Context, cfg config.TracingConfig) (func(context.Context) error, error) {
+	otel.SetTextMapPropagator(propagator)
+	if cfg.Endpoint == "" {
+		return func(context.Context) error { return nil }, nil
+	}
+
+	opts := []otlptracehttp.Option{otlptracehttp.WithEndpointURL(cfg.Endpoint)}
+	if len(cfg.Headers) > 0 {
+		opts = append(opts, otlptracehttp.WithHeaders(cfg.Headers))
+	}
+	exporter, err := otlptracehttp.New(ctx, opts...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create OTLP exporter: %w", err)
+	}
+
+	tp := NewProvider(exporter, cfg)
+	return tp.Shutdown, nil
+}
+
+// NewProvider installs a tracer provider that sends spans to exporter in
+// batches. Tests can pass an in-memory exporter from the SDK's tracetest
+// package and call ForceFlush before inspecting it.
+func NewProvider(exporter sdktrace.SpanExporter, cfg config.TracingConfig) *sdktrace.TracerProvider {
+	serviceName := cfg.ServiceName
+	if serviceName == "" {
+		serviceName = "synapse"
+	}
+	sampler := sdktrace.AlwaysSample()
+	if cfg.SampleRatio > 0 && cfg.SampleRatio < 1 {
+		sampler = sdktrace.TraceIDRatioBased(cfg.SampleRatio)
+	}
+
+	tp := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithSampler(sdktrace.ParentBased(sampler)),
+		sdktrace.WithResource(resource.NewSchemaless(semconv.ServiceName(serviceName))),
+	)
+	otel.SetTracerProvider(tp)
+	otel.SetTextMapPropagator(propagator)
+	return tp
+}
+
+type taskIDKey struct{}
+
+// WithTaskID returns a context whose spans are tagged with the task ID.
+func WithTaskID(ctx context.Context, taskID string) context.Context {
+	return context.WithValue(ctx, taskIDKey{}, taskID)
+}
+
+// TagTask tags the current span of ctx with the task ID and returns a
+// context whose later spans are tagged too.
+func TagTask(ctx context.Context, taskID string) context.Context {
+	trace.SpanFromContext(ctx).SetAttributes(attribute.String("task_id", taskID))
+	return WithTaskID(ctx, taskID)
+}
+
+// Start starts a span, tagged with the task ID of ctx if it has one.
+func Start(ctx context.Context, name string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	if taskID, ok := ctx.Value(taskIDKey{}).(string); ok {
+		attrs = append(attrs, attribute.String("task_id", taskID))
+	}
+	return otel.Tracer(tracerName).Start(ctx, name, trace.WithAttributes(attrs...))
+}
+
+// Record records a span that started at start and ends now, for intervals
+// such as time spent queued that were not observed as they happened.
+func Record(ctx context.Context, name string, start time.Time, attrs ...attribute.KeyValue) {
+	if taskID, ok := ctx.Value(taskIDKey{}).(string); ok {
+		attrs = append(attrs, attribute.String("task_id", taskID))
+	}
+	_, span := otel.Tracer(tracerName).Start(ctx, name, trace.WithTimestamp(start), trace.WithAttributes(attrs...))
+	span.End()
+}
+
+// End records err on span, if any, and ends it.
+func End(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// Inject returns the trace context of ctx in a form that can travel with a
+// task, or nil if ctx has none.
+func Inject(ctx context.Context) map[string]string {
+	carrier := propagation.MapCarrier{}
+	otel.GetTextMapPropagator().Inject(ctx, carrier)
+	if len(carrier) == 0 {
+		return nil
+	}
+	return carrier
+}
+
+// Extract returns ctx with the trace context carried by a task.
+func Extract(ctx context.Context, carrier map[string]string) context.Context {
+	if len(carrier) == 0 {
+		return ctx
+	}
+	return otel.GetTextMapPropagator().Extract(ctx, propagation.MapCarrier(carrier))
+}
+
+// ExtractHTTP returns ctx with the trace context of incoming request headers.
+func ExtractHTTP(ctx context.Context, header http.Header) context.Context {
+	return otel.GetTextMapPropagator().Extract(ctx, propagation.HeaderCarrier(header))
+}
@@ -0,0 +1,94 @@
+package worker
+
+import (
+	"strings"
+	"time"
+
+	"github.com/sokinpui/synapse.go/internal/broker"
+	"github.com/sokinpui/synapse.go/internal/models"
+	"github.com/sokinpui/synapse.go/model"
+)
+
+// Completion describes a task the worker is done with, for observers such
+// as the audit log.
+type Completion struct {
+	Task *models.GenerationTask
+	// Text is the whole response, including any part streamed before a
+	// failure.
+	Text     string
+	Err      *models.TaskError
+	Metadata *models.TaskMetadata
+	// Charges is the usage of each successful upstream call. Tasks that
+	// shared another task's generation have none.
+	Charges []model.Charge
+	// Outcome is one of ok, error, timeout, canceled, shutdown or rejected,
+	// or cached for tasks the server answered from the response cache.
+	Outcome  string
+	Started  time.Time
+	Finished time.Time
+}
+
+// OnComplete registers fn to be called for every task the worker finishes,
+// whether it succeeded, failed or was rejected by a drain. fn runs on the
+// worker goroutine, after the task's last result has been published. It
+// must be called before Run.
+func (w *GenAIWorker) OnComplete(fn func(Completion)) {
+	w.onComplete = append(w.onComplete, fn)
+}
+
+// taskOutput publishes a task's results and, if anyone observes
+// completions, keeps what it published.
+type taskOutput struct {
+	broker  *broker.MemoryBroker
+	task    *models.GenerationTask
+	keep    bool
+	started time.Time
+
+	text strings.Builder
+	err  *models.TaskError
+}
+
+func (w *GenAIWorker) newOutput(task *models.GenerationTask) *taskOutput {
+	return &taskOutput{broker: w.broker, task: task, keep: len(w.onComplete) > 0, started: time.Now()}
+}
+
+func (o *taskOutput) chunk(text string) {
+	if o.keep {
+		o.text.WriteString(text)
+	}
+	o.broker.Publish(o.task.TaskID, models.TaskResult{Text: text})
+}
+
+func (o *taskOutput) fail(code string, err error) {
+	o.err = &models.TaskError{Code: code, Message: err.Error()}
+	o.broker.Publish(o.task.TaskID, models.TaskResult{Err: o.err})
+}
+
+// finish publishes the task's final result and notifies completion observers,
+// for the task and for every task that shared its generation. Each of those
+// is audited, but the upstream calls are charged once, to the task that made
+// them.
+func (w *GenAIWorker) finish(o *taskOutput, outcome string, meta *models.TaskMetadata, charges []model.Charge) {
+	members := o.broker.PublishFinal(o.task.TaskID, models.TaskResult{Done: true, Metadata: meta})
+	if len(w.onComplete) == 0 {
+		return
+	}
+
+	c := Completion{
+		Task:     o.task,
+		Text:     o.text.String(),
+		Err:      o.err,
+		Metadata: meta,
+		Charges:  charges,
+		Outcome:  outcome,
+		Started:  o.started,
+		Finished: time.Now(),
+	}
+	for _, task := range append([]*models.GenerationTask{o.task}, members...) {
+		c.Task = task
+		for _, fn := range w.onComplete {
+			fn(c)
+		}
+		c.Charges = nil
+	}
+}
@@ -0,0 +1,115 @@
+package worker
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/sokinpui/synapse.go/internal/broker"
+	"github.com/sokinpui/synapse.go/internal/config"
+	"github.com/sokinpui/synapse.go/internal/models"
+	"github.com/sokinpui/synapse.go/model"
+)
+
+func init() {
+	model.RegisterProvider("test", func(cfg *config.Config) (map[string]model.LLM, error) {
+		gemini, err := model.NewGeminiModel(context.Background(), "gemini-test",
+			model.NewKeyBalancer([]string{"k"}), model.NewRateLimiter("gemini", nil))
+		if err != nil {
+			return nil, err
+		}
+		return map[string]model.LLM{"gemini-test": gemini}, nil
+	})
+}
+
+// slowGemini serves Gemini generations of "four" after a delay, reporting
+// 3 input and 1 output tokens, and counts the calls it gets.
+func slowGemini(t *testing.T) *atomic.Int32 {
+	t.Helper()
+	var calls atomic.Int32
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		time.Sleep(50 * time.Millisecond)
+		resp := `{"candidates": [{"content": {"role": "model", "parts": [{"text": "four"}]}}],` +
+			` "usageMetadata": {"promptTokenCount": 3, "candidatesTokenCount": 1}}`
+		if strings.Contains(r.URL.Path, "stream") {
+			w.Header().Set("Content-Type", "text/event-stream")
+			fmt.Fprintf(w, "data: %s\n\n", resp)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		io.WriteString(w, resp)
+	}))
+	t.Cleanup(upstream.Close)
+	t.Setenv("GOOGLE_GEMINI_BASE_URL", upstream.URL)
+	return &calls
+}
+
+func TestCoalescedTasksAreCompleted(t *testing.T) {
+	calls := slowGemini(t)
+	registry, err := model.New(&config.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := broker.NewMemoryBroker(10)
+	w := New(b, registry, 1, config.WorkerConfig{})
+	var (
+		mu          sync.Mutex
+		completions = map[string]Completion{}
+	)
+	w.OnComplete(func(c Completion) {
+		mu.Lock()
+		defer mu.Unlock()
+		completions[c.Task.TaskID] = c
+	})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go w.Run(ctx)
+
+	zero := float32(0)
+	for _, id := range []string{"leader", "member"} {
+		b.Subscribe(id)
+		task := &models.GenerationTask{TaskID: id, Tenant: "acme", ModelCode: "gemini-test", Prompt: "2+2?",
+			Config: &model.Config{Temperature: &zero}}
+		if err := b.Enqueue(task); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	waitFor(t, func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return len(completions) == 2
+	})
+	for _, id := range []string{"leader", "member"} {
+		c := completions[id]
+		if c.Text != "four" || c.Outcome != "ok" || c.Task.Tenant != "acme" {
+			t.Errorf("completion of %s = %+v, want the shared answer", id, c)
+		}
+	}
+
+	// The ledger bills what the completions charge: one call, once.
+	if n := calls.Load(); n != 1 {
+		t.Fatalf("%d upstream calls, want 1", n)
+	}
+	var billed model.Usage
+	for _, c := range completions {
+		for _, ch := range c.Charges {
+			billed.InputTokens += ch.Usage.InputTokens
+			billed.OutputTokens += ch.Usage.OutputTokens
+		}
+	}
+	if want := (model.Usage{InputTokens: 3, OutputTokens: 1}); billed != want {
+		t.Errorf("charged usage = %+v, want %+v for the one call", billed, want)
+	}
+	if len(completions["member"].Charges) != 0 {
+		t.Errorf("member charges = %+v, want none", completions["member"].Charges)
+	}
+}
@@ -0,0 +1,177 @@
+package worker
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"github.com/sokinpui/synapse.go/model"
+)
+
+// hedgedLLM races a second attempt against the primary one when the primary
+// has not produced anything within delay. The hedge goes to a sibling model
+// if one is configured and otherwise to the same model, whose key balancer
+// hands it the next API key. The first attempt to respond wins and the other
+// is canceled.
+type hedgedLLM struct {
+	model.LLM
+	hedge     model.LLM
+	modelCode string
+	delay     time.Duration
+}
+
+// hedgeAttempt is one of the two racing calls.
+type hedgeAttempt struct {
+	name   string
+	chunks <-chan string
+	errs   <-chan error
+	cancel context.CancelFunc
+}
+
+// firstEvent is how an attempt's stream started: with a chunk, or by ending
+// before producing one.
+type firstEvent struct {
+	attempt *hedgeAttempt
+	chunk   string
+	ok      bool
+	err     error
+}
+
+func (h *hedgedLLM) Generate(ctx context.Context, prompt string, images [][]byte, config *model.Config) (string, error) {
+	type result struct {
+		text string
+		err  error
+	}
+
+	results := make(chan result, 2)
+	attemptCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	call := func(llm model.LLM) {
+		text, err := llm.Generate(attemptCtx, prompt, images, config)
+		results <- result{text: text, err: err}
+	}
+	go call(h.LLM)
+
+	timer := time.NewTimer(h.delay)
+	defer timer.Stop()
+	pending := 1
+
+	for {
+		select {
+		case <-timer.C:
+			slog.InfoContext(ctx, "No response yet, sending hedged request", "model", h.modelCode, "delay", h.delay)
+			go call(h.hedge)
+			pending++
+		case res := <-results:
+			pending--
+			if res.err == nil || pending == 0 {
+				return res.text, res.err
+			}
+		case <-ctx.Done():
+			return "", ctx.Err()
+		}
+	}
+}
+
+func (h *hedgedLLM) GenerateStream(ctx context.Context, prompt string, images [][]byte, config *model.Config) (<-chan string, <-chan error) {
+	outCh := make(chan string)
+	errCh := make(chan error, 1)
+
+	go func() {
+		defer close(outCh)
+		defer close(errCh)
+
+		firsts := make(chan firstEvent, 2)
+		start := func(name string, llm model.LLM) *hedgeAttempt {
+			attemptCtx, cancel := context.WithCancel(ctx)
+			chunks, errs := llm.GenerateStream(attemptCtx, prompt, images, config)
+			a := &hedgeAttempt{name: name, chunks: chunks, errs: errs, cancel: cancel}
+			go a.awaitFirst(firsts)
+			return a
+		}
+
+		attempts := []*hedgeAttempt{start("primary", h.LLM)}
+		defer func() {
+			for _, a := range attempts {
+				a.cancel()
+			}
+		}()
+
+		timer := time.NewTimer(h.delay)
+		defer timer.Stop()
+		pending := 1
+
+		for {
+			select {
+			case <-timer.C:
+				slog.InfoContext(ctx, "No first token yet, sending hedged request", "model", h.modelCode, "delay", h.delay)
+				attempts = append(attempts, start("hedge", h.hedge))
+				pending++
+			case ev := <-firsts:
+				pending--
+				if !ev.ok && ev.err != nil && pending > 0 {
+					continue
+				}
+				for _, a := range attempts {
+					if a != ev.attempt {
+						a.cancel()
+						go model.Drain(a.chunks)
+					}
+				}
+				if !ev.ok {
+					if ev.err != nil {
+						errCh <- ev.err
+					}
+					return
+				}
+				if len(attempts) > 1 {
+					slog.InfoContext(ctx, "Hedged request won", "model", h.modelCode, "winner", ev.attempt.name)
+				}
+				if err := h.forward(ctx, outCh, ev); err != nil {
+					errCh <- err
+				}
+				return
+			case <-ctx.Done():
+				errCh <- ctx.Err()
+				return
+			}
+		}
+	}()
+
+	return outCh, errCh
+}
+
+// forward streams the winning attempt, starting with its first chunk.
+func (h *hedgedLLM) forward(ctx context.Context, out chan<- string, ev firstEvent) error {
+	chunk, chunks, errs := ev.chunk, ev.attempt.chunks, ev.attempt.errs
+	for {
+		select {
+		case out <- chunk:
+		case <-ctx.Done():
+			go model.Drain(chunks)
+			return ctx.Err()
+		}
+
+		var ok bool
+		select {
+		case chunk, ok = <-chunks:
+			if !ok {
+				return <-errs
+			}
+		case <-ctx.Done():
+			go model.Drain(chunks)
+			return ctx.Err()
+		}
+	}
+}
+
+// awaitFirst reports how the attempt's stream started.
+func (a *hedgeAttempt) awaitFirst(firsts chan<- firstEvent) {
+	chunk, ok := <-a.chunks
+	if ok {
+		firsts <- firstEvent{attempt: a, chunk: chunk, ok: true}
+		return
+	}
+	firsts <- firstEvent{attempt: a, err: <-a.errs}
+}
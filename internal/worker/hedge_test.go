@@ -0,0 +1,137 @@
+package worker
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/sokinpui/synapse.go/model"
+)
+
+// slowLLM answers with text after delay, or fails with err. It records how
+// often it was called and whether its calls were canceled.
+type slowLLM struct {
+	delay    time.Duration
+	text     string
+	err      error
+	calls    atomic.Int32
+	canceled atomic.Int32
+}
+
+func (s *slowLLM) wait(ctx context.Context) error {
+	s.calls.Add(1)
+	select {
+	case <-time.After(s.delay):
+		return s.err
+	case <-ctx.Done():
+		s.canceled.Add(1)
+		return ctx.Err()
+	}
+}
+
+func (s *slowLLM) Generate(ctx context.Context, prompt string, images [][]byte, config *model.Config) (string, error) {
+	if err := s.wait(ctx); err != nil {
+		return "", err
+	}
+	return s.text, nil
+}
+
+func (s *slowLLM) GenerateStream(ctx context.Context, prompt string, images [][]byte, config *model.Config) (<-chan string, <-chan error) {
+	outCh := make(chan string)
+	errCh := make(chan error, 1)
+	go func() {
+		defer close(outCh)
+		defer close(errCh)
+		if err := s.wait(ctx); err != nil {
+			errCh <- err
+			return
+		}
+		for _, word := range strings.Fields(s.text) {
+			select {
+			case outCh <- word:
+			case <-ctx.Done():
+				errCh <- ctx.Err()
+				return
+			}
+		}
+	}()
+	return outCh, errCh
+}
+
+func (s *slowLLM) CountTokens(prompt string) (int, error) { return 0, nil }
+
+func hedgeOf(primary, hedge model.LLM) *hedgedLLM {
+	return &hedgedLLM{LLM: primary, hedge: hedge, modelCode: "m", delay: 10 * time.Millisecond}
+}
+
+func TestHedgeSkippedWhenPrimaryIsFast(t *testing.T) {
+	primary := &slowLLM{text: "primary"}
+	hedge := &slowLLM{text: "hedge"}
+
+	text, err := hedgeOf(primary, hedge).Generate(context.Background(), "hi", nil, nil)
+	if err != nil || text != "primary" {
+		t.Fatalf("Generate = %q, %v; want primary", text, err)
+	}
+	time.Sleep(20 * time.Millisecond)
+	if n := hedge.calls.Load(); n != 0 {
+		t.Errorf("hedge called %d times, want 0", n)
+	}
+}
+
+func TestHedgeWinsAndCancelsPrimary(t *testing.T) {
+	primary := &slowLLM{delay: time.Second, text: "primary"}
+	hedge := &slowLLM{text: "hedge"}
+
+	text, err := hedgeOf(primary, hedge).Generate(context.Background(), "hi", nil, nil)
+	if err != nil || text != "hedge" {
+		t.Fatalf("Generate = %q, %v; want hedge", text, err)
+	}
+	waitFor(t, func() bool { return primary.canceled.Load() == 1 })
+}
+
+func TestHedgeReturnsErrorWhenBothFail(t *testing.T) {
+	primaryErr := errors.New("primary failed")
+	primary := &slowLLM{delay: 20 * time.Millisecond, err: primaryErr}
+	hedge := &slowLLM{delay: 30 * time.Millisecond, err: errors.New("hedge failed")}
+
+	_, err := hedgeOf(primary, hedge).Generate(context.Background(), "hi", nil, nil)
+	if err == nil {
+		t.Fatal("Generate succeeded, want an error")
+	}
+	if hedge.calls.Load() != 1 {
+		t.Errorf("hedge called %d times, want 1", hedge.calls.Load())
+	}
+}
+
+func TestHedgeStreamForwardsWinner(t *testing.T) {
+	primary := &slowLLM{delay: time.Second, text: "slow primary"}
+	hedge := &slowLLM{text: "fast hedge answer"}
+
+	chunks, errs := hedgeOf(primary, hedge).GenerateStream(context.Background(), "hi", nil, nil)
+	var got []string
+	for c := range chunks {
+		got = append(got, c)
+	}
+	if err := <-errs; err != nil {
+		t.Fatalf("stream failed: %v", err)
+	}
+	if strings.Join(got, " ") != "fast hedge answer" {
+		t.Errorf("got %q, want the hedge's chunks", got)
+	}
+	waitFor(t, func() bool { return primary.canceled.Load() == 1 })
+}
+
+// waitFor fails the test if cond does not become true within a second.
+func waitFor(t *testing.T, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("condition not met in time")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
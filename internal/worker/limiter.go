@@ -0,0 +1,145 @@
+package worker
+
+import (
+	"context"
+	"sync"
+
+	"github.com/sokinpui/synapse.go/internal/config"
+	"github.com/sokinpui/synapse.go/internal/metrics"
+	"github.com/sokinpui/synapse.go/internal/models"
+)
+
+// inFlightLimiter caps how many tasks run at once per model and per provider.
+// Tasks that cannot start are parked rather than holding a worker goroutine,
+// and are handed back out as running tasks release their slots. Workers stop
+// taking tasks from the broker while maxParked tasks are parked, so that the
+// backlog stays in the broker's queue, where it counts towards readiness and
+// holds up Enqueue once the queue is full.
+type inFlightLimiter struct {
+	modelLimits    map[string]int
+	providerLimits map[string]int
+	providerOf     func(modelCode string) string
+	maxParked      int
+
+	mu              sync.Mutex
+	modelRunning    map[string]int
+	providerRunning map[string]int
+	parked          []*models.GenerationTask
+	room            chan struct{} // closed once parked falls below maxParked
+}
+
+func newInFlightLimiter(limits config.InFlightLimits, maxParked int, providerOf func(string) string) *inFlightLimiter {
+	return &inFlightLimiter{
+		modelLimits:     limits.Models,
+		providerLimits:  limits.Providers,
+		providerOf:      providerOf,
+		maxParked:       max(maxParked, 1),
+		modelRunning:    make(map[string]int),
+		providerRunning: make(map[string]int),
+	}
+}
+
+// waitForRoom blocks while maxParked tasks are parked, and reports false if
+// ctx or stop ends the wait first. Workers that pass it at once may each
+// park one more task.
+func (l *inFlightLimiter) waitForRoom(ctx context.Context, stop <-chan struct{}) bool {
+	l.mu.Lock()
+	if len(l.parked) < l.maxParked {
+		l.mu.Unlock()
+		return true
+	}
+	if l.room == nil {
+		l.room = make(chan struct{})
+	}
+	room := l.room
+	l.mu.Unlock()
+
+	select {
+	case <-room:
+		return true
+	case <-stop:
+		return false
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// parkedChanged updates the parked gauge and wakes workers waiting for
+// room. l.mu must be held.
+func (l *inFlightLimiter) parkedChanged() {
+	metrics.ParkedTasks.Set(float64(len(l.parked)))
+	if l.room != nil && len(l.parked) < l.maxParked {
+		close(l.room)
+		l.room = nil
+	}
+}
+
+// admit reserves slots for task and reports whether it may run now.
+// If it may not, the task is parked until release hands it out.
+func (l *inFlightLimiter) admit(task *models.GenerationTask) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.tryAcquire(task) {
+		return true
+	}
+	l.parked = append(l.parked, task)
+	l.parkedChanged()
+	return false
+}
+
+// release frees the slots held by task and returns the oldest parked task
+// that can now run, with its slots already reserved, or nil if there is none.
+func (l *inFlightLimiter) release(task *models.GenerationTask) *models.GenerationTask {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.modelRunning[task.ModelCode]--
+	if provider := l.provider(task.ModelCode); provider != "" {
+		l.providerRunning[provider]--
+	}
+
+	for i, next := range l.parked {
+		if l.tryAcquire(next) {
+			l.parked = append(l.parked[:i], l.parked[i+1:]...)
+			l.parkedChanged()
+			return next
+		}
+	}
+	return nil
+}
+
+// takeParked removes and returns all parked tasks.
+func (l *inFlightLimiter) takeParked() []*models.GenerationTask {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	parked := l.parked
+	l.parked = nil
+	l.parkedChanged()
+	return parked
+}
+
+func (l *inFlightLimiter) tryAcquire(task *models.GenerationTask) bool {
+	provider := l.provider(task.ModelCode)
+
+	if limit := l.modelLimits[task.ModelCode]; limit > 0 && l.modelRunning[task.ModelCode] >= limit {
+		return false
+	}
+	if limit := l.providerLimits[provider]; provider != "" && limit > 0 && l.providerRunning[provider] >= limit {
+		return false
+	}
+
+	l.modelRunning[task.ModelCode]++
+	if provider != "" {
+		l.providerRunning[provider]++
+	}
+	return true
+}
+
+func (l *inFlightLimiter) provider(modelCode string) string {
+	if l.providerOf == nil {
+		return ""
+	}
+	return l.providerOf(modelCode)
+}
@@ -0,0 +1,112 @@
+package worker
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/sokinpui/synapse.go/internal/config"
+	"github.com/sokinpui/synapse.go/internal/models"
+)
+
+func taskFor(id, modelCode string) *models.GenerationTask {
+	return &models.GenerationTask{TaskID: id, ModelCode: modelCode}
+}
+
+func testLimiter(maxParked int) *inFlightLimiter {
+	limits := config.InFlightLimits{
+		Models:    map[string]int{"a1": 1},
+		Providers: map[string]int{"p": 2},
+	}
+	providers := map[string]string{"a1": "p", "a2": "p", "b": "q"}
+	return newInFlightLimiter(limits, maxParked, func(m string) string { return providers[m] })
+}
+
+func TestLimiterAdmitsWithinLimits(t *testing.T) {
+	tests := []struct {
+		name    string
+		running []string // models of the tasks already admitted
+		next    string
+		want    bool
+	}{
+		{"idle", nil, "a1", true},
+		{"model limit", []string{"a1"}, "a1", false},
+		{"sibling within provider limit", []string{"a1"}, "a2", true},
+		{"provider limit", []string{"a1", "a2"}, "a2", false},
+		{"other provider", []string{"a1", "a2"}, "b", true},
+		{"unlimited model", []string{"b", "b", "b"}, "b", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l := testLimiter(10)
+			for i, m := range tt.running {
+				if !l.admit(taskFor(string(rune('0'+i)), m)) {
+					t.Fatalf("setup task %d for %s was parked", i, m)
+				}
+			}
+			if got := l.admit(taskFor("next", tt.next)); got != tt.want {
+				t.Errorf("admit(%s) = %v, want %v", tt.next, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLimiterReleaseHandsOutOldestRunnable(t *testing.T) {
+	l := testLimiter(10)
+	first, other := taskFor("first", "a1"), taskFor("other", "b")
+	l.admit(first)
+	l.admit(other)
+	l.admit(taskFor("sibling", "a2"))
+	l.admit(taskFor("parked-a1", "a1")) // model and provider full
+	l.admit(taskFor("parked-a2", "a2")) // provider full
+
+	// Releasing a1 frees a slot of both the model and the provider, and the
+	// oldest parked task takes it.
+	if next := l.release(first); next == nil || next.TaskID != "parked-a1" {
+		t.Fatalf("release handed out %v, want parked-a1", next)
+	}
+	// The provider is full again, so nothing else can run yet.
+	if next := l.release(other); next != nil {
+		t.Errorf("release of another provider's task handed out %s", next.TaskID)
+	}
+
+	parked := l.takeParked()
+	if len(parked) != 1 || parked[0].TaskID != "parked-a2" {
+		t.Errorf("takeParked = %v, want parked-a2", parked)
+	}
+	if len(l.takeParked()) != 0 {
+		t.Error("takeParked left tasks parked")
+	}
+}
+
+func TestLimiterWaitsForRoomToPark(t *testing.T) {
+	l := testLimiter(1)
+	running := taskFor("running", "a1")
+	l.admit(running)
+	l.admit(taskFor("parked", "a1"))
+
+	stop := make(chan struct{})
+	room := make(chan bool)
+	go func() { room <- l.waitForRoom(context.Background(), stop) }()
+	select {
+	case <-room:
+		t.Fatal("waitForRoom returned while the parked tasks are at the cap")
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	l.release(running) // hands out the parked task
+	select {
+	case ok := <-room:
+		if !ok {
+			t.Error("waitForRoom = false, want true once a parked task left")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("waitForRoom still blocked after the parked task left")
+	}
+
+	l.admit(taskFor("parked again", "a1"))
+	close(stop)
+	if l.waitForRoom(context.Background(), stop) {
+		t.Error("waitForRoom = true after stop, want false")
+	}
+}
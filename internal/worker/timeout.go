@@ -0,0 +1,44 @@
+package worker
+
+import (
+	"time"
+
+	"github.com/sokinpui/synapse.go/internal/models"
+	"github.com/sokinpui/synapse.go/model"
+)
+
+// errTimeout is the cause of a task canceled for exceeding one of its
+// timeouts. It is the model's own, so that the circuit breaker counts a hang
+// cut short by a timeout as an upstream failure.
+var errTimeout = model.ErrTimeout
+
+// taskTimeouts are the limits enforced on a single task. Zero means none.
+type taskTimeouts struct {
+	request    time.Duration
+	firstToken time.Duration
+	idle       time.Duration
+}
+
+// timeoutsFor returns the configured default timeouts, overridden by any the
+// task sets itself.
+func (w *GenAIWorker) timeoutsFor(task *models.GenerationTask) taskTimeouts {
+	t := taskTimeouts{
+		request:    w.timeouts.Request,
+		firstToken: w.timeouts.FirstToken,
+		idle:       w.timeouts.Idle,
+	}
+	if task.Timeout > 0 {
+		t.request = seconds(task.Timeout)
+	}
+	if task.FirstTokenTimeout > 0 {
+		t.firstToken = seconds(task.FirstTokenTimeout)
+	}
+	if task.IdleTimeout > 0 {
+		t.idle = seconds(task.IdleTimeout)
+	}
+	return t
+}
+
+func seconds(s float64) time.Duration {
+	return time.Duration(s * float64(time.Second))
+}
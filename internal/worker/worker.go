@@ -2,52 +2,115 @@ package worker
 
 import (
 	"context"
+	"errors"
 	"fmt"
-	"log"
+	"log/slog"
 	"os"
 	"sync"
+	"time"
 
 	"github.com/sokinpui/synapse.go/internal/broker"
-	"github.com/sokinpui/synapse.go/internal/color"
+	"github.com/sokinpui/synapse.go/internal/config"
+	"github.com/sokinpui/synapse.go/internal/logging"
+	"github.com/sokinpui/synapse.go/internal/metrics"
 	"github.com/sokinpui/synapse.go/internal/models"
+	"github.com/sokinpui/synapse.go/internal/tracing"
 	"github.com/sokinpui/synapse.go/model"
+	"go.opentelemetry.io/otel/attribute"
 )
 
-const sentinel = "[DONE]"
-
 // GenAIWorker dequeues and processes generation tasks.
 type GenAIWorker struct {
 	workerID    string
 	broker      *broker.MemoryBroker
 	llmRegistry *model.Registry
 	concurrency int
+	limiter     *inFlightLimiter
+	timeouts    config.Timeouts
+	hedging     config.Hedging
+
+	stopping chan struct{} // closed when Drain starts
+	stopOnce sync.Once
+	abort    context.CancelCauseFunc // aborts tasks still running after the drain deadline
+	aborted  context.Context
+	done     chan struct{} // closed when Run returns
+
+	onComplete []func(Completion)
 }
 
-func New(b *broker.MemoryBroker, llmRegistry *model.Registry, concurrency int) *GenAIWorker {
+// errShutdown is the cause of tasks aborted or rejected by a drain.
+var errShutdown = errors.New("server is shutting down")
+
+func New(b *broker.MemoryBroker, llmRegistry *model.Registry, concurrency int, cfg config.WorkerConfig) *GenAIWorker {
+	providerOf := func(string) string { return "" }
+	if llmRegistry != nil {
+		providerOf = llmRegistry.ProviderOf
+	}
+
+	aborted, abort := context.WithCancelCause(context.Background())
+
 	return &GenAIWorker{
 		workerID:    fmt.Sprintf("GenAIWorker-%d", os.Getpid()),
 		broker:      b,
 		llmRegistry: llmRegistry,
 		concurrency: concurrency,
+		limiter:     newInFlightLimiter(cfg.MaxInFlight, concurrency, providerOf),
+		timeouts:    cfg.Timeouts,
+		hedging:     cfg.Hedging,
+		stopping:    make(chan struct{}),
+		abort:       abort,
+		aborted:     aborted,
+		done:        make(chan struct{}),
 	}
 }
 
+// Run processes tasks until ctx is canceled, which aborts tasks in flight,
+// or until Drain has let them finish.
 func (w *GenAIWorker) Run(ctx context.Context) {
-	log.Printf("%s started. Waiting for tasks... (concurrency: %d)", w.workerID, w.concurrency)
+	defer close(w.done)
+	slog.Info("Worker started, waiting for tasks", "worker", w.workerID, "concurrency", w.concurrency)
+
+	ctx, cancel := context.WithCancelCause(ctx)
+	defer cancel(nil)
+	stopAbort := context.AfterFunc(w.aborted, func() { cancel(context.Cause(w.aborted)) })
+	defer stopAbort()
 
 	taskCh := w.broker.Dequeue()
+	backgroundCh := w.broker.DequeueBackground()
 	var wg sync.WaitGroup
 	for i := 0; i < w.concurrency; i++ {
 		wg.Add(1)
 		go func() {
 			defer wg.Done()
 			for {
+				select {
+				case <-w.stopping:
+					return
+				default:
+				}
+				if !w.limiter.waitForRoom(ctx, w.stopping) {
+					return
+				}
+
+				// Interactive tasks go first; background ones only fill
+				// otherwise idle workers.
+				select {
+				case task := <-taskCh:
+					w.run(ctx, task)
+					continue
+				default:
+				}
+
 				select {
 				case task, ok := <-taskCh:
 					if !ok {
 						return
 					}
-					w.processTask(ctx, task)
+					w.run(ctx, task)
+				case task := <-backgroundCh:
+					w.run(ctx, task)
+				case <-w.stopping:
+					return
 				case <-ctx.Done():
 					return
 				}
@@ -56,47 +119,229 @@ func (w *GenAIWorker) Run(ctx context.Context) {
 	}
 
 	wg.Wait()
-	log.Printf("%s all workers stopped.", w.workerID)
+	slog.Info("All workers stopped", "worker", w.workerID)
+}
+
+// Drain stops taking new tasks and waits for tasks in flight to finish.
+// Tasks still running when ctx is done are aborted. Tasks that never
+// started, whether queued in the broker or parked by the in-flight limiter,
+// are rejected with an error. The broker should stop accepting tasks before
+// Drain is called.
+func (w *GenAIWorker) Drain(ctx context.Context) error {
+	w.stopOnce.Do(func() { close(w.stopping) })
+
+	var err error
+	select {
+	case <-w.done:
+	case <-ctx.Done():
+		err = ctx.Err()
+		slog.Warn("Drain deadline reached, aborting tasks in flight", "worker", w.workerID)
+		w.abort(errShutdown)
+		<-w.done
+	}
+
+	rejected := 0
+	for _, task := range append(w.limiter.takeParked(), w.broker.TakeQueued()...) {
+		w.reject(task)
+		rejected++
+	}
+	if rejected > 0 {
+		slog.Warn("Rejected queued tasks", "worker", w.workerID, "count", rejected)
+	}
+	return err
+}
+
+func (w *GenAIWorker) isStopping() bool {
+	select {
+	case <-w.stopping:
+		return true
+	default:
+		return false
+	}
+}
+
+// reject fails a task that will not be processed because of a drain.
+func (w *GenAIWorker) reject(task *models.GenerationTask) {
+	out := w.newOutput(task)
+	out.fail(models.ErrCodeShuttingDown, fmt.Errorf("%w, task was not started", errShutdown))
+	w.finish(out, "rejected", &models.TaskMetadata{}, nil)
+}
+
+// run processes task once the in-flight limiter admits it. A task for a
+// saturated model or provider is parked instead, leaving this goroutine free
+// to serve other models; it is picked up by whichever goroutine frees a slot.
+func (w *GenAIWorker) run(ctx context.Context, task *models.GenerationTask) {
+	if !w.limiter.admit(task) {
+		slog.InfoContext(taskLogContext(ctx, task), "Task parked: in-flight limit reached")
+		return
+	}
+
+	for task != nil {
+		if w.isStopping() {
+			w.reject(task)
+		} else {
+			if !task.EnqueuedAt.IsZero() {
+				metrics.QueueWait.Observe(time.Since(task.EnqueuedAt).Seconds())
+			}
+			w.processTask(ctx, task)
+		}
+		task = w.limiter.release(task)
+	}
 }
 
 func (w *GenAIWorker) processTask(ctx context.Context, task *models.GenerationTask) {
-	log.Printf("-> %s task: %s [%s]", color.YellowString("Processing"), task.TaskID, task.ModelCode)
-	defer log.Printf("<- %s task: %s [%s]", color.GreenString("Finished"), task.TaskID, task.ModelCode)
+	ctx = taskLogContext(ctx, task)
+	if !w.broker.Started(task.TaskID) {
+		slog.InfoContext(ctx, "Task canceled before it started")
+		metrics.Cancellations.WithLabelValues(task.ModelCode).Inc()
+		w.finish(w.newOutput(task), "canceled", &models.TaskMetadata{}, nil)
+		return
+	}
+	slog.InfoContext(ctx, "Processing task", logging.Prompt(task.Prompt))
+	defer slog.InfoContext(ctx, "Finished task")
 
-	taskCtx, cancelTask := context.WithCancel(ctx)
-	defer cancelTask()
+	start := time.Now()
+	metrics.WorkersBusy.Inc()
+	defer metrics.WorkersBusy.Dec()
 
-	go w.listenForCancellation(taskCtx, task.TaskID, cancelTask)
+	ctx = tracing.WithTaskID(tracing.Extract(ctx, task.TraceContext), task.TaskID)
+	if !task.EnqueuedAt.IsZero() {
+		tracing.Record(ctx, "broker.queue", task.EnqueuedAt)
+	}
+	ctx, span := tracing.Start(ctx, "worker.process",
+		attribute.String("model", task.ModelCode),
+		attribute.Bool("stream", task.Stream),
+		attribute.Bool("hedge", task.Hedge))
 
-	resultChannel := task.TaskID
+	timeouts := w.timeoutsFor(task)
+	taskCtx, cancelTask := context.WithCancelCause(ctx)
+	defer cancelTask(nil)
+	if timeouts.request > 0 {
+		var cancelDeadline context.CancelFunc
+		taskCtx, cancelDeadline = context.WithTimeoutCause(taskCtx, timeouts.request,
+			fmt.Errorf("%w: no result within %s", errTimeout, timeouts.request))
+		defer cancelDeadline()
+	}
+
+	go w.listenForCancellation(taskCtx, task.TaskID, func() { cancelTask(context.Canceled) })
+
+	taskCtx, report := model.WithReport(taskCtx)
+	out := w.newOutput(task)
+	outcome := "ok"
 
 	defer func() {
-		w.broker.Publish(resultChannel, sentinel)
+		w.finish(out, outcome, &models.TaskMetadata{
+			Attempts: report.Attempts(),
+			Usage:    usageMetadata(report.Usage()),
+		}, report.Charges())
 	}()
 
-	model, err := w.llmRegistry.GetModel(task.ModelCode)
+	llm, err := w.llmRegistry.GetModel(task.ModelCode)
 	if err != nil {
-		log.Printf("Error getting model for task %s: %v", task.TaskID, err)
-		errMsg := fmt.Sprintf("Error: %v", err)
-		w.broker.Publish(resultChannel, errMsg)
+		outcome = "error"
+		slog.WarnContext(ctx, "Error getting model", "error", err)
+		tracing.End(span, err)
+		out.fail(models.ErrCodeModelNotFound, err)
 		return
 	}
 
+	defer func() {
+		span.SetAttributes(attribute.String("outcome", outcome), attribute.Int("attempts", report.Attempts()))
+		tracing.End(span, err)
+
+		usage := report.Usage()
+		metrics.GenerationDuration.WithLabelValues(task.ModelCode, outcome).Observe(time.Since(start).Seconds())
+		metrics.Tokens.WithLabelValues(task.ModelCode, "input").Add(float64(usage.InputTokens))
+		metrics.Tokens.WithLabelValues(task.ModelCode, "output").Add(float64(usage.OutputTokens))
+	}()
+
+	if task.Hedge {
+		llm = w.hedged(task.ModelCode, llm)
+	}
+
 	if task.Stream {
-		err = w.processStream(taskCtx, task, model)
+		err = w.processStream(taskCtx, cancelTask, task, llm, out, timeouts, start)
 	} else {
-		err = w.process(taskCtx, task, model)
+		err = w.process(taskCtx, task, llm, out, start)
+	}
+
+	if cause := context.Cause(taskCtx); errors.Is(cause, errTimeout) || errors.Is(cause, errShutdown) {
+		err = cause
 	}
 
 	if err != nil {
-		if err == context.Canceled {
-			log.Printf("Task %s was canceled.", task.TaskID)
+		if errors.Is(err, errShutdown) {
+			outcome = "shutdown"
+			slog.WarnContext(ctx, "Task aborted by shutdown")
+			out.fail(models.ErrCodeShuttingDown, err)
+			return
+		}
+		if errors.Is(err, errTimeout) {
+			outcome = "timeout"
+			slog.WarnContext(ctx, "Task timed out", "error", err)
+			out.fail(models.ErrCodeTimeout, err)
+			return
+		}
+		if errors.Is(err, context.Canceled) {
+			outcome = "canceled"
+			metrics.Cancellations.WithLabelValues(task.ModelCode).Inc()
+			slog.InfoContext(ctx, "Task was canceled")
 			return
 		}
-		log.Printf("Error processing generation task %s: %v", task.TaskID, err)
-		errMsg := fmt.Sprintf("Error: %v", err)
-		w.broker.Publish(resultChannel, errMsg)
+		outcome = "error"
+		slog.ErrorContext(ctx, "Error processing generation task", "error", err)
+		code := models.ErrCodeGeneration
+		if errors.Is(err, model.ErrCircuitOpen) {
+			code = models.ErrCodeCircuitOpen
+		}
+		out.fail(code, err)
+	}
+}
+
+// taskLogContext returns ctx with the task's identifying attributes attached
+// for logging.
+func taskLogContext(ctx context.Context, task *models.GenerationTask) context.Context {
+	attrs := []slog.Attr{slog.String("task_id", task.TaskID), slog.String("model", task.ModelCode)}
+	if task.Tenant != "" {
+		attrs = append(attrs, slog.String("tenant", task.Tenant))
+	}
+	if task.RequestID != "" {
+		attrs = append(attrs, slog.String("request_id", task.RequestID))
+	}
+	return logging.With(ctx, attrs...)
+}
+
+// usageMetadata converts upstream usage for task results, or returns nil if
+// upstream reported none.
+func usageMetadata(u model.Usage) *models.Usage {
+	if u == (model.Usage{}) {
+		return nil
+	}
+	return &models.Usage{
+		PromptTokens:     u.InputTokens,
+		CompletionTokens: u.OutputTokens,
+		TotalTokens:      u.InputTokens + u.OutputTokens,
+	}
+}
+
+// hedged wraps llm so that a slow first attempt is raced by a second one.
+// It returns llm unchanged if hedging is not configured.
+func (w *GenAIWorker) hedged(modelCode string, llm model.LLM) model.LLM {
+	if w.hedging.Delay <= 0 {
+		return llm
+	}
+
+	hedge := llm
+	if sibling, ok := w.hedging.Siblings[modelCode]; ok {
+		siblingLLM, err := w.llmRegistry.GetModel(sibling)
+		if err != nil {
+			slog.Warn("Hedge sibling unavailable, hedging on the same model", "model", modelCode, "sibling", sibling, "error", err)
+		} else {
+			hedge = siblingLLM
+		}
 	}
+
+	return &hedgedLLM{LLM: llm, hedge: hedge, modelCode: modelCode, delay: w.hedging.Delay}
 }
 
 func (w *GenAIWorker) listenForCancellation(ctx context.Context, taskID string, cancel context.CancelFunc) {
@@ -108,26 +353,71 @@ func (w *GenAIWorker) listenForCancellation(ctx context.Context, taskID string,
 	}
 }
 
-func (w *GenAIWorker) process(ctx context.Context, task *models.GenerationTask, model model.LLM) error {
+func (w *GenAIWorker) process(ctx context.Context, task *models.GenerationTask, model model.LLM, out *taskOutput, start time.Time) error {
 	result, err := model.Generate(ctx, task.Prompt, task.Images, task.Config)
 	if err != nil {
 		return err
 	}
-	w.broker.Publish(task.TaskID, result)
+	metrics.TimeToFirstToken.WithLabelValues(task.ModelCode).Observe(time.Since(start).Seconds())
+	out.chunk(result)
 	return nil
 }
 
-func (w *GenAIWorker) processStream(ctx context.Context, task *models.GenerationTask, model model.LLM) error {
+// processStream publishes chunks as they arrive, canceling the task if the
+// first chunk or any later one takes longer than its timeout.
+func (w *GenAIWorker) processStream(ctx context.Context, cancel context.CancelCauseFunc, task *models.GenerationTask, model model.LLM, out *taskOutput, timeouts taskTimeouts, start time.Time) error {
 	outCh, errCh := model.GenerateStream(ctx, task.Prompt, task.Images, task.Config)
 
+	var timer *time.Timer
+	var timeout <-chan time.Time
+	if timeouts.firstToken > 0 {
+		timer = time.NewTimer(timeouts.firstToken)
+		timeout = timer.C
+	}
+	defer func() {
+		if timer != nil {
+			timer.Stop()
+		}
+	}()
+	first := true
+
 	for {
 		select {
 		case chunk, ok := <-outCh:
 			if !ok {
-				return nil // Stream finished
+				// Stream finished; it may have ended on an error.
+				if errCh == nil {
+					return nil
+				}
+				return <-errCh
+			}
+			if first {
+				metrics.TimeToFirstToken.WithLabelValues(task.ModelCode).Observe(time.Since(start).Seconds())
+				first = false
+			}
+			out.chunk(chunk)
+
+			timeout = nil
+			if timeouts.idle > 0 {
+				if timer == nil {
+					timer = time.NewTimer(timeouts.idle)
+				} else {
+					timer.Reset(timeouts.idle)
+				}
+				timeout = timer.C
+			}
+		case err, ok := <-errCh:
+			if !ok {
+				errCh = nil
+				continue
+			}
+			return err
+		case <-timeout:
+			err := fmt.Errorf("%w: stream idle for %s", errTimeout, timeouts.idle)
+			if first {
+				err = fmt.Errorf("%w: no first token within %s", errTimeout, timeouts.firstToken)
 			}
-			w.broker.Publish(task.TaskID, chunk)
-		case err := <-errCh:
+			cancel(err)
 			return err
 		case <-ctx.Done():
 			return ctx.Err()
@@ -1,12 +1,29 @@
 package model
 
-import "sync"
+import (
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sokinpui/synapse.go/internal/config"
+)
+
+// maxKeyFailures is the number of consecutive failures after which a key is
+// no longer counted as usable. keyCooldown later it counts as usable again,
+// until its next call settles whether it has recovered.
+const (
+	maxKeyFailures = 3
+	keyCooldown    = time.Minute
+)
 
 // api key table
-// index | key | used
+// index | key | used | consecutive failures | time of the last failure
 type apiKeyState struct {
-	Value string
-	Used  bool
+	Value    string
+	Used     bool
+	Failures int
+	FailedAt time.Time
 }
 
 type KeyBalancer struct {
@@ -54,6 +71,62 @@ func (b *KeyBalancer) KeyCount() int {
 	return len(b.keys)
 }
 
+// ReportSuccess records a successful call made with the key at idx.
+func (b *KeyBalancer) ReportSuccess(idx int) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if idx >= 0 && idx < len(b.keys) {
+		b.keys[idx].Failures = 0
+	}
+}
+
+// ReportFailure records a call made with the key at idx that failed with
+// err. Only errors that reflect on the key count: see keyFault.
+func (b *KeyBalancer) ReportFailure(idx int, err error) {
+	if !keyFault(err) {
+		return
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if idx >= 0 && idx < len(b.keys) {
+		b.keys[idx].Failures++
+		b.keys[idx].FailedAt = time.Now()
+	}
+}
+
+// UsableKeyCount returns the number of keys that have not failed
+// repeatedly in a row within the last keyCooldown.
+func (b *KeyBalancer) UsableKeyCount() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	usable := 0
+	for _, k := range b.keys {
+		if k.Failures < maxKeyFailures || time.Since(k.FailedAt) >= keyCooldown {
+			usable++
+		}
+	}
+	return usable
+}
+
+// keyFault reports whether err is upstream rejecting the key or failing on
+// its side: an authentication, quota or server error. Other client errors
+// are the request's fault, and cancellations and deadlines the caller's.
+func keyFault(err error) bool {
+	status := httpStatus(err)
+	return status == 401 || status == 403 || status == 429 || status >= 500
+}
+
+// requestFault reports whether err is upstream rejecting the request itself,
+// which every other key would reject too.
+func requestFault(err error) bool {
+	status := httpStatus(err)
+	return status >= 400 && status < 500 && !keyFault(err)
+}
+
 func (b *KeyBalancer) areAllUsed() bool {
 	for _, k := range b.keys {
 		if !k.Used {
@@ -68,3 +141,53 @@ func (b *KeyBalancer) reset() {
 		b.keys[i].Used = false
 	}
 }
+
+// keysFromEnv returns the API keys listed in the first of the environment
+// variables names that is set.
+func keysFromEnv(names ...string) []string {
+	var raw string
+	for _, name := range names {
+		if raw = os.Getenv(name); raw != "" {
+			break
+		}
+	}
+	return strings.FieldsFunc(raw, func(r rune) bool {
+		return r == ',' || r == '\n' || r == '\r' || r == '\t' || r == ' ' || r == '\\'
+	})
+}
+
+// keyPool is the API keys and rate limits of one provider.
+type keyPool struct {
+	balancer *KeyBalancer
+	limiter  *RateLimiter
+}
+
+type poolKey struct {
+	cfg      *config.Config
+	provider string
+}
+
+var (
+	poolsMu sync.Mutex
+	pools   = map[poolKey]keyPool{}
+)
+
+// sharedKeys returns the key balancer and rate limiter of provider under
+// cfg, reading the keys from the first of the environment variables keyVars
+// that is set. A provider's chat and embedding models share them, so that
+// they rotate the same keys within the same rate limits.
+func sharedKeys(cfg *config.Config, provider string, keyVars ...string) (*KeyBalancer, *RateLimiter) {
+	poolsMu.Lock()
+	defer poolsMu.Unlock()
+
+	k := poolKey{cfg: cfg, provider: provider}
+	pool, ok := pools[k]
+	if !ok {
+		pool = keyPool{
+			balancer: NewKeyBalancer(keysFromEnv(keyVars...)),
+			limiter:  NewRateLimiter(provider, cfg.Models.Provider(provider).RateLimits),
+		}
+		pools[k] = pool
+	}
+	return pool.balancer, pool.limiter
+}
@@ -0,0 +1,65 @@
+package model
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"google.golang.org/genai"
+)
+
+func TestKeyFailuresOnlyCountKeyFaults(t *testing.T) {
+	b := NewKeyBalancer([]string{"k"})
+	for _, err := range []error{
+		genai.APIError{Code: 400},
+		genai.APIError{Code: 404},
+		context.Canceled,
+		context.DeadlineExceeded,
+	} {
+		for range maxKeyFailures {
+			b.ReportFailure(0, err)
+		}
+	}
+	if got := b.UsableKeyCount(); got != 1 {
+		t.Errorf("UsableKeyCount = %d after client errors, want 1", got)
+	}
+
+	for _, code := range []int{401, 429, 503} {
+		b.ReportFailure(0, genai.APIError{Code: code})
+	}
+	if got := b.UsableKeyCount(); got != 0 {
+		t.Errorf("UsableKeyCount = %d after key faults, want 0", got)
+	}
+}
+
+func TestKeyRecoversAfterCooldown(t *testing.T) {
+	b := NewKeyBalancer([]string{"k"})
+	for range maxKeyFailures {
+		b.ReportFailure(0, errUnavailable)
+	}
+	b.keys[0].FailedAt = time.Now().Add(-keyCooldown)
+	if got := b.UsableKeyCount(); got != 1 {
+		t.Fatalf("UsableKeyCount = %d after the cooldown, want 1", got)
+	}
+
+	// The retried key fails again and sits out another cooldown.
+	b.ReportFailure(0, errUnavailable)
+	if got := b.UsableKeyCount(); got != 0 {
+		t.Fatalf("UsableKeyCount = %d after failing again, want 0", got)
+	}
+	b.ReportSuccess(0)
+	if got := b.UsableKeyCount(); got != 1 {
+		t.Errorf("UsableKeyCount = %d after a success, want 1", got)
+	}
+}
+
+func TestStatusErrorIsClassified(t *testing.T) {
+	err := &statusError{provider: "openai", status: 401, message: "bad key"}
+	if !keyFault(err) || requestFault(err) {
+		t.Errorf("401 keyFault = %v, requestFault = %v; want a key fault", keyFault(err), requestFault(err))
+	}
+	err = &statusError{provider: "openai", status: 400}
+	if keyFault(err) || !requestFault(err) {
+		t.Errorf("400 keyFault = %v, requestFault = %v; want a request fault", keyFault(err), requestFault(err))
+	}
+}
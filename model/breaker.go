@@ -0,0 +1,214 @@
+package model
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/sokinpui/synapse.go/internal/config"
+)
+
+// ErrCircuitOpen is returned without calling upstream while a model's
+// circuit breaker is open and it has no fallback.
+var ErrCircuitOpen = errors.New("circuit breaker is open")
+
+// ErrTimeout is the cause a caller cancels a call's context with when the
+// call ran past one of its timeouts. Unlike other cancellations, a call ended
+// this way counts against the circuit breaker, so that an upstream that hangs
+// still opens the circuit.
+var ErrTimeout = errors.New("task timed out")
+
+// Circuit breaker states.
+const (
+	CircuitClosed   = "closed"
+	CircuitOpen     = "open"
+	CircuitHalfOpen = "half_open"
+)
+
+// CircuitStatus is a snapshot of a model's circuit breaker.
+type CircuitStatus struct {
+	State               string     `json:"state"`
+	ConsecutiveFailures int        `json:"consecutive_failures"`
+	OpenedAt            *time.Time `json:"opened_at,omitempty"`
+	Fallback            string     `json:"fallback,omitempty"`
+}
+
+type fallbackKey struct{}
+
+// circuitBreaker stops calling a model after FailureThreshold consecutive
+// upstream failures. While open, calls fail fast or go to the fallback model; after
+// OpenTimeout a single probe is let through, and its outcome decides whether
+// the circuit closes again.
+type circuitBreaker struct {
+	LLM
+	model    string
+	policy   config.BreakerPolicy
+	fallback LLM
+
+	mu       sync.Mutex
+	state    string
+	failures int
+	openedAt time.Time
+	probing  bool
+}
+
+func newCircuitBreaker(llm LLM, modelCode string, policy config.BreakerPolicy) *circuitBreaker {
+	if policy.OpenTimeout <= 0 {
+		policy.OpenTimeout = 30 * time.Second
+	}
+	return &circuitBreaker{
+		LLM:    llm,
+		model:  modelCode,
+		policy: policy,
+		state:  CircuitClosed,
+	}
+}
+
+func (b *circuitBreaker) Generate(ctx context.Context, prompt string, images [][]byte, config *Config) (string, error) {
+	if !b.allow() {
+		fallback, err := b.rejected(ctx)
+		if err != nil {
+			return "", err
+		}
+		return fallback.Generate(context.WithValue(ctx, fallbackKey{}, true), prompt, images, config)
+	}
+
+	text, err := b.LLM.Generate(ctx, prompt, images, config)
+	b.record(ctx, err)
+	return text, err
+}
+
+func (b *circuitBreaker) GenerateStream(ctx context.Context, prompt string, images [][]byte, config *Config) (<-chan string, <-chan error) {
+	if !b.allow() {
+		fallback, err := b.rejected(ctx)
+		if err != nil {
+			outCh := make(chan string)
+			errCh := make(chan error, 1)
+			errCh <- err
+			close(errCh)
+			close(outCh)
+			return outCh, errCh
+		}
+		return fallback.GenerateStream(context.WithValue(ctx, fallbackKey{}, true), prompt, images, config)
+	}
+
+	outCh := make(chan string)
+	errCh := make(chan error, 1)
+
+	go func() {
+		defer close(outCh)
+		defer close(errCh)
+
+		chunks, errs := b.LLM.GenerateStream(ctx, prompt, images, config)
+		_, err := forwardStream(ctx, outCh, chunks, errs)
+		b.record(ctx, err)
+		if err != nil {
+			errCh <- err
+		}
+	}()
+
+	return outCh, errCh
+}
+
+// rejected returns the fallback to use for a call the breaker turned away,
+// or ErrCircuitOpen if there is none. Fallbacks are not chained, so that two
+// models falling back to each other cannot loop.
+func (b *circuitBreaker) rejected(ctx context.Context) (LLM, error) {
+	if b.fallback == nil || ctx.Value(fallbackKey{}) != nil {
+		return nil, fmt.Errorf("%w for model %s", ErrCircuitOpen, b.model)
+	}
+	slog.InfoContext(ctx, "Circuit open, falling back", "model", b.model, "fallback", b.policy.Fallback)
+	return b.fallback, nil
+}
+
+func (b *circuitBreaker) allow() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch b.state {
+	case CircuitOpen:
+		if time.Since(b.openedAt) < b.policy.OpenTimeout {
+			return false
+		}
+		b.state = CircuitHalfOpen
+		b.probing = true
+		slog.Info("Circuit half-open, probing upstream", "model", b.model)
+		return true
+	case CircuitHalfOpen:
+		if b.probing {
+			return false
+		}
+		b.probing = true
+		return true
+	}
+	return true
+}
+
+func (b *circuitBreaker) record(ctx context.Context, err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	wasProbe := b.state == CircuitHalfOpen
+	b.probing = false
+
+	switch {
+	case err == nil:
+		if b.state != CircuitClosed {
+			slog.Info("Circuit closed", "model", b.model)
+		}
+		b.state = CircuitClosed
+		b.failures = 0
+	case !breakerFailure(ctx, err):
+		// A bad request, rate limiting or a caller giving up says
+		// nothing about upstream health.
+	default:
+		b.failures++
+		if wasProbe || b.failures >= b.policy.FailureThreshold {
+			if b.state != CircuitOpen {
+				slog.Warn("Circuit opened", "model", b.model, "consecutive_failures", b.failures)
+			}
+			b.state = CircuitOpen
+			b.openedAt = time.Now()
+		}
+	}
+}
+
+// breakerFailure reports whether err counts against the breaker: only server
+// errors, timeouts and transport failures do.
+//
+// A call cut short by its caller's context counts only if the caller timed it
+// out with ErrTimeout: a hang that runs into a task's deadline, first-token or
+// idle timeout is a failure of upstream, while a caller canceling the call or
+// shutting down says nothing about it.
+func breakerFailure(ctx context.Context, err error) bool {
+	if ctx.Err() != nil {
+		return errors.Is(context.Cause(ctx), ErrTimeout)
+	}
+	if errors.Is(err, context.Canceled) {
+		return false
+	}
+	switch ClassifyError(err) {
+	case ErrorClassServer, ErrorClassTimeout, ErrorClassNetwork:
+		return true
+	}
+	return false
+}
+
+func (b *circuitBreaker) status() CircuitStatus {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	status := CircuitStatus{
+		State:               b.state,
+		ConsecutiveFailures: b.failures,
+		Fallback:            b.policy.Fallback,
+	}
+	if b.state != CircuitClosed {
+		openedAt := b.openedAt
+		status.OpenedAt = &openedAt
+	}
+	return status
+}
@@ -0,0 +1,120 @@
+package model
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/sokinpui/synapse.go/internal/config"
+	"google.golang.org/genai"
+)
+
+func TestBreakerOpensOnServerErrors(t *testing.T) {
+	fake := &fakeLLM{errs: []error{errUnavailable, errUnavailable}}
+	b := newCircuitBreaker(fake, "m", config.BreakerPolicy{FailureThreshold: 2, OpenTimeout: time.Hour})
+
+	for range 2 {
+		b.Generate(context.Background(), "hi", nil, nil)
+	}
+	if _, err := b.Generate(context.Background(), "hi", nil, nil); !errors.Is(err, ErrCircuitOpen) {
+		t.Fatalf("Generate = %v, want ErrCircuitOpen", err)
+	}
+	if fake.calls != 2 {
+		t.Errorf("calls = %d, want 2", fake.calls)
+	}
+}
+
+func TestBreakerIgnoresClientErrors(t *testing.T) {
+	badRequest := genai.APIError{Code: 400, Message: "bad request"}
+	rateLimited := genai.APIError{Code: 429, Message: "quota"}
+	fake := &fakeLLM{errs: []error{badRequest, rateLimited, context.Canceled, badRequest}}
+	b := newCircuitBreaker(fake, "m", config.BreakerPolicy{FailureThreshold: 1, OpenTimeout: time.Hour})
+
+	for range 4 {
+		b.Generate(context.Background(), "hi", nil, nil)
+	}
+	if st := b.status(); st.State != CircuitClosed || st.ConsecutiveFailures != 0 {
+		t.Errorf("status = %+v, want closed without failures", st)
+	}
+}
+
+func TestBreakerProbeClosesCircuit(t *testing.T) {
+	fake := &fakeLLM{errs: []error{errUnavailable}}
+	b := newCircuitBreaker(fake, "m", config.BreakerPolicy{FailureThreshold: 1, OpenTimeout: time.Millisecond})
+
+	b.Generate(context.Background(), "hi", nil, nil)
+	if st := b.status(); st.State != CircuitOpen {
+		t.Fatalf("state = %s, want open", st.State)
+	}
+	time.Sleep(2 * time.Millisecond)
+	if _, err := b.Generate(context.Background(), "hi", nil, nil); err != nil {
+		t.Fatalf("probe failed: %v", err)
+	}
+	if st := b.status(); st.State != CircuitClosed {
+		t.Errorf("state = %s, want closed", st.State)
+	}
+}
+
+func TestBreakerIgnoresCallerCancellation(t *testing.T) {
+	fake := &fakeLLM{errs: []error{context.Canceled, context.Canceled}}
+	b := newCircuitBreaker(fake, "m", config.BreakerPolicy{FailureThreshold: 1, OpenTimeout: time.Hour})
+
+	// A client going away, and a shutdown, which cancels with its own cause.
+	gone, cancel := context.WithCancel(context.Background())
+	cancel()
+	b.Generate(gone, "hi", nil, nil)
+	shutdown, cancelShutdown := context.WithCancelCause(context.Background())
+	cancelShutdown(errors.New("shutting down"))
+	b.Generate(shutdown, "hi", nil, nil)
+
+	if st := b.status(); st.State != CircuitClosed || st.ConsecutiveFailures != 0 {
+		t.Errorf("status = %+v, want closed without failures", st)
+	}
+}
+
+// hangingLLM never answers, returning only once its caller gives up.
+type hangingLLM struct{ fakeLLM }
+
+func (h *hangingLLM) Generate(ctx context.Context, prompt string, images [][]byte, config *Config) (string, error) {
+	<-ctx.Done()
+	return "", ctx.Err()
+}
+
+func (h *hangingLLM) GenerateStream(ctx context.Context, prompt string, images [][]byte, config *Config) (<-chan string, <-chan error) {
+	outCh := make(chan string)
+	errCh := make(chan error, 1)
+	go func() {
+		defer close(outCh)
+		defer close(errCh)
+		<-ctx.Done()
+		errCh <- ctx.Err()
+	}()
+	return outCh, errCh
+}
+
+func TestBreakerCountsHangsEndedByTimeout(t *testing.T) {
+	b := newCircuitBreaker(&hangingLLM{}, "m", config.BreakerPolicy{FailureThreshold: 2, OpenTimeout: time.Hour})
+
+	// A task deadline, then a stream idle timeout, which the worker signals
+	// by canceling the task's context with ErrTimeout.
+	deadline, cancel := context.WithTimeoutCause(context.Background(), 10*time.Millisecond,
+		fmt.Errorf("%w: no result within 10ms", ErrTimeout))
+	defer cancel()
+	if _, err := b.Generate(deadline, "hi", nil, nil); err == nil {
+		t.Fatal("Generate succeeded, want the deadline")
+	}
+
+	idle, cancelIdle := context.WithCancelCause(context.Background())
+	chunks, errs := b.GenerateStream(idle, "hi", nil, nil)
+	cancelIdle(fmt.Errorf("%w: stream idle for 10ms", ErrTimeout))
+	Drain(chunks)
+	if err := <-errs; err == nil {
+		t.Fatal("stream succeeded, want the idle timeout")
+	}
+
+	if st := b.status(); st.State != CircuitOpen || st.ConsecutiveFailures != 2 {
+		t.Errorf("status = %+v, want open after 2 failures", st)
+	}
+}
@@ -5,10 +5,11 @@ import "errors"
 // Config defines the generation configuration for a model.
 // All fields are optional.
 type Config struct {
-	Temperature  *float32 `json:"temperature,omitempty"`
-	TopP         *float32 `json:"top_p,omitempty"`
-	TopK         *float32 `json:"top_k,omitempty"`
-	OutputLength int32    `json:"output_length,omitempty"`
+	Temperature   *float32 `json:"temperature,omitempty"`
+	TopP          *float32 `json:"top_p,omitempty"`
+	TopK          *float32 `json:"top_k,omitempty"`
+	OutputLength  int32    `json:"output_length,omitempty"`
+	StopSequences []string `json:"stop_sequences,omitempty"`
 }
 
 // Custom errors for the library.
@@ -0,0 +1,86 @@
+package model
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sort"
+
+	"github.com/sokinpui/synapse.go/internal/config"
+)
+
+// Embedder turns texts into embedding vectors.
+type Embedder interface {
+	// Embed returns one vector per input, in order.
+	Embed(ctx context.Context, inputs []string, opts *EmbedOptions) (*Embeddings, error)
+}
+
+// EmbedOptions are the optional settings of an embedding request.
+type EmbedOptions struct {
+	// Dimensions truncates the vectors to that many dimensions, for models
+	// that support it.
+	Dimensions int32 `json:"dimensions,omitempty"`
+}
+
+// Embeddings is the result of an embedding request.
+type Embeddings struct {
+	Vectors [][]float32
+	// InputTokens is the number of tokens in the inputs, as reported by
+	// upstream or else estimated.
+	InputTokens int
+}
+
+type EmbedderProvider func(cfg *config.Config) (map[string]Embedder, error)
+
+type namedEmbedderProvider struct {
+	name    string
+	provide EmbedderProvider
+}
+
+var embedderProviders []namedEmbedderProvider
+
+// RegisterEmbedderProvider makes a provider's embedding models available to
+// every Registry created afterwards, like RegisterProvider does for LLMs.
+func RegisterEmbedderProvider(name string, provider EmbedderProvider) {
+	embedderProviders = append(embedderProviders, namedEmbedderProvider{name: name, provide: provider})
+}
+
+// newEmbedders collects the embedding models of every registered provider,
+// adding the key balancers they draw from to keys.
+func newEmbedders(cfg *config.Config, keys map[string]*KeyBalancer) (map[string]Embedder, error) {
+	embedders := make(map[string]Embedder)
+	for _, provider := range embedderProviders {
+		providerEmbedders, err := provider.provide(cfg)
+		if err != nil {
+			return nil, fmt.Errorf("failed to initialize embedding provider '%s': %w", provider.name, err)
+		}
+		for name, embedder := range providerEmbedders {
+			if _, exists := embedders[name]; exists {
+				slog.Warn("Embedding model is being overwritten by a new provider", "model", name, "provider", provider.name)
+			}
+			if k, ok := embedder.(keyed); ok {
+				keys[provider.name] = k.Keys()
+			}
+			embedders[name] = embedder
+		}
+	}
+	return embedders, nil
+}
+
+func (r *Registry) GetEmbedder(modelCode string) (Embedder, error) {
+	embedder, ok := r.embedders[modelCode]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrModelNotFound, modelCode)
+	}
+	return embedder, nil
+}
+
+// ListEmbedders returns the codes of the embedding models, sorted.
+func (r *Registry) ListEmbedders() []string {
+	keys := make([]string, 0, len(r.embedders))
+	for k := range r.embedders {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,52 @@
+package model
+
+import (
+	"testing"
+
+	"github.com/sokinpui/synapse.go/internal/config"
+)
+
+func TestGeminiModelsAndEmbeddersShareKeys(t *testing.T) {
+	t.Setenv("GENAI_API_KEYS", "k1,k2")
+	cfg := &config.Config{}
+	cfg.Models.Gemini.Codes = []string{"chat-test"}
+	cfg.Models.Gemini.EmbeddingCodes = []string{"embed-test"}
+	cfg.Models.Gemini.RateLimits = map[string]config.RateLimit{"default": {RPM: 60}}
+
+	r, err := New(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	embedder, err := r.GetEmbedder("embed-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ge := embedder.(*GeminiEmbedder)
+	if ge.balancer != r.keys["gemini"] {
+		t.Error("the embedder draws from other keys than the chat models")
+	}
+	if _, limiter := sharedKeys(cfg, "gemini"); ge.limiter != limiter {
+		t.Error("the embedder has a rate limiter of its own")
+	}
+
+	for range maxKeyFailures {
+		ge.balancer.ReportFailure(0, errUnavailable)
+	}
+	if st := r.Providers()["gemini"]; st.Keys != 2 || st.UsableKeys != 1 {
+		t.Errorf("gemini status = %+v, want the embedder's key failures to count", st)
+	}
+}
+
+func TestEmbeddingOnlyProviderReportsKeys(t *testing.T) {
+	t.Setenv("OPENAI_API_KEYS", "k1")
+	cfg := &config.Config{}
+	cfg.Models.OpenAI.EmbeddingCodes = []string{"text-embedding-test"}
+
+	r, err := New(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if st, ok := r.Providers()["openai"]; !ok || st.Keys != 1 || st.UsableKeys != 1 {
+		t.Errorf("openai status = %+v, %v; want its key reported", st, ok)
+	}
+}
@@ -4,39 +4,30 @@ import (
 	"context"
 	"errors"
 	"fmt"
-	"log"
+	"log/slog"
+
 	"github.com/sokinpui/synapse.go/internal/config"
+	"github.com/sokinpui/synapse.go/internal/logging"
+	"github.com/sokinpui/synapse.go/internal/metrics"
+	"github.com/sokinpui/synapse.go/internal/tracing"
+	"go.opentelemetry.io/otel/attribute"
 	"google.golang.org/genai"
 	"google.golang.org/genai/tokenizer"
-	"os"
-	"strings"
 )
 
 func init() {
-	RegisterProvider(newGeminiProvider)
+	RegisterProvider("gemini", newGeminiProvider)
 }
 
 func newGeminiProvider(cfg *config.Config) (map[string]LLM, error) {
-	apiKeysVar := os.Getenv("GENAI_API_KEYS")
-	rawKeys := strings.FieldsFunc(apiKeysVar, func(r rune) bool {
-		return r == ',' || r == '\n' || r == '\r' || r == '\t' || r == ' ' || r == '\\'
-	})
-
-	var apiKeys []string
-	for _, k := range rawKeys {
-		if trimmed := strings.TrimSpace(k); trimmed != "" {
-			apiKeys = append(apiKeys, trimmed)
-		}
-	}
-
-	log.Printf("Gemini provider initialized with %d API keys", len(apiKeys))
+	balancer, limiter := sharedKeys(cfg, "gemini", "GENAI_API_KEYS")
+	slog.Info("Gemini provider initialized", "keys", balancer.KeyCount())
 
 	models := make(map[string]LLM)
 	ctx := context.Background()
-	balancer := NewKeyBalancer(apiKeys)
 
 	for _, code := range cfg.Models.Gemini.Codes {
-		model, err := NewGeminiModel(ctx, code, balancer)
+		model, err := NewGeminiModel(ctx, code, balancer, limiter)
 		if err != nil {
 			return nil, fmt.Errorf("failed to create Gemini model '%s': %w", code, err)
 		}
@@ -49,15 +40,22 @@ func newGeminiProvider(cfg *config.Config) (map[string]LLM, error) {
 type GeminiModel struct {
 	model    string
 	balancer *KeyBalancer
+	limiter  *RateLimiter
 }
 
-func NewGeminiModel(ctx context.Context, modelCode string, balancer *KeyBalancer) (*GeminiModel, error) {
+func NewGeminiModel(ctx context.Context, modelCode string, balancer *KeyBalancer, limiter *RateLimiter) (*GeminiModel, error) {
 	return &GeminiModel{
 		model:    modelCode,
 		balancer: balancer,
+		limiter:  limiter,
 	}, nil
 }
 
+// Keys returns the balancer of the API keys this model draws from.
+func (m *GeminiModel) Keys() *KeyBalancer {
+	return m.balancer
+}
+
 // Generate performs a non-streaming text generation.
 func (m *GeminiModel) Generate(ctx context.Context, prompt string, images [][]byte, config *Config) (string, error) {
 	if m.balancer.KeyCount() == 0 {
@@ -70,32 +68,42 @@ func (m *GeminiModel) Generate(ctx context.Context, prompt string, images [][]by
 	}
 
 	genConfig := getGenConfig(config)
+	tokens := approxTokens(prompt)
 	var lastErr error
 
 	for i := 0; i < m.balancer.KeyCount(); i++ {
 		if ctx.Err() != nil {
 			return "", ctx.Err()
 		}
+		// The first key is the attempt the caller already counted; every
+		// further key is another upstream call within the same attempt.
+		if i > 0 {
+			reportFrom(ctx).addAttempt()
+		}
 
-		apiKey, keyIdx := m.balancer.PickKey()
-		log.Printf("[%s] Attempting generation with API key #%d", m.model, keyIdx)
-
-		client, err := genai.NewClient(ctx, &genai.ClientConfig{APIKey: apiKey, Backend: genai.BackendGeminiAPI})
+		apiKey, keyIdx, err := m.limiter.PickKey(ctx, m.balancer, m.model, tokens)
 		if err != nil {
-			lastErr = fmt.Errorf("failed to create genai client: %w", err)
-			log.Printf("Gemini API key [#%d] failed for model %s, retrying... Error: %v", keyIdx, m.model, err)
-			continue
+			return "", err
 		}
+		keyCtx := logging.With(ctx, slog.Int("key_index", keyIdx))
+		slog.DebugContext(keyCtx, "Attempting generation", "model", m.model, logging.Key(apiKey))
 
-		resp, err := client.Models.GenerateContent(ctx, m.model, content, genConfig)
+		resp, err := m.generateWithKey(keyCtx, apiKey, keyIdx, content, genConfig)
 		if err != nil {
 			if errors.Is(err, context.Canceled) {
 				return "", err
 			}
-			lastErr = fmt.Errorf("%w: %v", ErrGeneration, err)
-			log.Printf("Gemini API key [#%d] failed for model %s, retrying... Error: %v", keyIdx, m.model, err)
+			lastErr = fmt.Errorf("%w: %w", ErrGeneration, err)
+			if requestFault(err) {
+				return "", lastErr
+			}
+			m.balancer.ReportFailure(keyIdx, err)
+			metrics.KeyFailures.WithLabelValues("gemini").Inc()
+			slog.WarnContext(keyCtx, "Gemini API key failed, trying the next one", "model", m.model, "error", err)
 			continue
 		}
+		m.balancer.ReportSuccess(keyIdx)
+		reportFrom(ctx).addUsage("gemini", m.model, keyIdx, geminiUsage(resp.UsageMetadata))
 
 		if len(resp.Candidates) == 0 || len(resp.Candidates[0].Content.Parts) == 0 {
 			return "", fmt.Errorf("%w: no content in response", ErrGeneration)
@@ -107,6 +115,22 @@ func (m *GeminiModel) Generate(ctx context.Context, prompt string, images [][]by
 	return "", fmt.Errorf("all API keys failed: %w", lastErr)
 }
 
+// generateWithKey makes one upstream call with the given API key.
+func (m *GeminiModel) generateWithKey(ctx context.Context, apiKey string, keyIdx int, content []*genai.Content, genConfig *genai.GenerateContentConfig) (resp *genai.GenerateContentResponse, err error) {
+	ctx, span := tracing.Start(ctx, "gemini.key_attempt", attribute.String("model", m.model), attribute.Int("key_index", keyIdx))
+	defer func() { tracing.End(span, err) }()
+
+	client, err := genai.NewClient(ctx, &genai.ClientConfig{APIKey: apiKey, Backend: genai.BackendGeminiAPI})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create genai client: %w", err)
+	}
+
+	ctx, call := tracing.Start(ctx, "gemini.GenerateContent", attribute.String("model", m.model))
+	resp, err = client.Models.GenerateContent(ctx, m.model, content, genConfig)
+	tracing.End(call, err)
+	return resp, err
+}
+
 // GenerateStream performs a streaming text generation.
 func (m *GeminiModel) GenerateStream(ctx context.Context, prompt string, images [][]byte, config *Config) (<-chan string, <-chan error) {
 	genConfig := getGenConfig(config)
@@ -128,6 +152,7 @@ func (m *GeminiModel) GenerateStream(ctx context.Context, prompt string, images
 			return
 		}
 
+		tokens := approxTokens(prompt)
 		var lastErr error
 
 		for i := 0; i < m.balancer.KeyCount(); i++ {
@@ -135,39 +160,36 @@ func (m *GeminiModel) GenerateStream(ctx context.Context, prompt string, images
 				errCh <- ctx.Err()
 				return
 			}
+			if i > 0 {
+				reportFrom(ctx).addAttempt()
+			}
 
-			apiKey, keyIdx := m.balancer.PickKey()
-			log.Printf("[%s] Attempting stream generation with API key #%d", m.model, keyIdx)
-
-			client, err := genai.NewClient(ctx, &genai.ClientConfig{APIKey: apiKey, Backend: genai.BackendGeminiAPI})
+			apiKey, keyIdx, err := m.limiter.PickKey(ctx, m.balancer, m.model, tokens)
 			if err != nil {
-				lastErr = fmt.Errorf("failed to create genai client: %w", err)
-				log.Printf("Gemini API key [#%d] failed for model %s (stream), retrying... Error: %v", keyIdx, m.model, err)
-				continue
+				errCh <- err
+				return
 			}
+			keyCtx := logging.With(ctx, slog.Int("key_index", keyIdx))
+			slog.DebugContext(keyCtx, "Attempting stream generation", "model", m.model, logging.Key(apiKey))
 
-			streamErr := func() error {
-				iter := client.Models.GenerateContentStream(ctx, m.model, content, genConfig)
-				for resp, err := range iter {
-					if err != nil {
-						return err
-					}
-					if resp != nil && len(resp.Candidates) > 0 && len(resp.Candidates[0].Content.Parts) > 0 {
-						outCh <- resp.Text()
-					}
-				}
-				return nil
-			}()
-
+			usage, streamErr := m.streamWithKey(keyCtx, apiKey, keyIdx, content, genConfig, outCh)
 			if streamErr != nil {
 				if errors.Is(streamErr, context.Canceled) {
 					errCh <- streamErr
 					return
 				}
-				lastErr = fmt.Errorf("%w: %v", ErrGeneration, streamErr)
-				log.Printf("Gemini API key [#%d] failed for model %s (stream), retrying... Error: %v", keyIdx, m.model, streamErr)
+				lastErr = fmt.Errorf("%w: %w", ErrGeneration, streamErr)
+				if requestFault(streamErr) {
+					errCh <- lastErr
+					return
+				}
+				m.balancer.ReportFailure(keyIdx, streamErr)
+				metrics.KeyFailures.WithLabelValues("gemini").Inc()
+				slog.WarnContext(keyCtx, "Gemini API key failed, trying the next one", "model", m.model, "stream", true, "error", streamErr)
 				continue
 			}
+			m.balancer.ReportSuccess(keyIdx)
+			reportFrom(ctx).addUsage("gemini", m.model, keyIdx, geminiUsage(usage))
 			return // Success
 		}
 
@@ -176,6 +198,46 @@ func (m *GeminiModel) GenerateStream(ctx context.Context, prompt string, images
 	return outCh, errCh
 }
 
+// streamWithKey makes one upstream streaming call with the given API key,
+// sending its chunks to out, and returns the usage upstream reported.
+func (m *GeminiModel) streamWithKey(ctx context.Context, apiKey string, keyIdx int, content []*genai.Content, genConfig *genai.GenerateContentConfig, out chan<- string) (usage *genai.GenerateContentResponseUsageMetadata, err error) {
+	ctx, span := tracing.Start(ctx, "gemini.key_attempt", attribute.String("model", m.model), attribute.Int("key_index", keyIdx))
+	defer func() { tracing.End(span, err) }()
+
+	client, err := genai.NewClient(ctx, &genai.ClientConfig{APIKey: apiKey, Backend: genai.BackendGeminiAPI})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create genai client: %w", err)
+	}
+
+	ctx, call := tracing.Start(ctx, "gemini.GenerateContentStream", attribute.String("model", m.model))
+	defer func() { tracing.End(call, err) }()
+
+	iter := client.Models.GenerateContentStream(ctx, m.model, content, genConfig)
+	for resp, iterErr := range iter {
+		if iterErr != nil {
+			return usage, iterErr
+		}
+		if resp != nil && resp.UsageMetadata != nil {
+			usage = resp.UsageMetadata
+		}
+		if resp != nil && len(resp.Candidates) > 0 && len(resp.Candidates[0].Content.Parts) > 0 {
+			out <- resp.Text()
+		}
+	}
+	return usage, nil
+}
+
+func geminiUsage(u *genai.GenerateContentResponseUsageMetadata) Usage {
+	if u == nil {
+		return Usage{}
+	}
+	return Usage{
+		InputTokens:  int(u.PromptTokenCount),
+		OutputTokens: int(u.CandidatesTokenCount + u.ThoughtsTokenCount),
+		CachedTokens: int(u.CachedContentTokenCount),
+	}
+}
+
 func buildContent(prompt string, images [][]byte) ([]*genai.Content, error) {
 	parts := []*genai.Part{genai.NewPartFromText(prompt)}
 
@@ -227,6 +289,7 @@ func getGenConfig(config *Config) *genai.GenerateContentConfig {
 		TopP:            config.TopP,
 		TopK:            config.TopK,
 		MaxOutputTokens: config.OutputLength,
+		StopSequences:   config.StopSequences,
 		// Tools:           tools,
 	}
 }
@@ -0,0 +1,125 @@
+package model
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+
+	"github.com/sokinpui/synapse.go/internal/config"
+	"github.com/sokinpui/synapse.go/internal/logging"
+	"github.com/sokinpui/synapse.go/internal/metrics"
+	"github.com/sokinpui/synapse.go/internal/tracing"
+	"go.opentelemetry.io/otel/attribute"
+	"google.golang.org/genai"
+)
+
+func init() {
+	RegisterEmbedderProvider("gemini", newGeminiEmbedderProvider)
+}
+
+func newGeminiEmbedderProvider(cfg *config.Config) (map[string]Embedder, error) {
+	codes := cfg.Models.Gemini.EmbeddingCodes
+	if len(codes) == 0 {
+		return nil, nil
+	}
+
+	balancer, limiter := sharedKeys(cfg, "gemini", "GENAI_API_KEYS")
+
+	embedders := make(map[string]Embedder, len(codes))
+	for _, code := range codes {
+		embedders[code] = &GeminiEmbedder{model: code, balancer: balancer, limiter: limiter}
+	}
+	return embedders, nil
+}
+
+// GeminiEmbedder embeds texts with the Gemini EmbedContent API.
+type GeminiEmbedder struct {
+	model    string
+	balancer *KeyBalancer
+	limiter  *RateLimiter
+}
+
+// Keys returns the balancer of the API keys this model draws from.
+func (m *GeminiEmbedder) Keys() *KeyBalancer {
+	return m.balancer
+}
+
+func (m *GeminiEmbedder) Embed(ctx context.Context, inputs []string, opts *EmbedOptions) (*Embeddings, error) {
+	if m.balancer.KeyCount() == 0 {
+		return nil, fmt.Errorf("%w: API key is required for embedding", ErrConfiguration)
+	}
+
+	contents := make([]*genai.Content, len(inputs))
+	tokens := 0
+	for i, input := range inputs {
+		contents[i] = genai.NewContentFromText(input, genai.RoleUser)
+		tokens += approxTokens(input)
+	}
+	embedConfig := &genai.EmbedContentConfig{}
+	if opts != nil && opts.Dimensions > 0 {
+		embedConfig.OutputDimensionality = &opts.Dimensions
+	}
+
+	var lastErr error
+	for i := 0; i < m.balancer.KeyCount(); i++ {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+
+		apiKey, keyIdx, err := m.limiter.PickKey(ctx, m.balancer, m.model, tokens)
+		if err != nil {
+			return nil, err
+		}
+		keyCtx := logging.With(ctx, slog.Int("key_index", keyIdx))
+		slog.DebugContext(keyCtx, "Attempting embedding", "model", m.model, logging.Key(apiKey))
+
+		resp, err := m.embedWithKey(keyCtx, apiKey, keyIdx, contents, embedConfig)
+		if err != nil {
+			if errors.Is(err, context.Canceled) {
+				return nil, err
+			}
+			lastErr = fmt.Errorf("%w: %w", ErrGeneration, err)
+			if requestFault(err) {
+				return nil, lastErr
+			}
+			m.balancer.ReportFailure(keyIdx, err)
+			metrics.KeyFailures.WithLabelValues("gemini").Inc()
+			slog.WarnContext(keyCtx, "Gemini API key failed, trying the next one", "model", m.model, "error", err)
+			continue
+		}
+		m.balancer.ReportSuccess(keyIdx)
+
+		if len(resp.Embeddings) != len(inputs) {
+			return nil, fmt.Errorf("%w: got %d embeddings for %d inputs", ErrGeneration, len(resp.Embeddings), len(inputs))
+		}
+		out := &Embeddings{Vectors: make([][]float32, len(inputs))}
+		reported := 0
+		for i, e := range resp.Embeddings {
+			out.Vectors[i] = e.Values
+			if e.Statistics != nil {
+				reported += int(e.Statistics.TokenCount)
+			}
+		}
+		out.InputTokens = tokens
+		if reported > 0 {
+			out.InputTokens = reported
+		}
+		reportFrom(ctx).addUsage("gemini", m.model, keyIdx, Usage{InputTokens: out.InputTokens})
+		return out, nil
+	}
+
+	return nil, fmt.Errorf("all API keys failed: %w", lastErr)
+}
+
+// embedWithKey makes one upstream call with the given API key.
+func (m *GeminiEmbedder) embedWithKey(ctx context.Context, apiKey string, keyIdx int, contents []*genai.Content, embedConfig *genai.EmbedContentConfig) (resp *genai.EmbedContentResponse, err error) {
+	ctx, span := tracing.Start(ctx, "gemini.EmbedContent", attribute.String("model", m.model), attribute.Int("key_index", keyIdx))
+	defer func() { tracing.End(span, err) }()
+
+	client, err := genai.NewClient(ctx, &genai.ClientConfig{APIKey: apiKey, Backend: genai.BackendGeminiAPI})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create genai client: %w", err)
+	}
+	return client.Models.EmbedContent(ctx, m.model, contents, embedConfig)
+}
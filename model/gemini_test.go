@@ -0,0 +1,47 @@
+package model
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/sokinpui/synapse.go/internal/config"
+)
+
+func TestGeminiFailsOverToNextKeyWithoutRetryPolicy(t *testing.T) {
+	var mu sync.Mutex
+	var keys []string
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get("x-goog-api-key")
+		mu.Lock()
+		keys = append(keys, key)
+		mu.Unlock()
+		w.Header().Set("Content-Type", "application/json")
+		if key == "bad" {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			w.Write([]byte(`{"error": {"code": 503, "message": "unavailable", "status": "UNAVAILABLE"}}`))
+			return
+		}
+		w.Write([]byte(`{"candidates": [{"content": {"role": "model", "parts": [{"text": "ok"}]}}]}`))
+	}))
+	defer upstream.Close()
+	t.Setenv("GOOGLE_GEMINI_BASE_URL", upstream.URL)
+
+	balancer := NewKeyBalancer([]string{"bad", "good"})
+	gemini, _ := NewGeminiModel(context.Background(), "gemini-test", balancer, NewRateLimiter("gemini", nil))
+	llm, _ := decorate(gemini, "gemini-test", config.ProviderConfig{})
+	ctx, report := WithReport(context.Background())
+
+	text, err := llm.Generate(ctx, "hi", nil, nil)
+	if err != nil || text != "ok" {
+		t.Fatalf("Generate = %q, %v; want ok from the second key", text, err)
+	}
+	if len(keys) != 2 || keys[0] != "bad" || keys[1] != "good" {
+		t.Errorf("keys tried = %q, want [bad good]", keys)
+	}
+	if got := report.Attempts(); got != 2 {
+		t.Errorf("Attempts = %d, want 2", got)
+	}
+}
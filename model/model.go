@@ -3,6 +3,7 @@ package model
 import (
 	"context"
 	"fmt"
+	"log/slog"
 
 	"github.com/sokinpui/synapse.go/internal/config"
 )
@@ -15,33 +16,98 @@ type LLM interface {
 
 type ModelProvider func(cfg *config.Config) (map[string]LLM, error)
 
-var providers []ModelProvider
+type namedProvider struct {
+	name    string
+	provide ModelProvider
+}
+
+var providers []namedProvider
 
-func RegisterProvider(provider ModelProvider) {
-	providers = append(providers, provider)
+// RegisterProvider makes a provider's models available to every Registry
+// created afterwards. The name identifies the provider in configuration,
+// such as per-provider concurrency limits.
+func RegisterProvider(name string, provider ModelProvider) {
+	providers = append(providers, namedProvider{name: name, provide: provider})
 }
 
 type Registry struct {
-	models map[string]LLM
+	models     map[string]LLM
+	providerOf map[string]string
+	breakers   map[string]*circuitBreaker
+	keys       map[string]*KeyBalancer
+	embedders  map[string]Embedder
+}
+
+// keyed is implemented by models that draw from a pool of API keys.
+type keyed interface {
+	Keys() *KeyBalancer
+}
+
+// ProviderStatus summarizes a provider's models and API keys.
+type ProviderStatus struct {
+	Models     int `json:"models"`
+	Keys       int `json:"keys"`
+	UsableKeys int `json:"usable_keys"`
 }
 
 func New(cfg *config.Config) (*Registry, error) {
 	allModels := make(map[string]LLM)
+	providerOf := make(map[string]string)
+	breakers := make(map[string]*circuitBreaker)
+	keys := make(map[string]*KeyBalancer)
 	for _, provider := range providers {
-		providerModels, err := provider(cfg)
+		providerModels, err := provider.provide(cfg)
 		if err != nil {
-			return nil, fmt.Errorf("failed to initialize a model provider: %w", err)
+			return nil, fmt.Errorf("failed to initialize model provider '%s': %w", provider.name, err)
 		}
 		for name, model := range providerModels {
 			if _, exists := allModels[name]; exists {
 				// Handle potential model name collisions
-				fmt.Printf("Warning: Model '%s' is being overwritten by a new provider.\n", name)
+				slog.Warn("Model is being overwritten by a new provider", "model", name, "provider", provider.name)
+			}
+			if k, ok := model.(keyed); ok {
+				keys[provider.name] = k.Keys()
+			}
+
+			model, breaker := decorate(model, name, cfg.Models.Provider(provider.name))
+			if breaker != nil {
+				breakers[name] = breaker
 			}
 			allModels[name] = model
+			providerOf[name] = provider.name
+		}
+	}
+
+	for name, breaker := range breakers {
+		if breaker.policy.Fallback == "" {
+			continue
 		}
+		fallback, ok := allModels[breaker.policy.Fallback]
+		if !ok {
+			return nil, fmt.Errorf("%w: fallback %s of model %s", ErrModelNotFound, breaker.policy.Fallback, name)
+		}
+		breaker.fallback = fallback
+	}
+
+	embedders, err := newEmbedders(cfg, keys)
+	if err != nil {
+		return nil, err
 	}
 
-	return &Registry{models: allModels}, nil
+	return &Registry{models: allModels, providerOf: providerOf, breakers: breakers, keys: keys, embedders: embedders}, nil
+}
+
+// decorate wraps a provider's model with the resilience layers configured
+// for it, and returns its circuit breaker if it has one.
+func decorate(llm LLM, modelCode string, pc config.ProviderConfig) (LLM, *circuitBreaker) {
+	retry, _ := config.ForModel(pc.Retry, modelCode)
+	llm = withRetry(llm, modelCode, retry)
+	policy, ok := config.ForModel(pc.CircuitBreaker, modelCode)
+	if !ok || policy.FailureThreshold <= 0 {
+		return llm, nil
+	}
+	breaker := newCircuitBreaker(llm, modelCode, policy)
+	return breaker, breaker
 }
 
 func (r *Registry) GetModel(modelCode string) (LLM, error) {
@@ -52,6 +118,44 @@ func (r *Registry) GetModel(modelCode string) (LLM, error) {
 	return model, nil
 }
 
+// Circuits returns the state of every model's circuit breaker, keyed by
+// model code. Models without a breaker are left out.
+func (r *Registry) Circuits() map[string]CircuitStatus {
+	circuits := make(map[string]CircuitStatus, len(r.breakers))
+	for name, breaker := range r.breakers {
+		circuits[name] = breaker.status()
+	}
+	return circuits
+}
+
+// Providers returns the status of every provider with registered models,
+// chat or embedding, keyed by provider name.
+func (r *Registry) Providers() map[string]ProviderStatus {
+	statuses := make(map[string]ProviderStatus)
+	for _, provider := range r.providerOf {
+		status := statuses[provider]
+		status.Models++
+		if balancer, ok := r.keys[provider]; ok {
+			status.Keys = balancer.KeyCount()
+			status.UsableKeys = balancer.UsableKeyCount()
+		}
+		statuses[provider] = status
+	}
+	// Providers serving only embedding models.
+	for provider, balancer := range r.keys {
+		if _, ok := statuses[provider]; !ok {
+			statuses[provider] = ProviderStatus{Keys: balancer.KeyCount(), UsableKeys: balancer.UsableKeyCount()}
+		}
+	}
+	return statuses
+}
+
+// ProviderOf returns the name of the provider serving modelCode, or an empty
+// string if the model is not registered.
+func (r *Registry) ProviderOf(modelCode string) string {
+	return r.providerOf[modelCode]
+}
+
 func (r *Registry) ListModels() []string {
 	keys := make([]string, 0, len(r.models))
 	for k := range r.models {
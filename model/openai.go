@@ -0,0 +1,208 @@
+package model
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strings"
+
+	"github.com/sokinpui/synapse.go/internal/config"
+	"github.com/sokinpui/synapse.go/internal/logging"
+	"github.com/sokinpui/synapse.go/internal/metrics"
+	"github.com/sokinpui/synapse.go/internal/tracing"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+const defaultOpenAIBaseURL = "https://api.openai.com/v1"
+
+func init() {
+	RegisterEmbedderProvider("openai", func(cfg *config.Config) (map[string]Embedder, error) {
+		return newOpenAIEmbedders(cfg, "openai", defaultOpenAIBaseURL, "OPENAI_API_KEYS", "OPENAI_API_KEY"), nil
+	})
+}
+
+// newOpenAIEmbedders creates the embedding models of a provider with an
+// OpenAI-compatible embeddings endpoint.
+func newOpenAIEmbedders(cfg *config.Config, provider, defaultBaseURL string, keyVars ...string) map[string]Embedder {
+	pc := cfg.Models.Provider(provider)
+	if len(pc.EmbeddingCodes) == 0 {
+		return nil
+	}
+
+	baseURL := pc.BaseURL
+	if baseURL == "" {
+		baseURL = defaultBaseURL
+	}
+	balancer, limiter := sharedKeys(cfg, provider, keyVars...)
+	slog.Info("OpenAI-compatible embedding provider initialized", "provider", provider, "base_url", baseURL, "keys", balancer.KeyCount())
+
+	embedders := make(map[string]Embedder, len(pc.EmbeddingCodes))
+	for _, code := range pc.EmbeddingCodes {
+		embedders[code] = &OpenAIEmbedder{
+			provider: provider,
+			baseURL:  strings.TrimSuffix(baseURL, "/"),
+			model:    code,
+			balancer: balancer,
+			limiter:  limiter,
+		}
+	}
+	return embedders
+}
+
+// OpenAIEmbedder embeds texts with an OpenAI-compatible /embeddings API.
+type OpenAIEmbedder struct {
+	provider string
+	baseURL  string
+	model    string
+	balancer *KeyBalancer
+	limiter  *RateLimiter
+}
+
+type openAIEmbeddingRequest struct {
+	Model          string   `json:"model"`
+	Input          []string `json:"input"`
+	Dimensions     int32    `json:"dimensions,omitempty"`
+	EncodingFormat string   `json:"encoding_format"`
+}
+
+type openAIEmbeddingResponse struct {
+	Data []struct {
+		Embedding []float32 `json:"embedding"`
+		Index     int       `json:"index"`
+	} `json:"data"`
+	Usage struct {
+		PromptTokens int `json:"prompt_tokens"`
+	} `json:"usage"`
+}
+
+// Keys returns the balancer of the API keys this model draws from.
+func (m *OpenAIEmbedder) Keys() *KeyBalancer {
+	return m.balancer
+}
+
+func (m *OpenAIEmbedder) Embed(ctx context.Context, inputs []string, opts *EmbedOptions) (*Embeddings, error) {
+	if m.balancer.KeyCount() == 0 {
+		return nil, fmt.Errorf("%w: API key is required for %s", ErrConfiguration, m.provider)
+	}
+
+	req := openAIEmbeddingRequest{Model: m.model, Input: inputs, EncodingFormat: "float"}
+	if opts != nil {
+		req.Dimensions = opts.Dimensions
+	}
+	body, err := json.Marshal(req)
+	if err != nil {
+		return nil, err
+	}
+	tokens := 0
+	for _, input := range inputs {
+		tokens += approxTokens(input)
+	}
+
+	var lastErr error
+	for i := 0; i < m.balancer.KeyCount(); i++ {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+
+		apiKey, keyIdx, err := m.limiter.PickKey(ctx, m.balancer, m.model, tokens)
+		if err != nil {
+			return nil, err
+		}
+		keyCtx := logging.With(ctx, slog.Int("key_index", keyIdx))
+		slog.DebugContext(keyCtx, "Attempting embedding", "model", m.model, logging.Key(apiKey))
+
+		resp, err := m.embedWithKey(keyCtx, apiKey, keyIdx, body)
+		if err != nil {
+			if errors.Is(err, context.Canceled) {
+				return nil, err
+			}
+			lastErr = fmt.Errorf("%w: %w", ErrGeneration, err)
+			if requestFault(err) {
+				return nil, lastErr
+			}
+			m.balancer.ReportFailure(keyIdx, err)
+			metrics.KeyFailures.WithLabelValues(m.provider).Inc()
+			slog.WarnContext(keyCtx, "API key failed, trying the next one", "provider", m.provider, "model", m.model, "error", err)
+			continue
+		}
+		m.balancer.ReportSuccess(keyIdx)
+
+		out := &Embeddings{Vectors: make([][]float32, len(inputs)), InputTokens: resp.Usage.PromptTokens}
+		for _, d := range resp.Data {
+			if d.Index < 0 || d.Index >= len(inputs) {
+				return nil, fmt.Errorf("%w: embedding index %d out of range", ErrGeneration, d.Index)
+			}
+			out.Vectors[d.Index] = d.Embedding
+		}
+		for i, v := range out.Vectors {
+			if v == nil {
+				return nil, fmt.Errorf("%w: no embedding for input %d", ErrGeneration, i)
+			}
+		}
+		if out.InputTokens == 0 {
+			out.InputTokens = tokens
+		}
+		reportFrom(ctx).addUsage(m.provider, m.model, keyIdx, Usage{InputTokens: out.InputTokens})
+		return out, nil
+	}
+
+	return nil, fmt.Errorf("all API keys failed: %w", lastErr)
+}
+
+// embedWithKey makes one upstream call with the given API key.
+func (m *OpenAIEmbedder) embedWithKey(ctx context.Context, apiKey string, keyIdx int, body []byte) (resp *openAIEmbeddingResponse, err error) {
+	ctx, span := tracing.Start(ctx, m.provider+".CreateEmbeddings", attribute.String("model", m.model), attribute.Int("key_index", keyIdx))
+	defer func() { tracing.End(span, err) }()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, m.baseURL+"/embeddings", bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+apiKey)
+
+	httpResp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer httpResp.Body.Close()
+
+	data, err := io.ReadAll(httpResp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if httpResp.StatusCode != http.StatusOK {
+		var apiErr struct {
+			Error struct {
+				Message string `json:"message"`
+			} `json:"error"`
+		}
+		json.Unmarshal(data, &apiErr)
+		return nil, &statusError{provider: m.provider, status: httpResp.StatusCode, message: apiErr.Error.Message}
+	}
+
+	resp = &openAIEmbeddingResponse{}
+	if err := json.Unmarshal(data, resp); err != nil {
+		return nil, fmt.Errorf("invalid embeddings response: %w", err)
+	}
+	return resp, nil
+}
+
+// statusError is an error response from an OpenAI-compatible API.
+type statusError struct {
+	provider string
+	status   int
+	message  string
+}
+
+func (e *statusError) Error() string {
+	if e.message == "" {
+		return fmt.Sprintf("%s API error (status %d)", e.provider, e.status)
+	}
+	return fmt.Sprintf("%s API error (status %d): %s", e.provider, e.status, e.message)
+}
@@ -2,45 +2,35 @@ package model
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"io"
-	"log"
-	"os"
-	"strings"
+	"log/slog"
 
 	openrouter "github.com/revrost/go-openrouter"
 	"github.com/sokinpui/synapse.go/internal/config"
+	"github.com/sokinpui/synapse.go/internal/logging"
+	"github.com/sokinpui/synapse.go/internal/metrics"
+	"github.com/sokinpui/synapse.go/internal/tracing"
+	"go.opentelemetry.io/otel/attribute"
 )
 
 func init() {
-	RegisterProvider(newOpenRouterProvider)
+	RegisterProvider("openrouter", newOpenRouterProvider)
+	RegisterEmbedderProvider("openrouter", func(cfg *config.Config) (map[string]Embedder, error) {
+		return newOpenAIEmbedders(cfg, "openrouter", "https://openrouter.ai/api/v1", "OPENROUTER_API_KEYS", "OPENROUTER_API_KEY"), nil
+	})
 }
 
 func newOpenRouterProvider(cfg *config.Config) (map[string]LLM, error) {
-	apiKeysVar := os.Getenv("OPENROUTER_API_KEYS")
-	if apiKeysVar == "" {
-		apiKeysVar = os.Getenv("OPENROUTER_API_KEY")
-	}
-
-	rawKeys := strings.FieldsFunc(apiKeysVar, func(r rune) bool {
-		return r == ',' || r == '\n' || r == '\r' || r == '\t' || r == ' ' || r == '\\'
-	})
-
-	var apiKeys []string
-	for _, k := range rawKeys {
-		if trimmed := strings.TrimSpace(k); trimmed != "" {
-			apiKeys = append(apiKeys, trimmed)
-		}
-	}
-
-	log.Printf("OpenRouter provider initialized with %d API keys", len(apiKeys))
+	balancer, limiter := sharedKeys(cfg, "openrouter", "OPENROUTER_API_KEYS", "OPENROUTER_API_KEY")
+	slog.Info("OpenRouter provider initialized", "keys", balancer.KeyCount())
 
 	models := make(map[string]LLM)
 	ctx := context.Background()
-	balancer := NewKeyBalancer(apiKeys)
 
 	for _, code := range cfg.Models.OpenRouter.Codes {
-		model, err := NewOpenRouterModel(ctx, code, balancer)
+		model, err := NewOpenRouterModel(ctx, code, balancer, limiter)
 		if err != nil {
 			return nil, fmt.Errorf("failed to create OpenRouter model '%s': %w", code, err)
 		}
@@ -52,30 +42,51 @@ func newOpenRouterProvider(cfg *config.Config) (map[string]LLM, error) {
 type OpenRouterModel struct {
 	model    string
 	balancer *KeyBalancer
+	limiter  *RateLimiter
+	baseURL  string // the OpenRouter API if empty
 }
 
-func NewOpenRouterModel(ctx context.Context, modelCode string, balancer *KeyBalancer) (*OpenRouterModel, error) {
+func NewOpenRouterModel(ctx context.Context, modelCode string, balancer *KeyBalancer, limiter *RateLimiter) (*OpenRouterModel, error) {
 	return &OpenRouterModel{
 		model:    modelCode,
 		balancer: balancer,
+		limiter:  limiter,
 	}, nil
 }
 
+// Keys returns the balancer of the API keys this model draws from.
+func (orm *OpenRouterModel) Keys() *KeyBalancer {
+	return orm.balancer
+}
+
+func (orm *OpenRouterModel) client(apiKey string) *openrouter.Client {
+	config := openrouter.DefaultConfig(apiKey)
+	if orm.baseURL != "" {
+		config.BaseURL = orm.baseURL
+	}
+	return openrouter.NewClientWithConfig(*config)
+}
+
 func (orm *OpenRouterModel) Generate(ctx context.Context, prompt string, images [][]byte, config *Config) (string, error) {
 	if orm.balancer.KeyCount() == 0 {
 		return "", fmt.Errorf("%w: API key is required for OpenRouter", ErrConfiguration)
 	}
 
-	apiKey, keyIdx := orm.balancer.PickKey()
-	log.Printf("[%s] Attempting generation with API key #%d", orm.model, keyIdx)
+	apiKey, keyIdx, err := orm.limiter.PickKey(ctx, orm.balancer, orm.model, approxTokens(prompt))
+	if err != nil {
+		return "", err
+	}
+	ctx = logging.With(ctx, slog.Int("key_index", keyIdx))
+	slog.DebugContext(ctx, "Attempting generation", "model", orm.model, logging.Key(apiKey))
 
 	/* TODO: don't support Image yet */
-	client := openrouter.NewClient(apiKey)
+	client := orm.client(apiKey)
 	req := openrouter.ChatCompletionRequest{
 		Model: orm.model,
 		Messages: []openrouter.ChatCompletionMessage{
 			openrouter.UserMessage(prompt),
 		},
+		Usage: &openrouter.IncludeUsage{Include: true},
 	}
 
 	if config != nil {
@@ -91,14 +102,24 @@ func (orm *OpenRouterModel) Generate(ctx context.Context, prompt string, images
 		if config.OutputLength > 0 {
 			req.MaxCompletionTokens = int(config.OutputLength)
 		}
+		req.Stop = config.StopSequences
 	}
 
-	response, err := client.CreateChatCompletion(ctx, req)
+	callCtx, span := tracing.Start(ctx, "openrouter.CreateChatCompletion", attribute.String("model", orm.model), attribute.Int("key_index", keyIdx))
+	response, err := client.CreateChatCompletion(callCtx, req)
+	tracing.End(span, err)
 
 	if err != nil {
+		orm.balancer.ReportFailure(keyIdx, err)
+		metrics.KeyFailures.WithLabelValues("openrouter").Inc()
 		return "", fmt.Errorf("OpenRouter API error: %w", err)
 	}
+	orm.balancer.ReportSuccess(keyIdx)
+	reportFrom(ctx).addUsage("openrouter", orm.model, keyIdx, openRouterUsage(response.Usage))
 
+	if len(response.Choices) == 0 {
+		return "", errors.New("OpenRouter API error: response has no choices")
+	}
 	return response.Choices[0].Message.Content.Text, nil
 }
 
@@ -115,16 +136,22 @@ func (orm *OpenRouterModel) GenerateStream(ctx context.Context, prompt string, i
 			return
 		}
 
-		apiKey, keyIdx := orm.balancer.PickKey()
-		log.Printf("[%s] Attempting stream generation with API key #%d", orm.model, keyIdx)
+		apiKey, keyIdx, err := orm.limiter.PickKey(ctx, orm.balancer, orm.model, approxTokens(prompt))
+		if err != nil {
+			errCh <- err
+			return
+		}
+		ctx := logging.With(ctx, slog.Int("key_index", keyIdx))
+		slog.DebugContext(ctx, "Attempting stream generation", "model", orm.model, logging.Key(apiKey))
 
-		client := openrouter.NewClient(apiKey)
+		client := orm.client(apiKey)
 		req := openrouter.ChatCompletionRequest{
 			Model: orm.model,
 			Messages: []openrouter.ChatCompletionMessage{
 				openrouter.UserMessage(prompt),
 			},
 			Stream: true,
+			Usage:  &openrouter.IncludeUsage{Include: true},
 		}
 
 		if config != nil {
@@ -140,42 +167,89 @@ func (orm *OpenRouterModel) GenerateStream(ctx context.Context, prompt string, i
 			if config.OutputLength > 0 {
 				req.MaxCompletionTokens = int(config.OutputLength)
 			}
+			req.Stop = config.StopSequences
 		}
-		stream, err := client.CreateChatCompletionStream(ctx, req)
+		callCtx, span := tracing.Start(ctx, "openrouter.CreateChatCompletionStream", attribute.String("model", orm.model), attribute.Int("key_index", keyIdx))
+		defer span.End()
+		stream, err := client.CreateChatCompletionStream(callCtx, req)
 
 		if err != nil && err != io.EOF {
+			tracing.End(span, err)
+			orm.balancer.ReportFailure(keyIdx, err)
+			metrics.KeyFailures.WithLabelValues("openrouter").Inc()
 			errCh <- fmt.Errorf("OpenRouter API error: %w", err)
 			return
 		}
-
 		defer stream.Close()
 
+		// The client ends a stream with io.EOF whatever stopped it, so a
+		// stream only succeeded if a chunk said why the response finished.
+		var finish openrouter.FinishReason
 		for {
 			response, err := stream.Recv()
+			if ctx.Err() != nil {
+				errCh <- ctx.Err()
+				return
+			}
+			if errors.Is(err, io.EOF) {
+				if err = openRouterStreamEnd(finish); err == nil {
+					orm.balancer.ReportSuccess(keyIdx)
+					return
+				}
+			}
 			if err != nil {
-				break
+				tracing.End(span, err)
+				orm.balancer.ReportFailure(keyIdx, err)
+				metrics.KeyFailures.WithLabelValues("openrouter").Inc()
+				errCh <- fmt.Errorf("OpenRouter stream error: %w", err)
+				return
+			}
+			if response.Usage != nil {
+				reportFrom(ctx).addUsage("openrouter", orm.model, keyIdx, openRouterUsage(response.Usage))
+			}
+			// The final usage chunk carries no choices.
+			if len(response.Choices) == 0 {
+				continue
+			}
+			if reason := response.Choices[0].FinishReason; reason != "" && reason != openrouter.FinishReasonNull {
+				finish = reason
+			}
+			select {
+			case outCh <- response.Choices[0].Delta.Content:
+			case <-ctx.Done():
+				errCh <- ctx.Err()
+				return
 			}
-			outCh <- response.Choices[0].Delta.Content
 		}
 	}()
 
 	return outCh, errCh
 }
 
-func (orm *OpenRouterModel) CountTokens(prompt string) (int, error) {
-	/* 1 English character ≈ 0.3 token.
-	1 Chinese character ≈ 0.6 token. */
-	// loop via each char
-	var tokenConnt float32 = 0.0
-	for _, r := range prompt {
-		if r <= 127 {
-			// English char
-			tokenConnt += 0.3
-		} else {
-			// Non-English char
-			tokenConnt += 0.6
-		}
+// openRouterStreamEnd returns the error a stream that ended with the given
+// finish reason failed with, if any.
+func openRouterStreamEnd(finish openrouter.FinishReason) error {
+	switch finish {
+	case "":
+		return fmt.Errorf("stream ended before the response finished: %w", io.ErrUnexpectedEOF)
+	case "error":
+		return errors.New("upstream ended the stream with an error")
+	}
+	return nil
+}
+
+func openRouterUsage(u *openrouter.Usage) Usage {
+	if u == nil {
+		return Usage{}
+	}
+	return Usage{
+		InputTokens:  u.PromptTokens,
+		OutputTokens: u.CompletionTokens,
+		CachedTokens: u.PromptTokenDetails.CachedTokens,
+		Cost:         u.Cost,
 	}
-	return int(tokenConnt), nil
+}
 
+func (orm *OpenRouterModel) CountTokens(prompt string) (int, error) {
+	return approxTokens(prompt), nil
 }
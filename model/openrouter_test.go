@@ -0,0 +1,100 @@
+package model
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// openRouterServer answers chat completions with body, as a stream if the
+// request asks for one.
+func openRouterServer(t *testing.T, body string) *OpenRouterModel {
+	t.Helper()
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		req, _ := io.ReadAll(r.Body)
+		if strings.Contains(string(req), `"stream":true`) {
+			w.Header().Set("Content-Type", "text/event-stream")
+		} else {
+			w.Header().Set("Content-Type", "application/json")
+		}
+		io.WriteString(w, body)
+	}))
+	t.Cleanup(upstream.Close)
+
+	orm, _ := NewOpenRouterModel(context.Background(), "test/model", NewKeyBalancer([]string{"k"}), NewRateLimiter("openrouter", nil))
+	orm.baseURL = upstream.URL
+	return orm
+}
+
+func streamChunk(content, finish string) string {
+	reason := "null"
+	if finish != "" {
+		reason = fmt.Sprintf("%q", finish)
+	}
+	return fmt.Sprintf("data: {\"choices\": [{\"delta\": {\"content\": %q}, \"finish_reason\": %s}]}\n\n", content, reason)
+}
+
+func collectStream(chunks <-chan string, errs <-chan error) (string, error) {
+	var sb strings.Builder
+	for c := range chunks {
+		sb.WriteString(c)
+	}
+	return sb.String(), <-errs
+}
+
+func TestOpenRouterStream(t *testing.T) {
+	tests := []struct {
+		name      string
+		body      string
+		wantText  string
+		wantClass ErrorClass // empty for success
+	}{
+		{
+			name:     "finished",
+			body:     streamChunk("hel", "") + streamChunk("lo", "stop") + "data: [DONE]\n\n",
+			wantText: "hello",
+		},
+		{
+			name:      "cut off",
+			body:      streamChunk("hel", ""),
+			wantText:  "hel",
+			wantClass: ErrorClassNetwork,
+		},
+		{
+			name:      "upstream error",
+			body:      streamChunk("hel", "") + streamChunk("", "error") + "data: [DONE]\n\n",
+			wantText:  "hel",
+			wantClass: ErrorClassOther,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			orm := openRouterServer(t, tt.body)
+
+			text, err := collectStream(orm.GenerateStream(context.Background(), "hi", nil, nil))
+			if text != tt.wantText {
+				t.Errorf("text = %q, want %q", text, tt.wantText)
+			}
+			switch {
+			case tt.wantClass == "" && err != nil:
+				t.Errorf("stream failed: %v", err)
+			case tt.wantClass != "" && err == nil:
+				t.Error("stream succeeded, want an error")
+			case err != nil && ClassifyError(err) != tt.wantClass:
+				t.Errorf("error class = %s, want %s (%v)", ClassifyError(err), tt.wantClass, err)
+			}
+		})
+	}
+}
+
+func TestOpenRouterGenerateWithoutChoices(t *testing.T) {
+	orm := openRouterServer(t, `{"choices": []}`)
+
+	if _, err := orm.Generate(context.Background(), "hi", nil, nil); err == nil {
+		t.Fatalf("Generate = %v, want an error for the missing choices", err)
+	}
+}
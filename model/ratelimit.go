@@ -0,0 +1,244 @@
+package model
+
+import (
+	"context"
+	"log/slog"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/sokinpui/synapse.go/internal/config"
+	"github.com/sokinpui/synapse.go/internal/metrics"
+)
+
+// tokenBucket refills continuously at rate tokens per second up to capacity.
+type tokenBucket struct {
+	capacity float64
+	tokens   float64
+	rate     float64
+	last     time.Time
+}
+
+func newTokenBucket(perMinute int, now time.Time) *tokenBucket {
+	capacity := float64(perMinute)
+	return &tokenBucket{
+		capacity: capacity,
+		tokens:   capacity,
+		rate:     capacity / 60,
+		last:     now,
+	}
+}
+
+func (b *tokenBucket) refill(now time.Time) {
+	b.tokens += now.Sub(b.last).Seconds() * b.rate
+	if b.tokens > b.capacity {
+		b.tokens = b.capacity
+	}
+	b.last = now
+}
+
+// waitFor returns how long until n tokens are available, zero if they are now.
+func (b *tokenBucket) waitFor(n float64) time.Duration {
+	if n > b.capacity {
+		n = b.capacity
+	}
+	if b.tokens >= n {
+		return 0
+	}
+	return time.Duration((n - b.tokens) / b.rate * float64(time.Second))
+}
+
+func (b *tokenBucket) take(n float64) {
+	if n > b.capacity {
+		n = b.capacity
+	}
+	b.tokens -= n
+}
+
+// keyBudget holds the request and token buckets of one key for one model.
+type keyBudget struct {
+	requests *tokenBucket
+	tokens   *tokenBucket
+}
+
+type rateKey struct {
+	provider string
+	key      int
+	model    string
+}
+
+// WaitStat summarizes the time callers spent waiting for rate budget.
+type WaitStat struct {
+	Provider string  `json:"provider"`
+	Model    string  `json:"model"`
+	Count    int     `json:"count"`
+	Seconds  float64 `json:"seconds"`
+}
+
+var (
+	waitStatsMu sync.Mutex
+	waitStats   = make(map[rateKey]*WaitStat)
+)
+
+// RateLimitWaits returns the time spent waiting for rate budget, per model.
+func RateLimitWaits() []WaitStat {
+	waitStatsMu.Lock()
+	defer waitStatsMu.Unlock()
+
+	stats := make([]WaitStat, 0, len(waitStats))
+	for _, s := range waitStats {
+		stats = append(stats, *s)
+	}
+	sort.Slice(stats, func(i, j int) bool {
+		if stats[i].Provider != stats[j].Provider {
+			return stats[i].Provider < stats[j].Provider
+		}
+		return stats[i].Model < stats[j].Model
+	})
+	return stats
+}
+
+func recordWait(provider, model string, d time.Duration) {
+	metrics.RateLimitWait.WithLabelValues(provider, model).Observe(d.Seconds())
+
+	waitStatsMu.Lock()
+	defer waitStatsMu.Unlock()
+
+	k := rateKey{provider: provider, model: model}
+	s, ok := waitStats[k]
+	if !ok {
+		s = &WaitStat{Provider: provider, Model: model}
+		waitStats[k] = s
+	}
+	s.Count++
+	s.Seconds += d.Seconds()
+}
+
+// RateLimiter enforces per-key, per-model RPM and TPM budgets for a provider.
+// A nil *RateLimiter imposes no limits.
+type RateLimiter struct {
+	provider string
+	limits   map[string]config.RateLimit
+
+	// The clock, replaced in tests: now tells the time, and timer returns a
+	// channel that fires after d and a function that stops it.
+	now   func() time.Time
+	timer func(d time.Duration) (<-chan time.Time, func() bool)
+
+	mu      sync.Mutex
+	budgets map[rateKey]*keyBudget
+}
+
+func NewRateLimiter(provider string, limits map[string]config.RateLimit) *RateLimiter {
+	if len(limits) == 0 {
+		return nil
+	}
+	return &RateLimiter{
+		provider: provider,
+		limits:   limits,
+		budgets:  make(map[rateKey]*keyBudget),
+		now:      time.Now,
+		timer: func(d time.Duration) (<-chan time.Time, func() bool) {
+			t := time.NewTimer(d)
+			return t.C, t.Stop
+		},
+	}
+}
+
+// tryReserve takes budget for one request of the given size on key if it is
+// available, and otherwise reports how long until it would be.
+func (l *RateLimiter) tryReserve(key int, model string, tokens int) (bool, time.Duration) {
+	limit, ok := config.ForModel(l.limits, model)
+	if !ok || (limit.RPM <= 0 && limit.TPM <= 0) {
+		return true, 0
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	k := rateKey{provider: l.provider, key: key, model: model}
+	budget, ok := l.budgets[k]
+	if !ok {
+		budget = &keyBudget{}
+		if limit.RPM > 0 {
+			budget.requests = newTokenBucket(limit.RPM, now)
+		}
+		if limit.TPM > 0 {
+			budget.tokens = newTokenBucket(limit.TPM, now)
+		}
+		l.budgets[k] = budget
+	}
+
+	var wait time.Duration
+	if budget.requests != nil {
+		budget.requests.refill(now)
+		wait = max(wait, budget.requests.waitFor(1))
+	}
+	if budget.tokens != nil {
+		budget.tokens.refill(now)
+		wait = max(wait, budget.tokens.waitFor(float64(tokens)))
+	}
+	if wait > 0 {
+		return false, wait
+	}
+
+	if budget.requests != nil {
+		budget.requests.take(1)
+	}
+	if budget.tokens != nil {
+		budget.tokens.take(float64(tokens))
+	}
+	return true, 0
+}
+
+// PickKey returns the next key from balancer that has budget for a request of
+// the given size. Keys without budget are skipped in favor of ones that have
+// it; only when every key is exhausted does PickKey wait for the earliest one
+// to refill.
+func (l *RateLimiter) PickKey(ctx context.Context, balancer *KeyBalancer, model string, tokens int) (string, int, error) {
+	if l == nil || balancer.KeyCount() == 0 {
+		key, idx := balancer.PickKey()
+		return key, idx, nil
+	}
+
+	for {
+		wait := time.Duration(-1)
+		for i := 0; i < balancer.KeyCount(); i++ {
+			key, idx := balancer.PickKey()
+			ok, keyWait := l.tryReserve(idx, model, tokens)
+			if ok {
+				return key, idx, nil
+			}
+			if wait < 0 || keyWait < wait {
+				wait = keyWait
+			}
+		}
+
+		slog.WarnContext(ctx, "All API keys are rate limited, waiting", "provider", l.provider, "model", model, "wait", wait.Round(time.Millisecond))
+		start := l.now()
+		fired, stop := l.timer(wait)
+		select {
+		case <-fired:
+			recordWait(l.provider, model, l.now().Sub(start))
+		case <-ctx.Done():
+			stop()
+			recordWait(l.provider, model, l.now().Sub(start))
+			return "", -1, ctx.Err()
+		}
+	}
+}
+
+// approxTokens estimates the token count of s without a tokenizer.
+// 1 English character ≈ 0.3 token, 1 Chinese character ≈ 0.6 token.
+func approxTokens(s string) int {
+	var tokenCount float32 = 0.0
+	for _, r := range s {
+		if r <= 127 {
+			tokenCount += 0.3
+		} else {
+			tokenCount += 0.6
+		}
+	}
+	return int(tokenCount)
+}
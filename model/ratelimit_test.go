@@ -0,0 +1,162 @@
+package model
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/sokinpui/synapse.go/internal/config"
+)
+
+// fakeClock stands still until advanced. Its timers fire at once, moving
+// the clock to their deadline, unless it blocks, in which case they never
+// fire.
+type fakeClock struct {
+	now   time.Time
+	block bool
+	waits []time.Duration
+}
+
+func (c *fakeClock) timer(d time.Duration) (<-chan time.Time, func() bool) {
+	c.waits = append(c.waits, d)
+	if c.block {
+		return nil, func() bool { return true }
+	}
+	c.now = c.now.Add(d)
+	fired := make(chan time.Time, 1)
+	fired <- c.now
+	return fired, func() bool { return false }
+}
+
+func fakeLimiter(limit config.RateLimit) (*RateLimiter, *fakeClock) {
+	clock := &fakeClock{now: time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)}
+	l := NewRateLimiter("test", map[string]config.RateLimit{"m": limit})
+	l.now = func() time.Time { return clock.now }
+	l.timer = clock.timer
+	return l, clock
+}
+
+func TestTokenBucket(t *testing.T) {
+	start := time.Now()
+	tests := []struct {
+		name     string
+		take     float64
+		after    time.Duration
+		want     float64 // tokens then available
+		wantWait time.Duration
+		n        float64 // tokens asked for
+	}{
+		{"full at start", 0, 0, 60, 0, 60},
+		{"refills at the per-minute rate", 60, 10 * time.Second, 10, 0, 10},
+		{"waits for the rest", 60, 10 * time.Second, 10, 5 * time.Second, 15},
+		{"never over capacity", 0, time.Hour, 60, 0, 60},
+		{"asks capped at capacity", 0, 0, 60, 0, 100},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := newTokenBucket(60, start)
+			b.take(tt.take)
+			b.refill(start.Add(tt.after))
+			if b.tokens != tt.want {
+				t.Errorf("tokens = %v, want %v", b.tokens, tt.want)
+			}
+			if got := b.waitFor(tt.n); got != tt.wantWait {
+				t.Errorf("waitFor(%v) = %v, want %v", tt.n, got, tt.wantWait)
+			}
+		})
+	}
+}
+
+func TestRateLimiterPickKey(t *testing.T) {
+	type pick struct {
+		advance  time.Duration // before the pick
+		tokens   int
+		wantKey  int
+		wantWait time.Duration // waited for budget, if any
+	}
+	tests := []struct {
+		name  string
+		keys  int
+		limit config.RateLimit
+		picks []pick
+	}{
+		{"spreads requests over keys", 2, config.RateLimit{RPM: 1}, []pick{
+			{wantKey: 0}, {wantKey: 1},
+		}},
+		{"skips a key without token budget", 2, config.RateLimit{TPM: 100}, []pick{
+			{tokens: 80, wantKey: 0},
+			{tokens: 10, wantKey: 1},
+			{tokens: 30, wantKey: 1}, // key 0 has only 20 tokens left
+		}},
+		{"waits when every key is exhausted", 2, config.RateLimit{RPM: 1}, []pick{
+			{wantKey: 0}, {wantKey: 1},
+			{wantKey: 0, wantWait: time.Minute},
+		}},
+		{"waits only for the earliest key", 2, config.RateLimit{RPM: 1}, []pick{
+			{wantKey: 0},
+			{advance: 30 * time.Second, wantKey: 1},
+			{wantKey: 0, wantWait: 30 * time.Second},
+		}},
+		{"refills over time", 1, config.RateLimit{RPM: 1}, []pick{
+			{wantKey: 0}, {wantKey: 0, advance: time.Minute},
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l, clock := fakeLimiter(tt.limit)
+			keys := make([]string, tt.keys)
+			for i := range keys {
+				keys[i] = fmt.Sprintf("k%d", i)
+			}
+			balancer := NewKeyBalancer(keys)
+
+			for i, p := range tt.picks {
+				clock.now = clock.now.Add(p.advance)
+				clock.waits = nil
+				_, idx, err := l.PickKey(context.Background(), balancer, "m", p.tokens)
+				if err != nil {
+					t.Fatalf("pick %d: %v", i, err)
+				}
+				if idx != p.wantKey {
+					t.Errorf("pick %d: key %d, want %d", i, idx, p.wantKey)
+				}
+				var waited time.Duration
+				for _, w := range clock.waits {
+					waited += w
+				}
+				if waited != p.wantWait {
+					t.Errorf("pick %d: waited %v, want %v", i, waited, p.wantWait)
+				}
+			}
+		})
+	}
+}
+
+func TestRateLimiterPickKeyCanceledWhileWaiting(t *testing.T) {
+	l, clock := fakeLimiter(config.RateLimit{RPM: 1})
+	balancer := NewKeyBalancer([]string{"k"})
+	if _, _, err := l.PickKey(context.Background(), balancer, "m", 0); err != nil {
+		t.Fatal(err)
+	}
+
+	clock.block = true
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, idx, err := l.PickKey(ctx, balancer, "m", 0)
+	if !errors.Is(err, context.Canceled) || idx != -1 {
+		t.Errorf("PickKey = %d, %v; want -1 and context.Canceled", idx, err)
+	}
+	if len(clock.waits) != 1 {
+		t.Errorf("waited %d times, want 1", len(clock.waits))
+	}
+}
+
+func TestRateLimiterWithoutLimits(t *testing.T) {
+	l := NewRateLimiter("test", nil)
+	_, idx, err := l.PickKey(context.Background(), NewKeyBalancer([]string{"k"}), "m", 1000)
+	if err != nil || idx != 0 {
+		t.Errorf("PickKey = %d, %v; want key 0 without limits", idx, err)
+	}
+}
@@ -0,0 +1,102 @@
+package model
+
+import (
+	"context"
+	"sync"
+)
+
+// Report collects details about a single generation as it runs, such as the
+// number of upstream attempts and the tokens they used. Attach one to the
+// context passed to an LLM with WithReport and read it once the call returns.
+type Report struct {
+	mu       sync.Mutex
+	attempts int
+	usage    Usage
+	charges  []Charge
+}
+
+// Usage is the token usage reported by upstream. Across several attempts it
+// is the total of all of them. Cost is the price in USD reported by
+// upstream, for providers that report one.
+type Usage struct {
+	InputTokens  int
+	OutputTokens int
+	CachedTokens int
+	Cost         float64
+}
+
+// Charge is the usage of one successful upstream call, attributed to the
+// model that served it and the index of the API key it used.
+type Charge struct {
+	Provider string
+	Model    string
+	Key      int
+	Usage    Usage
+}
+
+type reportKey struct{}
+
+// WithReport returns a context carrying a new Report.
+func WithReport(ctx context.Context) (context.Context, *Report) {
+	r := &Report{}
+	return context.WithValue(ctx, reportKey{}, r), r
+}
+
+// reportFrom returns the Report attached to ctx. The result may be nil, and
+// all Report methods are safe to call on a nil Report.
+func reportFrom(ctx context.Context) *Report {
+	r, _ := ctx.Value(reportKey{}).(*Report)
+	return r
+}
+
+func (r *Report) addAttempt() {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.attempts++
+}
+
+// Attempts returns the number of upstream attempts made so far.
+func (r *Report) Attempts() int {
+	if r == nil {
+		return 0
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.attempts
+}
+
+func (r *Report) addUsage(provider, model string, key int, u Usage) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.usage.InputTokens += u.InputTokens
+	r.usage.OutputTokens += u.OutputTokens
+	r.usage.CachedTokens += u.CachedTokens
+	r.usage.Cost += u.Cost
+	r.charges = append(r.charges, Charge{Provider: provider, Model: model, Key: key, Usage: u})
+}
+
+// Usage returns the token usage reported so far.
+func (r *Report) Usage() Usage {
+	if r == nil {
+		return Usage{}
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.usage
+}
+
+// Charges returns the usage of each successful upstream call so far.
+func (r *Report) Charges() []Charge {
+	if r == nil {
+		return nil
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]Charge(nil), r.charges...)
+}
@@ -0,0 +1,230 @@
+package model
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"math/rand/v2"
+	"net"
+	"slices"
+	"time"
+
+	openrouter "github.com/revrost/go-openrouter"
+	"github.com/sokinpui/synapse.go/internal/config"
+	"google.golang.org/genai"
+)
+
+// ErrorClass groups upstream failures by how they should be handled.
+type ErrorClass string
+
+const (
+	ErrorClassServer    ErrorClass = "server_error"
+	ErrorClassTimeout   ErrorClass = "timeout"
+	ErrorClassRateLimit ErrorClass = "rate_limit"
+	ErrorClassNetwork   ErrorClass = "network"
+	ErrorClassOther     ErrorClass = "other"
+)
+
+// httpStatus returns the HTTP status of an upstream error response wrapped
+// in err, or 0 if there is none.
+func httpStatus(err error) int {
+	var geminiErr genai.APIError
+	var orAPIErr *openrouter.APIError
+	var orReqErr *openrouter.RequestError
+	var statusErr *statusError
+	switch {
+	case errors.As(err, &geminiErr):
+		return geminiErr.Code
+	case errors.As(err, &orAPIErr):
+		return orAPIErr.HTTPStatusCode
+	case errors.As(err, &orReqErr):
+		return orReqErr.HTTPStatusCode
+	case errors.As(err, &statusErr):
+		return statusErr.status
+	}
+	return 0
+}
+
+// ClassifyError reports the class of an error returned by an LLM.
+func ClassifyError(err error) ErrorClass {
+	switch status := httpStatus(err); {
+	case status == 429:
+		return ErrorClassRateLimit
+	case status == 408 || status == 504:
+		return ErrorClassTimeout
+	case status >= 500:
+		return ErrorClassServer
+	}
+
+	if errors.Is(err, context.DeadlineExceeded) {
+		return ErrorClassTimeout
+	}
+	if errors.Is(err, io.ErrUnexpectedEOF) {
+		return ErrorClassNetwork
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) {
+		if netErr.Timeout() {
+			return ErrorClassTimeout
+		}
+		return ErrorClassNetwork
+	}
+	return ErrorClassOther
+}
+
+var defaultRetryOn = []string{
+	string(ErrorClassServer),
+	string(ErrorClassTimeout),
+	string(ErrorClassNetwork),
+}
+
+// retryLLM retries transient failures of the wrapped LLM with jittered
+// exponential backoff, counting every attempt in the context's Report.
+// MaxAttempts bounds the retry rounds only; the API keys a provider fails
+// over to within one round are bounded by its key count.
+type retryLLM struct {
+	LLM
+	model  string
+	policy config.RetryPolicy
+}
+
+func withRetry(llm LLM, modelCode string, policy config.RetryPolicy) LLM {
+	if policy.MaxAttempts < 1 {
+		policy.MaxAttempts = 1
+	}
+	if policy.BaseDelay <= 0 {
+		policy.BaseDelay = 500 * time.Millisecond
+	}
+	if policy.MaxDelay <= 0 {
+		policy.MaxDelay = 10 * time.Second
+	}
+	if len(policy.RetryOn) == 0 {
+		policy.RetryOn = defaultRetryOn
+	}
+	return &retryLLM{LLM: llm, model: modelCode, policy: policy}
+}
+
+func (r *retryLLM) Generate(ctx context.Context, prompt string, images [][]byte, config *Config) (string, error) {
+	report := reportFrom(ctx)
+
+	for attempt := 1; ; attempt++ {
+		report.addAttempt()
+		text, err := r.LLM.Generate(ctx, prompt, images, config)
+		if err == nil {
+			return text, nil
+		}
+		if !r.shouldRetry(ctx, err, attempt) {
+			return "", err
+		}
+		if err := r.wait(ctx, err, attempt); err != nil {
+			return "", err
+		}
+	}
+}
+
+// GenerateStream retries only while nothing has been streamed yet; once a
+// chunk has reached the caller a failure is returned as is.
+func (r *retryLLM) GenerateStream(ctx context.Context, prompt string, images [][]byte, config *Config) (<-chan string, <-chan error) {
+	outCh := make(chan string)
+	errCh := make(chan error, 1)
+
+	go func() {
+		defer close(outCh)
+		defer close(errCh)
+
+		report := reportFrom(ctx)
+		for attempt := 1; ; attempt++ {
+			report.addAttempt()
+			chunks, errs := r.LLM.GenerateStream(ctx, prompt, images, config)
+			sent, err := forwardStream(ctx, outCh, chunks, errs)
+			if err == nil {
+				return
+			}
+			if sent || !r.shouldRetry(ctx, err, attempt) {
+				errCh <- err
+				return
+			}
+			if err := r.wait(ctx, err, attempt); err != nil {
+				errCh <- err
+				return
+			}
+		}
+	}()
+
+	return outCh, errCh
+}
+
+func (r *retryLLM) shouldRetry(ctx context.Context, err error, attempt int) bool {
+	if attempt >= r.policy.MaxAttempts || ctx.Err() != nil {
+		return false
+	}
+	return slices.Contains(r.policy.RetryOn, string(ClassifyError(err)))
+}
+
+func (r *retryLLM) wait(ctx context.Context, err error, attempt int) error {
+	delay := r.backoff(attempt)
+	slog.WarnContext(ctx, "Attempt failed, retrying",
+		"model", r.model, "attempt", attempt, "max_attempts", r.policy.MaxAttempts,
+		"class", ClassifyError(err), "delay", delay.Round(time.Millisecond), "error", err)
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// backoff returns a delay drawn from [d/2, d], where d doubles with every
+// attempt from BaseDelay up to MaxDelay.
+func (r *retryLLM) backoff(attempt int) time.Duration {
+	d := r.policy.BaseDelay << (attempt - 1)
+	if d <= 0 || d > r.policy.MaxDelay {
+		d = r.policy.MaxDelay
+	}
+	return d/2 + rand.N(d/2+1)
+}
+
+// forwardStream copies chunks to out until the stream ends, reporting whether
+// any chunk was sent and the error the stream ended with.
+func forwardStream(ctx context.Context, out chan<- string, chunks <-chan string, errs <-chan error) (bool, error) {
+	sent := false
+	for {
+		select {
+		case chunk, ok := <-chunks:
+			if !ok {
+				if errs == nil {
+					return sent, nil
+				}
+				return sent, <-errs
+			}
+			select {
+			case out <- chunk:
+				sent = true
+			case <-ctx.Done():
+				go Drain(chunks)
+				return sent, ctx.Err()
+			}
+		case err, ok := <-errs:
+			if !ok {
+				errs = nil
+				continue
+			}
+			go Drain(chunks)
+			return sent, err
+		case <-ctx.Done():
+			go Drain(chunks)
+			return sent, ctx.Err()
+		}
+	}
+}
+
+// Drain discards what is left of an abandoned stream so that its producer
+// is not blocked forever on a send nobody will receive.
+func Drain(chunks <-chan string) {
+	for range chunks {
+	}
+}
@@ -0,0 +1,130 @@
+package model
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/sokinpui/synapse.go/internal/config"
+	"google.golang.org/genai"
+)
+
+var errUnavailable = genai.APIError{Code: 503, Message: "unavailable"}
+
+// fakeLLM answers each call with the next of its errors, then with text. A
+// call may try several keys, each of which counts as an attempt as a
+// provider's key rotation does.
+type fakeLLM struct {
+	errs   []error
+	keys   int
+	calls  int
+	chunks []string
+}
+
+func (f *fakeLLM) next(ctx context.Context) error {
+	f.calls++
+	var lastErr error
+	for i := 0; i < max(f.keys, 1); i++ {
+		if i > 0 {
+			reportFrom(ctx).addAttempt()
+		}
+		if len(f.errs) == 0 {
+			return nil
+		}
+		lastErr, f.errs = f.errs[0], f.errs[1:]
+	}
+	return lastErr
+}
+
+func (f *fakeLLM) Generate(ctx context.Context, prompt string, images [][]byte, config *Config) (string, error) {
+	if err := f.next(ctx); err != nil {
+		return "", err
+	}
+	return "ok", nil
+}
+
+func (f *fakeLLM) GenerateStream(ctx context.Context, prompt string, images [][]byte, config *Config) (<-chan string, <-chan error) {
+	outCh := make(chan string)
+	errCh := make(chan error, 1)
+	err := f.next(ctx)
+	go func() {
+		defer close(outCh)
+		defer close(errCh)
+		for _, c := range f.chunks {
+			outCh <- c
+		}
+		if err != nil {
+			errCh <- err
+		}
+	}()
+	return outCh, errCh
+}
+
+func (f *fakeLLM) CountTokens(prompt string) (int, error) { return 0, nil }
+
+func testPolicy(attempts int) config.RetryPolicy {
+	return config.RetryPolicy{MaxAttempts: attempts, BaseDelay: time.Millisecond, MaxDelay: time.Millisecond}
+}
+
+func TestRetryRecoversFromTransientErrors(t *testing.T) {
+	fake := &fakeLLM{errs: []error{errUnavailable, errUnavailable}}
+	ctx, report := WithReport(context.Background())
+
+	text, err := withRetry(fake, "m", testPolicy(3)).Generate(ctx, "hi", nil, nil)
+	if err != nil || text != "ok" {
+		t.Fatalf("Generate = %q, %v; want ok", text, err)
+	}
+	if got := report.Attempts(); got != 3 {
+		t.Errorf("Attempts = %d, want 3", got)
+	}
+}
+
+func TestRetrySkipsPermanentErrors(t *testing.T) {
+	permanent := genai.APIError{Code: 400, Message: "bad request"}
+	fake := &fakeLLM{errs: []error{permanent}}
+
+	_, err := withRetry(fake, "m", testPolicy(3)).Generate(context.Background(), "hi", nil, nil)
+	if !errors.As(err, &genai.APIError{}) || fake.calls != 1 {
+		t.Fatalf("got %v after %d calls, want the 400 after 1 call", err, fake.calls)
+	}
+}
+
+func TestRetryCountsKeyRotations(t *testing.T) {
+	// Every key fails; each of the two rounds tries all three keys, and
+	// each key counts as an attempt without using up a round.
+	fake := &fakeLLM{keys: 3, errs: []error{
+		errUnavailable, errUnavailable, errUnavailable,
+		errUnavailable, errUnavailable, errUnavailable,
+	}}
+	ctx, report := WithReport(context.Background())
+
+	if _, err := withRetry(fake, "m", testPolicy(2)).Generate(ctx, "hi", nil, nil); err == nil {
+		t.Fatal("Generate succeeded, want an error")
+	}
+	if got := report.Attempts(); got != 6 {
+		t.Errorf("Attempts = %d, want 6", got)
+	}
+	if fake.calls != 2 {
+		t.Errorf("calls = %d, want 2", fake.calls)
+	}
+	if left := len(fake.errs); left != 0 {
+		t.Errorf("%d upstream calls made, want 6", 6-left)
+	}
+}
+
+func TestRetryStreamStopsAfterFirstChunk(t *testing.T) {
+	fake := &fakeLLM{chunks: []string{"partial"}, errs: []error{errUnavailable}}
+
+	chunks, errs := withRetry(fake, "m", testPolicy(3)).GenerateStream(context.Background(), "hi", nil, nil)
+	var got []string
+	for c := range chunks {
+		got = append(got, c)
+	}
+	if err := <-errs; err == nil {
+		t.Error("stream succeeded, want the upstream error")
+	}
+	if len(got) != 1 || fake.calls != 1 {
+		t.Errorf("got chunks %q after %d calls, want one chunk and no retry", got, fake.calls)
+	}
+}